@@ -0,0 +1,94 @@
+package bevtree
+
+// ActiveNode is a snapshot of running agent of node.
+type ActiveNode struct {
+	// The node.
+	Node Node
+
+	// The ID of node.
+	ID NodeID
+
+	// The path of node.
+	Path string
+
+	// The status of agent.
+	Status AgentStatus
+
+	// The lazy-stop state of agent.
+	LazyStop LazyStop
+
+	// The serial number of the latest update of agent.
+	LatestUpdateSeri uint32
+
+	// The running children.
+	Children []*ActiveNode
+}
+
+// Whether the agent is lazy-stopping.
+func (n *ActiveNode) LazyStopping() bool { return n.LazyStop != LazyStopNone }
+
+func newActiveNode(t Tree, a *agent) *ActiveNode {
+	n := &ActiveNode{
+		Node:             a.node,
+		ID:               t.NodeID(a.node),
+		Path:             NodePath(a.node),
+		Status:           a.getStatus(),
+		LazyStop:         a.getLZStop(),
+		LatestUpdateSeri: a.latestUpdateSeri,
+	}
+
+	for child := a.firstChild; child != nil; child = child.getNext() {
+		n.Children = append(n.Children, newActiveNode(t, child))
+	}
+
+	return n
+}
+
+// ActiveNodes returns the snapshot of running agents. The tree
+// from the root node is the first, the others are the lazy-stopping
+// agents detached from their parents. It must be called on the
+// goroutine updating the entity.
+func (e *entity) ActiveNodes() []*ActiveNode { return e.activeNodes(nil) }
+
+// Get the snapshot of running agents, including the tree of
+// extra which is out of the agent list, e.g. the updating one.
+func (e *entity) activeNodes(extra *agent) []*ActiveNode {
+	var roots []*agent
+	visited := map[*agent]bool{}
+
+	addRoot := func(a *agent) {
+		for a.getParent() != nil {
+			a = a.getParent()
+		}
+
+		if !visited[a] {
+			visited[a] = true
+			roots = append(roots, a)
+		}
+	}
+
+	if extra != nil {
+		addRoot(extra)
+	}
+
+	for elem := e.agentList.front(); elem != nil; elem = elem.getNext() {
+		if a, ok := elem.Value.(*agent); ok && a != nil {
+			addRoot(a)
+		}
+	}
+
+	// The tree from root node first.
+	for i, a := range roots {
+		if _, ok := a.node.(*rootNode); ok && i > 0 {
+			roots[0], roots[i] = roots[i], roots[0]
+			break
+		}
+	}
+
+	nodes := make([]*ActiveNode, len(roots))
+	for i, a := range roots {
+		nodes[i] = newActiveNode(e.ctx.Tree(), a)
+	}
+
+	return nodes
+}
@@ -28,7 +28,9 @@ type BevInstance interface {
 	// behavior terminate.
 	OnUpdate(Context) Result
 
-	// OnTerminate is called after the last update of the behavior.
+	// OnTerminate is called after the last update of the behavior,
+	// or when the behavior is aborted or stopped. Implement
+	// TerminateHandler to know the reason.
 	OnTerminate(Context)
 }
 
@@ -80,7 +82,16 @@ func (b *bevTask) OnUpdate(ctx Context) Result {
 }
 
 func (b *bevTask) OnTerminate(ctx Context) {
-	b.bevInst.OnTerminate(ctx)
+	b.OnTerminateWithReason(ctx, TerminateCompleted, Failure)
+}
+
+func (b *bevTask) OnTerminateWithReason(ctx Context, reason TerminateReason, result Result) {
+	if h, ok := b.bevInst.(TerminateHandler); ok {
+		h.OnTerminateWithReason(ctx, reason, result)
+	} else {
+		b.bevInst.OnTerminate(ctx)
+	}
+
 	b.bev.DestroyInstance(b.bevInst)
 	b.bevInst = nil
 	b.bev = nil
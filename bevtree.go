@@ -1,9 +1,12 @@
 package bevtree
 
 import (
+	"io/fs"
 	"path"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/GodYY/gutils/assert"
@@ -17,6 +20,14 @@ func (t NodeType) Valid() bool { return string(t) != "" }
 
 func (t NodeType) String() string { return string(t) }
 
+// Node ID, identifies a node in a tree. The zero value
+// indicates the ID is not assigned yet.
+type NodeID uint32
+
+func (id NodeID) Valid() bool { return id != 0 }
+
+func (id NodeID) String() string { return strconv.FormatUint(uint64(id), 10) }
+
 // Node represents the structure portion of behavior tree.
 // Node defines the basic function of node of behavior tree.
 // Nodes must implement the Node interface to be considered
@@ -57,59 +68,83 @@ func (n *node) SetParent(parent Node) {
 func (n *node) Comment() string           { return n.comment }
 func (n *node) SetComment(comment string) { n.comment = comment }
 
-// status indicate the status of node's runtime.
-type status int8
+// AgentStatus indicate the status of node's runtime.
+type AgentStatus int8
 
 const (
 	// The initail state.
-	sNone = status(iota)
+	AgentNone = AgentStatus(iota)
 
 	// Running.
-	sRunning
+	AgentRunning
 
 	// Terminated.
-	sTerminated
+	AgentTerminated
 
 	// Was stopped.
-	sStopped
+	AgentStopped
 
 	// Was destroyed.
-	sDestroyed
+	AgentDestroyed
 )
 
-// The strings represent the status values.
+// The strings represent the AgentStatus values.
 var statusStrings = [...]string{
-	sNone:       "none",
-	sRunning:    "running",
-	sTerminated: "terminated",
-	sStopped:    "stopped",
-	sDestroyed:  "destroyed",
+	AgentNone:       "none",
+	AgentRunning:    "running",
+	AgentTerminated: "terminated",
+	AgentStopped:    "stopped",
+	AgentDestroyed:  "destroyed",
 }
 
-func (s status) String() string { return statusStrings[s] }
+func (s AgentStatus) String() string { return statusStrings[s] }
+
+func (s AgentStatus) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *AgentStatus) UnmarshalText(text []byte) error {
+	for i, str := range statusStrings {
+		if str == string(text) {
+			*s = AgentStatus(i)
+			return nil
+		}
+	}
+	return errors.Errorf("invalid agent status \"%s\"", text)
+}
 
-// lazyStop indicate node's runtime how to stop.
-type lazyStop int8
+// LazyStop indicate node's runtime how to stop.
+type LazyStop int8
 
 const (
 	// Don't need to stop.
-	lzsNone = lazyStop(iota)
+	LazyStopNone = LazyStop(iota)
 
 	// Stop before update.
-	lzsBeforeUpdate
+	LazyStopBeforeUpdate
 
 	// Stop after update.
-	lzsAfterUpdate
+	LazyStopAfterUpdate
 )
 
-// The strings represent the lazyStop values.
+// The strings represent the LazyStop values.
 var lazyStopStrings = [...]string{
-	lzsNone:         "none",
-	lzsBeforeUpdate: "before-Update",
-	lzsAfterUpdate:  "after-Update",
+	LazyStopNone:         "none",
+	LazyStopBeforeUpdate: "before-Update",
+	LazyStopAfterUpdate:  "after-Update",
 }
 
-func (l lazyStop) String() string { return lazyStopStrings[l] }
+func (l LazyStop) String() string { return lazyStopStrings[l] }
+
+func (l LazyStop) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+
+func (l *LazyStop) UnmarshalText(text []byte) error {
+	for i, str := range lazyStopStrings {
+		if str == string(text) {
+			*l = LazyStop(i)
+			return nil
+		}
+	}
+	return errors.Errorf("invalid lazy stop \"%s\"", text)
+}
 
 // Result represents the running results of node's runtime and even behavior trees.
 type Result int8
@@ -140,6 +175,30 @@ var resultStrings = [...]string{
 
 func (r Result) String() string { return resultStrings[r] }
 
+// TerminateReason indicates why a task terminates.
+type TerminateReason int8
+
+const (
+	// The task completed, the last update or decision returned
+	// Success or Failure, or the initialization failed.
+	TerminateCompleted = TerminateReason(iota)
+
+	// The task was aborted by the parent, e.g. the parallel
+	// parent stops the running children once a child failed.
+	TerminateAborted
+
+	// The task was stopped by Entity.Stop or Entity.Release.
+	TerminateStopped
+)
+
+var terminateReasonStrings = [...]string{
+	TerminateCompleted: "completed",
+	TerminateAborted:   "aborted",
+	TerminateStopped:   "stopped",
+}
+
+func (r TerminateReason) String() string { return terminateReasonStrings[r] }
+
 // TaskType indicate how the task will run.
 type TaskType int8
 
@@ -198,6 +257,15 @@ type Task interface {
 	OnChildTerminated(result Result, nextChildNodes NodeList, ctx Context) Result
 }
 
+// TerminateHandler is an optional interface of Task and
+// BevInstance. If implemented, OnTerminateWithReason is called
+// instead of OnTerminate, with the reason of termination and
+// the final result. The result is Failure if the reason is not
+// TerminateCompleted.
+type TerminateHandler interface {
+	OnTerminateWithReason(ctx Context, reason TerminateReason, result Result)
+}
+
 // Root node, a special node in behavior tree. it has
 // only one child and no parent. It returns result of
 // child directly.
@@ -230,6 +298,18 @@ func (r *rootNode) SetChild(child Node) {
 	}
 }
 
+func (r *rootNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteChild(r.child)
+	return nil
+}
+
+func (r *rootNode) UnmarshalBTProperties(p PropertyReader) error {
+	if child := p.Child(); child != nil {
+		r.SetChild(child)
+	}
+	return nil
+}
+
 // rootNode Task.
 type rootTask struct {
 	node *rootNode
@@ -264,6 +344,17 @@ type Tree interface {
 	// Get tree comment.
 	Comment() string
 
+	// Get the ID of node in the tree, zero if not assigned.
+	NodeID(n Node) NodeID
+
+	// Find the node with id in the tree. Returns nil if
+	// not found.
+	NodeByID(id NodeID) Node
+
+	// Find the node at path in the tree, the path looks like
+	// "root/selector[2]/attack". Returns nil if not found.
+	NodeByPath(path string) (Node, error)
+
 	// Get root node.
 	root() *rootNode
 
@@ -281,15 +372,29 @@ type tree struct {
 	// The _root node of behavior tree.
 	_root *rootNode
 
+	// The IDs of nodes.
+	ids nodeIDs
+
 	internalImpl
 }
 
+// The IDs of nodes in a tree.
+type nodeIDs map[Node]NodeID
+
+// Set the valid ID of node, if ids is not nil.
+func (ids nodeIDs) set(n Node, id NodeID) {
+	if ids != nil && id.Valid() {
+		ids[n] = id
+	}
+}
+
 func NewTree(name string) *tree {
 	assert.AssertF(name != "", "invalid name \"%s\"", name)
 
 	tree := &tree{
 		name:  name,
 		_root: newRootNode(),
+		ids:   nodeIDs{},
 	}
 
 	return tree
@@ -309,52 +414,246 @@ func (t *tree) Root() *rootNode { return t._root }
 
 func (t *tree) root() *rootNode { return t._root }
 
+func (t *tree) NodeID(n Node) NodeID { return t.ids[n] }
+
+// SetNodeID sets the ID of node in the tree, the zero ID removes it.
+// IDs must be unique in the tree.
+func (t *tree) SetNodeID(n Node, id NodeID) {
+	if id.Valid() {
+		if t.ids == nil {
+			t.ids = nodeIDs{}
+		}
+		t.ids[n] = id
+	} else {
+		delete(t.ids, n)
+	}
+}
+
+func (t *tree) NodeByID(id NodeID) Node {
+	if !id.Valid() {
+		return nil
+	}
+
+	var found Node
+	walkNode(t._root, func(n Node) bool {
+		if t.ids[n] == id {
+			found = n
+			return false
+		}
+		return true
+	})
+
+	return found
+}
+
+func (t *tree) NodeByPath(path string) (Node, error) {
+	return findNodeByPath(t._root, path)
+}
+
+// AssignNodeIDs assigns IDs to the nodes that have no ID yet, and
+// forgets the IDs of nodes removed. The assigned IDs follow the
+// maximum ID in the tree, so the existing IDs keep stable. IDs must
+// be unique in the tree. The IDs are assigned when a tree is
+// loaded, the trees built must assign them to be traced by ID.
+func (t *tree) AssignNodeIDs() error {
+	ids, _, err := t.completeNodeIDs()
+	if err != nil {
+		return err
+	}
+
+	t.ids = ids
+	return nil
+}
+
+// Get the IDs of nodes in the tree with the missing ones assigned,
+// and the count of them. The tree is not changed.
+func (t *tree) completeNodeIDs() (nodeIDs, int, error) {
+	var maxID NodeID
+	ids := nodeIDs{}
+	nodes := map[NodeID]Node{}
+	var err error
+
+	walkNode(t._root, func(n Node) bool {
+		id := t.ids[n]
+		if !id.Valid() {
+			return true
+		}
+
+		if nodes[id] != nil {
+			err = errors.Errorf("tree \"%s\": duplicate node id %s", t.name, id)
+			return false
+		}
+
+		nodes[id] = n
+		ids[n] = id
+		if id > maxID {
+			maxID = id
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	missing := 0
+	walkNode(t._root, func(n Node) bool {
+		if !ids[n].Valid() {
+			maxID++
+			missing++
+			ids[n] = maxID
+		}
+		return true
+	})
+
+	return ids, missing, nil
+}
+
+// Assign IDs to the nodes that have no ID, returns the count of
+// them.
+func (t *tree) assignMissingNodeIDs() (int, error) {
+	ids, missing, err := t.completeNodeIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	t.ids = ids
+	return missing, nil
+}
+
 type treeAsset struct {
 	entry *TreeEntry
 	once  *sync.Once
 	tree  *tree
 }
 
+// Clock provides the current time for entities.
+type Clock interface {
+	Now() time.Time
+}
+
+// The default clock, uses the system time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
 type Framework struct {
+	// The serial number of entity ID. It is the first field for
+	// 64-bit alignment of atomic operations.
+	entitySeri uint64
+
 	*meta
 	initialized    bool
 	loadAll        bool
 	configPathRoot string
+	fsys           fs.FS
 	treeAssets     map[string]*treeAsset
+	clock          Clock
+	recover        bool
+	errorHandler   func(error)
+	observer       Observer
+	logger         Logger
+	logLevel       int32
+	metrics        *Metrics
+	profile        bool
+	profileLabels  sync.Map
+	debugger       *Debugger
+
+	treeMigrations   []XMLMigration
+	configMigrations []XMLMigration
 }
 
 func NewFramework() *Framework {
 	return &Framework{
-		meta: newMeta(),
+		meta:     newMeta(),
+		clock:    systemClock{},
+		logger:   stdLogger{},
+		logLevel: int32(defaultLogLevel()),
 	}
 }
 
-func (s *Framework) RegsiterNodeType(nodeType NodeType, nodeCreator func() Node, taskCreator func() Task) {
+// SetClock replaces the clock used by Entity.Update, e.g. with
+// a simulated clock in tests.
+func (s *Framework) SetClock(clock Clock) {
 	if s.initialized {
 		panic("bevtree framework initialized")
 	}
 
-	s.meta.RegisterNodeType(nodeType, nodeCreator, taskCreator)
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	s.clock = clock
 }
 
-func (s *Framework) RegisterBevType(bevType BevType, creator func() Bev) {
+func (s *Framework) now() time.Time { return s.clock.Now() }
+
+func (s *Framework) RegsiterNodeType(nodeType NodeType, nodeCreator func() Node, taskCreator func() Task, desc ...*NodeDescriptor) {
 	if s.initialized {
 		panic("bevtree framework initialized")
 	}
-	s.meta.RegisterBevType(bevType, creator)
+
+	s.meta.RegisterNodeType(nodeType, nodeCreator, taskCreator, desc...)
 }
 
+func (s *Framework) RegisterBevType(bevType BevType, creator func() Bev, desc ...*BevDescriptor) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+	s.meta.RegisterBevType(bevType, creator, desc...)
+}
+
+// Init initializes the framework with the config file, and the tree
+// files in it are relative to the directory of config file. The cfgPath
+// can be a bundle written by Exporter.ExportBundle too, the files in it
+// are verified before loading.
 func (s *Framework) Init(cfgPath string) error {
+	if isBundleFile(cfgPath) {
+		b, err := OpenBundle(cfgPath)
+		if err != nil {
+			s.log(LogError, "open bundle failed", LogField{Key: LogKeyPath, Value: cfgPath}, LogField{Key: LogKeyError, Value: err})
+			return errors.WithMessage(err, "bevtree framework init")
+		}
+
+		return s.InitBundle(b)
+	}
+
+	return s.init(nil, cfgPath)
+}
+
+// InitFS works like Init, but reads the config file and tree files
+// from fsys, e.g. embed.FS or zip.Reader. The paths are slash-separated
+// as fs.FS requires.
+func (s *Framework) InitFS(fsys fs.FS, cfgPath string) error {
+	if fsys == nil {
+		return errors.New("bevtree framework init: fsys nil")
+	}
+
+	return s.init(fsys, cfgPath)
+}
+
+func (s *Framework) init(fsys fs.FS, cfgPath string) error {
 	if s.initialized {
 		return errors.New("bevtree framework repeated initialization")
 	}
 
-	config, err := loadConfig(cfgPath)
+	var config *Config
+	var err error
+	if fsys != nil {
+		config, err = s.loadConfigFS(fsys, cfgPath)
+	} else {
+		config, err = s.loadConfig(cfgPath)
+	}
 	if err != nil {
+		s.log(LogError, "load config failed", LogField{Key: LogKeyPath, Value: cfgPath}, LogField{Key: LogKeyError, Value: err})
 		return errors.WithMessagef(err, "bevtree framework init")
 	}
 
+	s.log(LogInfo, "config loaded", LogField{Key: LogKeyPath, Value: cfgPath}, LogField{Key: "trees", Value: len(config.TreeEntries)}, LogField{Key: "loadall", Value: config.LoadAll})
+
 	s.configPathRoot = path.Dir(cfgPath)
+	s.fsys = fsys
 	s.treeAssets = make(map[string]*treeAsset, len(config.TreeEntries))
 	s.loadAll = config.LoadAll
 
@@ -364,16 +663,19 @@ func (s *Framework) Init(cfgPath string) error {
 			if s.loadAll {
 				tree := new(tree)
 				path := path.Join(s.configPathRoot, entry.Path)
-				if err = s.DecodeXMLTreeFile(path, tree); err == nil {
+				if err = s.decodeTreeFile(path, entry.format(), tree); err == nil {
 					if tree.Name() != entry.Name {
 						err = errors.Errorf("load tree \"%s\": name don't match config name \"%s\"", tree.Name(), entry.Name)
 					}
 				}
 
 				if err != nil {
+					s.log(LogError, "load tree failed", LogField{Key: LogKeyTree, Value: entry.Name}, LogField{Key: LogKeyPath, Value: path}, LogField{Key: LogKeyError, Value: err})
 					return errors.WithMessage(err, "bevtree framework init")
 				}
 
+				s.log(LogInfo, "tree loaded", LogField{Key: LogKeyTree, Value: entry.Name}, LogField{Key: LogKeyPath, Value: path})
+
 				ta = &treeAsset{entry: entry, tree: tree}
 			} else {
 				ta = &treeAsset{entry: entry, once: new(sync.Once)}
@@ -399,12 +701,16 @@ func (s *Framework) loadTree(ta *treeAsset) (*tree, error) {
 		tree := new(tree)
 
 		path := path.Join(s.configPathRoot, ta.entry.Path)
-		if err = s.DecodeXMLTreeFile(path, tree); err != nil {
+		if err = s.decodeTreeFile(path, ta.entry.format(), tree); err != nil {
+			s.log(LogError, "load tree failed", LogField{Key: LogKeyTree, Value: ta.entry.Name}, LogField{Key: LogKeyPath, Value: path}, LogField{Key: LogKeyError, Value: err})
 			return
 		}
 
 		if tree.Name() != ta.entry.Name {
 			err = errors.Errorf("loadTree \"%s\": tree name don't match config name \"%s\"", tree.Name(), ta.entry.Name)
+			s.log(LogError, "load tree failed", LogField{Key: LogKeyTree, Value: ta.entry.Name}, LogField{Key: LogKeyPath, Value: path}, LogField{Key: LogKeyError, Value: err})
+		} else {
+			s.log(LogInfo, "tree loaded", LogField{Key: LogKeyTree, Value: ta.entry.Name}, LogField{Key: LogKeyPath, Value: path})
 		}
 
 		atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&ta.tree)), unsafe.Pointer(tree))
@@ -427,6 +733,16 @@ func (s *Framework) GetOrLoadTree(name string) (Tree, error) {
 	return s.getOrLoadTree(name)
 }
 
+// Get the tree loaded, nil if not found or not loaded yet.
+func (s *Framework) loadedTree(name string) *tree {
+	ta := s.treeAssets[name]
+	if ta == nil {
+		return nil
+	}
+
+	return (*tree)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&ta.tree))))
+}
+
 func (s *Framework) getOrLoadTree(name string) (*tree, error) {
 	ta := s.treeAssets[name]
 	if ta == nil {
@@ -450,7 +766,7 @@ func (s *Framework) getOrLoadTree(name string) (*tree, error) {
 	}
 }
 
-func (s *Framework) CreateEntity(treeName string, userData interface{}) (Entity, error) {
+func (s *Framework) CreateEntity(treeName string, userData interface{}, opts ...EntityOption) (Entity, error) {
 	if !s.initialized {
 		return nil, errors.New("bevtree framework uninitialized")
 	}
@@ -461,6 +777,6 @@ func (s *Framework) CreateEntity(treeName string, userData interface{}) (Entity,
 	} else if tree == nil {
 		return nil, errors.Errorf("bevtree framework CreateEntity: tree \"%s\" not exist", treeName)
 	} else {
-		return newEntity(newContext(s, tree, userData)), nil
+		return newEntity(newContext(s, tree, userData, newEntityOptions(opts))), nil
 	}
 }
@@ -1,14 +1,27 @@
 package bevtree
 
 import (
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/GodYY/gutils/assert"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func (s *Framework) addTree(tree *tree) {
@@ -916,3 +929,1454 @@ func TestWeightSelector(t *testing.T) {
 		}
 	}
 }
+
+func TestNodeIDAndPath(t *testing.T) {
+	tree := NewTree("test node id and path")
+
+	seq := NewSequenceNode()
+	tree.Root().SetChild(seq)
+
+	selc0 := NewSelectorNode()
+	selc1 := NewSelectorNode()
+	seq.AddChild(selc0)
+	seq.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Success })))
+	seq.AddChild(selc1)
+
+	incr := NewBevNode(newBehaviorIncr("key", 1))
+	selc1.AddChild(NewInverterNode())
+	selc1.AddChild(incr)
+
+	if err := tree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[NodeID]bool{}
+	walkNode(tree.Root(), func(n Node) bool {
+		id := tree.NodeID(n)
+		if !id.Valid() || ids[id] {
+			t.Fatalf("invalid or duplicate id %s of %s", id, NodePath(n))
+		}
+		ids[id] = true
+
+		if tree.NodeByID(id) != n {
+			t.Fatalf("NodeByID(%s) mismatch", id)
+		}
+
+		if found, err := tree.NodeByPath(NodePath(n)); err != nil || found != n {
+			t.Fatalf("NodeByPath(%s) mismatch: %v", NodePath(n), err)
+		}
+		return true
+	})
+
+	if path := NodePath(incr); path != "root/sequence/selector[1]/incr" {
+		t.Fatalf("unexpected path %s", path)
+	}
+
+	// IDs already assigned keep stable.
+	id := tree.NodeID(incr)
+	selc0.AddChild(NewSucceederNode())
+	if err := tree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.NodeID(incr) != id {
+		t.Fatalf("id changed from %s to %s", id, tree.NodeID(incr))
+	}
+
+	if n, _ := tree.NodeByPath("root/sequence/selector[2]"); n != nil {
+		t.Fatal("found node at non-existent path")
+	}
+
+	if _, err := tree.NodeByPath("root/sequence/selector[x]"); err == nil {
+		t.Fatal("invalid path should return error")
+	}
+
+	tree.SetNodeID(selc1.Child(0), id)
+	if err := tree.AssignNodeIDs(); err == nil {
+		t.Fatal("duplicate id should return error")
+	}
+}
+
+func TestRandSeed(t *testing.T) {
+	framework := newTestFramework()
+
+	tree := NewTree("test rand seed")
+	framework.addTree(tree)
+
+	selc := NewRandSelectorNode()
+	tree.Root().SetChild(selc)
+
+	key := "order"
+	n := 10
+	for i := 0; i < n; i++ {
+		k := i
+		selc.AddChild(NewBevNode(newBevFunc(func(e Context) Result {
+			e.DataSet().Set(key, append(e.DataSet().Get(key).([]int), k))
+			return Failure
+		})))
+	}
+
+	runOrders := func(seed int64, times int) [][]int {
+		entity, err := framework.CreateEntity("test rand seed", nil, WithRandSeed(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entity.Release()
+
+		var orders [][]int
+		for i := 0; i < times; i++ {
+			entity.Context().DataSet().Set(key, []int{})
+			entity.Update()
+			orders = append(orders, entity.Context().DataSet().Get(key).([]int))
+		}
+		return orders
+	}
+
+	a, b := runOrders(42, 5), runOrders(42, 5)
+	for i := range a {
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				t.Fatalf("run %d: %v != %v", i, a[i], b[i])
+			}
+		}
+	}
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestClock(t *testing.T) {
+	framework := NewFramework()
+	framework.RegisterBevType(function, func() Bev { return new(bevFunc) })
+	clock := &testClock{now: time.Unix(1000, 0)}
+	framework.SetClock(clock)
+	framework.initialized = true
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("set clock after init should panic")
+			}
+		}()
+		framework.SetClock(nil)
+	}()
+
+	tree := NewTree("test clock")
+	framework.addTree(tree)
+
+	var deltas []time.Duration
+	tree.Root().SetChild(NewBevNode(newBevFunc(func(e Context) Result {
+		deltas = append(deltas, e.DeltaTime())
+		return Running
+	})))
+
+	entity, err := framework.CreateEntity("test clock", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	clock.now = clock.now.Add(100 * time.Millisecond)
+	entity.Update()
+	clock.now = clock.now.Add(50 * time.Millisecond)
+	entity.Update()
+	entity.UpdateDelta(20 * time.Millisecond)
+
+	expected := []time.Duration{100 * time.Millisecond, 50 * time.Millisecond, 20 * time.Millisecond}
+	for i, d := range expected {
+		if deltas[i] != d {
+			t.Fatalf("update %d: delta %v != %v", i, deltas[i], d)
+		}
+	}
+
+	ctx := entity.Context()
+	if ctx.ElapsedTime() != 170*time.Millisecond {
+		t.Fatalf("elapsed %v != %v", ctx.ElapsedTime(), 170*time.Millisecond)
+	}
+
+	if !ctx.Now().Equal(time.Unix(1000, 0).Add(170 * time.Millisecond)) {
+		t.Fatalf("unexpected now %v", ctx.Now())
+	}
+
+	entity.Stop()
+	if ctx.ElapsedTime() != 0 || ctx.DeltaTime() != 0 {
+		t.Fatal("time not reset on stop")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	framework := newTestFramework()
+	framework.recover = true
+
+	var errs []error
+	framework.errorHandler = func(err error) { errs = append(errs, err) }
+
+	tree := NewTree("test recover")
+	framework.addTree(tree)
+
+	paral := NewParallelNode()
+	tree.Root().SetChild(paral)
+
+	paral.AddChild(NewBevNode(newBehaviorUpdate(100)))
+
+	panicking := true
+	bomb := NewBevNode(newBevFunc(func(Context) Result {
+		if panicking {
+			panic("boom")
+		}
+		return Success
+	}))
+	seq := NewSequenceNode()
+	seq.AddChild(bomb)
+	paral.AddChild(seq)
+
+	entity, err := framework.CreateEntity("test recover", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	if r := entity.Update(); r != Failure {
+		t.Fatalf("should return %v but get %v", Failure, r)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error but get %d", len(errs))
+	}
+
+	perr, ok := errs[0].(*PanicError)
+	if !ok {
+		t.Fatalf("unexpected error %v", errs[0])
+	}
+
+	if perr.NodePath != NodePath(bomb) || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Fatalf("unexpected panic error %s", perr)
+	}
+
+	// The entity is still usable.
+	panicking = false
+	if r := entity.Update(); r != Running {
+		t.Fatalf("should return %v but get %v", Running, r)
+	}
+
+	entity.Stop()
+	if r := entity.Update(); r != Running {
+		t.Fatalf("should return %v but get %v", Running, r)
+	}
+}
+
+const panicking = BevType("panicking")
+
+// The bev panics in OnInit or OnUpdate, and counts the terminations.
+type bevPanicking struct {
+	inInit     bool
+	terminated int
+}
+
+func (bevPanicking) BevType() BevType { return panicking }
+
+func (b *bevPanicking) CreateInstance() BevInstance {
+	return &bevPanickingEntity{bevPanicking: b}
+}
+
+func (b *bevPanicking) DestroyInstance(BevInstance) {}
+
+type bevPanickingEntity struct {
+	*bevPanicking
+}
+
+func (b *bevPanickingEntity) BevType() BevType { return panicking }
+
+func (b *bevPanickingEntity) OnInit(Context) bool {
+	if b.inInit {
+		panic("init")
+	}
+	return true
+}
+
+func (b *bevPanickingEntity) OnUpdate(Context) Result { panic("update") }
+func (b *bevPanickingEntity) OnTerminate(Context)     { b.terminated++ }
+
+func TestRecoverInit(t *testing.T) {
+	framework := newTestFramework()
+	framework.recover = true
+
+	var errs []error
+	framework.errorHandler = func(err error) { errs = append(errs, err) }
+
+	for _, inInit := range []bool{true, false} {
+		bev := &bevPanicking{inInit: inInit}
+		tree := NewTree(fmt.Sprintf("test recover init %v", inInit))
+		framework.addTree(tree)
+		tree.Root().SetChild(NewBevNode(bev))
+
+		entity, err := framework.CreateEntity(tree.Name(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r := entity.Update(); r != Failure {
+			t.Fatalf("should return %v but get %v", Failure, r)
+		}
+		entity.Release()
+
+		// The task panicked in OnInit is not terminated.
+		if expected := map[bool]int{true: 0, false: 1}[inInit]; bev.terminated != expected {
+			t.Fatalf("panic in init %v: terminated %d times, want %d", inInit, bev.terminated, expected)
+		}
+	}
+
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors but get %d", len(errs))
+	}
+}
+
+const reasonRecord = BevType("reasonRecord")
+
+type terminateRecord struct {
+	reason TerminateReason
+	result Result
+}
+
+type bevReasonRecord struct {
+	result  Result
+	records *[]terminateRecord
+}
+
+func (bevReasonRecord) BevType() BevType { return reasonRecord }
+
+func (b *bevReasonRecord) CreateInstance() BevInstance {
+	return &bevReasonRecordEntity{bevReasonRecord: b}
+}
+
+func (b *bevReasonRecord) DestroyInstance(BevInstance) {}
+
+type bevReasonRecordEntity struct {
+	*bevReasonRecord
+}
+
+func (b *bevReasonRecordEntity) BevType() BevType        { return reasonRecord }
+func (b *bevReasonRecordEntity) OnInit(Context) bool     { return true }
+func (b *bevReasonRecordEntity) OnUpdate(Context) Result { return b.result }
+func (b *bevReasonRecordEntity) OnTerminate(Context)     { panic("shouldnt be invoked") }
+func (b *bevReasonRecordEntity) OnTerminateWithReason(_ Context, reason TerminateReason, result Result) {
+	*b.records = append(*b.records, terminateRecord{reason: reason, result: result})
+}
+
+func TestTerminateReason(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(reasonRecord, func() Bev { return new(bevReasonRecord) })
+
+	var records []terminateRecord
+
+	tree := NewTree("test terminate reason")
+	framework.addTree(tree)
+
+	paral := NewParallelNode()
+	tree.Root().SetChild(paral)
+
+	running := &bevReasonRecord{result: Running, records: &records}
+	paral.AddChild(NewBevNode(running))
+
+	failed := &bevReasonRecord{result: Failure, records: &records}
+	seq := NewSequenceNode()
+	seq.AddChild(NewBevNode(&bevReasonRecord{result: Success, records: &records}))
+	seq.AddChild(NewBevNode(failed))
+	paral.AddChild(seq)
+
+	entity, err := framework.CreateEntity("test terminate reason", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	if r := entity.Update(); r != Failure {
+		t.Fatalf("should return %v but get %v", Failure, r)
+	}
+
+	expected := []terminateRecord{
+		{TerminateCompleted, Success},
+		{TerminateCompleted, Failure},
+		{TerminateAborted, Failure},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("records %v != %v", records, expected)
+	}
+	for i := range expected {
+		if records[i] != expected[i] {
+			t.Fatalf("records %v != %v", records, expected)
+		}
+	}
+
+	records = records[:0]
+	failed.result = Running
+	entity.Update()
+	entity.Stop()
+
+	for _, r := range records {
+		if r != (terminateRecord{TerminateStopped, Failure}) && r != (terminateRecord{TerminateCompleted, Success}) {
+			t.Fatalf("unexpected record %v", r)
+		}
+	}
+	if len(records) != 3 {
+		t.Fatalf("unexpected records %v", records)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	framework := newTestFramework()
+
+	var events []Event
+	framework.observer = ObserverFunc(func(e *Event) { events = append(events, *e) })
+
+	tree := NewTree("test observer")
+	framework.addTree(tree)
+
+	inverter := NewInverterNode()
+	tree.Root().SetChild(inverter)
+	bev := NewBevNode(newBevFunc(func(Context) Result { return Failure }))
+	inverter.SetChild(bev)
+
+	entity, err := framework.CreateEntity("test observer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := entity.Update(); r != Success {
+		t.Fatalf("should return %v but get %v", Success, r)
+	}
+	entity.Release()
+
+	count := map[EventType]int{}
+	for _, e := range events {
+		count[e.Type]++
+	}
+
+	if count[EventEntityCreate] != 1 || count[EventEntityUpdate] != 1 || count[EventEntityRelease] != 1 {
+		t.Fatalf("unexpected entity events %v", count)
+	}
+
+	if count[EventAgentCreate] != 3 || count[EventAgentInit] != 3 || count[EventAgentDestroy] != 3 || count[EventAgentChildTerminated] != 2 {
+		t.Fatalf("unexpected agent events %v", count)
+	}
+
+	found := false
+	for _, e := range events {
+		if e.Type == EventAgentUpdate && e.Node == bev {
+			found = true
+			if e.Result != Failure || e.NodePath() != "root/inverter/func" || e.UpdateSeri != 1 {
+				t.Fatalf("unexpected event %v %s %v %d", e.Type, e.NodePath(), e.Result, e.UpdateSeri)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatal("update event of bev not found")
+	}
+
+	// Entity observer replaces the framework observer.
+	events = events[:0]
+	entity, _ = framework.CreateEntity("test observer", nil)
+	entity.SetObserver(nil)
+	entity.Update()
+	entity.Release()
+	if len(events) != 1 || events[0].Type != EventEntityCreate {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
+
+func TestObserverRelease(t *testing.T) {
+	framework := newTestFramework()
+
+	var events []Event
+	framework.observer = ObserverFunc(func(e *Event) { events = append(events, *e) })
+
+	tree := NewTree("test observer release")
+	framework.addTree(tree)
+	tree.Root().SetChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+
+	entity, err := framework.CreateEntity("test observer release", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := entity.Update(); r != Running {
+		t.Fatalf("should return %v but get %v", Running, r)
+	}
+	entity.Release()
+
+	// The agents running are stopped before the release event.
+	destroyed := 0
+	for _, e := range events[:len(events)-1] {
+		if e.Type == EventEntityRelease {
+			t.Fatal("release event is not the last")
+		}
+		if e.Type == EventAgentDestroy {
+			destroyed++
+		}
+	}
+
+	if last := events[len(events)-1]; last.Type != EventEntityRelease {
+		t.Fatalf("last event %v, want %v", last.Type, EventEntityRelease)
+	}
+
+	if destroyed != 2 {
+		t.Fatalf("%d agents destroyed, want 2", destroyed)
+	}
+}
+
+type logRecord struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type testLogger struct {
+	records []logRecord
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	r := logRecord{level: level, msg: msg, fields: map[string]interface{}{}}
+	for _, f := range fields {
+		r.fields[f.Key] = f.Value
+	}
+	l.records = append(l.records, r)
+}
+
+func TestLogger(t *testing.T) {
+	framework := newTestFramework()
+
+	logger := &testLogger{}
+	framework.logger = logger
+	framework.SetLogLevel(LogDebug)
+
+	logTree := NewTree("test logger")
+	framework.addTree(logTree)
+
+	inverter := NewInverterNode()
+	logTree.Root().SetChild(inverter)
+	inverter.SetChild(NewBevNode(newBevFunc(func(Context) Result { return Failure })))
+
+	entity, err := framework.CreateEntity("test logger", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity.Update()
+
+	found := false
+	for _, r := range logger.records {
+		if r.level != LogDebug {
+			t.Fatalf("unexpected log level %v", r.level)
+		}
+
+		if r.msg == "agent update" && r.fields[LogKeyNode] == "root/inverter/func" {
+			found = true
+			if r.fields[LogKeyTree] != "test logger" || r.fields[LogKeyEntity] != entity.ID() {
+				t.Fatalf("unexpected log fields %v", r.fields)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatal("agent update log of bev not found")
+	}
+
+	// Debug logs are filtered out.
+	logger.records = logger.records[:0]
+	framework.SetLogLevel(LogWarn)
+	entity.Update()
+	entity.Release()
+	if len(logger.records) != 0 {
+		t.Fatalf("unexpected logs %v", logger.records)
+	}
+
+	// The node IDs generated while decoding are logged at info level,
+	// and only for the trees decoded.
+	data := []byte(`<bevtree name="test logger"><root><child nodetype="inverter"></child></root></bevtree>`)
+	if err := framework.UnmarshalXMLTree(data, new(tree)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logger.records) != 0 {
+		t.Fatalf("unexpected logs %v", logger.records)
+	}
+
+	framework.SetLogLevel(LogInfo)
+	if err := framework.UnmarshalXMLTree(data, new(tree)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logger.records) != 1 || logger.records[0].level != LogInfo || logger.records[0].fields["count"] != 2 {
+		t.Fatalf("unexpected logs %v", logger.records)
+	}
+
+	logger.records = logger.records[:0]
+	data = []byte(`<bevtree name="test logger"><root><child nodetype="inverter"><child nodetype="unknown"></child></child></root></bevtree>`)
+	if err := framework.UnmarshalXMLTree(data, new(tree)); err == nil {
+		t.Fatal("tree with unknown node type decoded")
+	}
+
+	if len(logger.records) != 0 {
+		t.Fatalf("unexpected logs %v", logger.records)
+	}
+
+	// Zap adapter.
+	core, logs := observer.New(zap.InfoLevel)
+	zl := NewZapLogger(zap.New(core))
+	zl.Log(LogDebug, "debug")
+	zl.Log(LogWarn, "warn", LogField{Key: LogKeyTree, Value: "test logger"})
+	if logs.Len() != 1 || logs.All()[0].Level != zap.WarnLevel || logs.All()[0].ContextMap()[LogKeyTree] != "test logger" {
+		t.Fatalf("unexpected zap logs %v", logs.All())
+	}
+}
+
+func TestTraceRecorder(t *testing.T) {
+	framework := newTestFramework()
+
+	var buf bytes.Buffer
+	recorder := NewTraceRecorder(&buf, WithTraceDataSet())
+	framework.observer = recorder
+
+	traceTree := NewTree("test trace")
+	framework.addTree(traceTree)
+
+	seq := NewSequenceNode()
+	traceTree.Root().SetChild(seq)
+	seq.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		if ctx.DataSet().IncInt("count") < 2 {
+			return Running
+		}
+		return Success
+	})))
+	if err := traceTree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := framework.CreateEntity("test trace", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity.Context().DataSet().SetInt("count", 0)
+	entity.Update()
+	entity.Update()
+	entity.Release()
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewTraceReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []*TraceFrame
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+
+	if len(frames) != 3 || !frames[2].Released {
+		t.Fatalf("unexpected frames %d", len(frames))
+	}
+
+	f := FindTraceFrame(frames, entity.ID(), 1)
+	if f == nil || f.Tree != "test trace" || f.Result != Running {
+		t.Fatalf("unexpected frame %v", f)
+	}
+
+	var running []string
+	for _, n := range f.Running {
+		running = append(running, n.Path)
+	}
+	if strings.Join(running, ",") != "root,root/sequence,root/sequence/func" {
+		t.Fatalf("unexpected running nodes %v", running)
+	}
+
+	if len(f.DataSet) != 1 || f.DataSet[0] != (TraceDataChange{Key: "count", Value: "1"}) {
+		t.Fatalf("unexpected data changes %v", f.DataSet)
+	}
+
+	f = FindTraceFrame(frames, entity.ID(), 2)
+	if f == nil || f.Result != Success || len(f.Running) != 0 {
+		t.Fatalf("unexpected frame %v", f)
+	}
+
+	last := f.Events[len(f.Events)-1]
+	if last.Type != EventEntityUpdate || f.Node(last) != nil {
+		t.Fatalf("unexpected event %v", last)
+	}
+
+	// Append sessions to trace file.
+	path := filepath.Join(t.TempDir(), "test.trace")
+	for i := 0; i < 2; i++ {
+		recorder, err := CreateTraceFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entity, _ := framework.CreateEntity("test trace", nil)
+		entity.SetObserver(recorder)
+		entity.Context().DataSet().SetInt("count", 0)
+		entity.Update()
+		if err := recorder.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames, err = ReadTraceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 2 || frames[0].Session != 0 || frames[1].Session != 1 {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+}
+
+func TestTraceReaderCorrupt(t *testing.T) {
+	for length, msg := range map[uint64]string{
+		1 << 40: "invalid string length",
+		1 << 30: io.ErrUnexpectedEOF.Error(),
+	} {
+		data := append([]byte(traceMagic), traceRecSession, traceRecTree, 0)
+		data = appendUvarint(data, length)
+		data = append(data, "name"...)
+
+		r, err := NewTraceReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.Next(); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("length %d: error %v, want %s", length, err, msg)
+		}
+	}
+}
+
+func TestTraceRecorderRelease(t *testing.T) {
+	framework := newTestFramework()
+
+	recorder := NewTraceRecorder(io.Discard)
+	framework.observer = recorder
+
+	traceTree := NewTree("test trace release")
+	framework.addTree(traceTree)
+	traceTree.Root().SetChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+	if err := traceTree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		entity, err := framework.CreateEntity("test trace release", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entity.Update()
+		entity.Release()
+	}
+
+	if n := len(recorder.entities); n != 0 {
+		t.Fatalf("%d entities left in recorder after release", n)
+	}
+
+	// The nodes of tree reloaded are defined once.
+	nodes := len(recorder.nodes)
+	data, err := framework.MarshalXMLTree(traceTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := new(tree)
+	if err := framework.UnmarshalXMLTree(data, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	walkNode(reloaded.Root(), func(n Node) bool {
+		recorder.nodeIndex(reloaded, n)
+		return true
+	})
+	if len(recorder.nodes) != nodes || len(recorder.trees) != 1 {
+		t.Fatalf("nodes of tree reloaded defined again, %d nodes, %d trees", len(recorder.nodes), len(recorder.trees))
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entities are forgotten after the recording stopped.
+	recorder.err = errors.New("test error")
+	entity, err := framework.CreateEntity("test trace release", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity.Update()
+	entity.Release()
+
+	if n := len(recorder.entities); n != 0 {
+		t.Fatalf("%d entities left in recorder stopped", n)
+	}
+}
+
+// The async span of Chrome trace.
+type chromeTraceTestSpan struct {
+	name   string
+	path   string
+	tid    uint64
+	dur    float64
+	result interface{}
+}
+
+// Read the spans of Chrome trace in the order of end.
+func readChromeTraceSpans(t *testing.T, data []byte) []chromeTraceTestSpan {
+	t.Helper()
+
+	var events []chromeTraceEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		t.Fatal(err, string(data))
+	}
+
+	var spans []chromeTraceTestSpan
+	open := map[uint64][]chromeTraceEvent{}
+	for _, e := range events {
+		switch e.Ph {
+		case "B":
+			open[e.Tid] = append(open[e.Tid], e)
+		case "E":
+			stack := open[e.Tid]
+			if len(stack) == 0 || stack[len(stack)-1].Name != e.Name {
+				t.Fatalf("unmatched end event %v", e)
+			}
+			b := stack[len(stack)-1]
+			open[e.Tid] = stack[:len(stack)-1]
+			spans = append(spans, chromeTraceTestSpan{
+				name:   e.Name,
+				path:   b.Args["path"].(string),
+				tid:    e.Tid,
+				dur:    e.Ts - b.Ts,
+				result: e.Args["result"],
+			})
+		}
+	}
+
+	for tid, stack := range open {
+		if len(stack) > 0 {
+			t.Fatalf("spans of thread %d not ended: %v", tid, stack)
+		}
+	}
+
+	return spans
+}
+
+func TestChromeTrace(t *testing.T) {
+	framework := newTestFramework()
+	clock := &testClock{now: time.Unix(1000, 0)}
+	framework.initialized = false
+	framework.SetClock(clock)
+	framework.initialized = true
+
+	var buf bytes.Buffer
+	exporter := NewChromeTraceExporter(&buf)
+	framework.observer = exporter
+
+	chromeTree := NewTree("test chrome trace")
+	framework.addTree(chromeTree)
+
+	seq := NewSequenceNode()
+	chromeTree.Root().SetChild(seq)
+	seq.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		if ctx.UpdateSeri() < 2 {
+			return Running
+		}
+		return Success
+	})))
+
+	entity, err := framework.CreateEntity("test chrome trace", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The time is of entity, not the clock.
+	entity.Update()
+	entity.UpdateDelta(10 * time.Millisecond)
+	entity.Release()
+
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	durs := map[string]float64{}
+	for _, s := range readChromeTraceSpans(t, buf.Bytes()) {
+		if s.result != Success.String() {
+			t.Fatalf("unexpected span %v", s)
+		}
+		durs[s.path] = s.dur
+	}
+
+	if len(durs) != 3 || durs["root"] != 10000 || durs["root/sequence"] != 10000 || durs["root/sequence/func"] != 10000 {
+		t.Fatalf("unexpected durations %v", durs)
+	}
+
+	// The concurrent children of parallel node are on different
+	// threads.
+	paralTree := NewTree("test chrome trace parallel")
+	framework.addTree(paralTree)
+
+	paral := NewParallelNode()
+	paralTree.Root().SetChild(paral)
+	paral.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+	paral.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+
+	buf.Reset()
+	exporter = NewChromeTraceExporter(&buf)
+	framework.observer = exporter
+
+	entity, err = framework.CreateEntity("test chrome trace parallel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity.Update()
+	clock.now = clock.now.Add(10 * time.Millisecond)
+
+	// The running agents end at close.
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entity.Release()
+
+	tids := map[string]uint64{}
+	for _, s := range readChromeTraceSpans(t, buf.Bytes()) {
+		tids[s.path] = s.tid
+	}
+
+	if len(tids) != 4 || tids["root"] != entity.ID() || tids["root/parallel"] != entity.ID() || tids["root/parallel/func"] == tids["root/parallel/func[1]"] {
+		t.Fatalf("unexpected threads %v", tids)
+	}
+
+	// Convert trace frames.
+	frames := []*TraceFrame{
+		{Entity: 1, Time: time.Unix(1000, 0), Events: []TraceEvent{{Type: EventAgentCreate, Node: 1}, {Type: EventAgentCreate, Node: 2}}},
+		{Entity: 1, UpdateSeri: 1, Time: time.Unix(1001, 0), Events: []TraceEvent{{Type: EventAgentDestroy, Node: 1}}},
+	}
+	nodes := []*TraceNode{{Tree: "t", NodeType: root, Path: "root"}, {Tree: "t", NodeType: sequence, Path: "root/sequence"}}
+	for _, f := range frames {
+		f.nodes = nodes
+	}
+
+	buf.Reset()
+	if err := WriteChromeTrace(&buf, frames); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range readChromeTraceSpans(t, buf.Bytes()) {
+		names = append(names, fmt.Sprintf("%s:%v", s.name, s.dur))
+	}
+	if strings.Join(names, ",") != "sequence:1e+06,root:1e+06" {
+		t.Fatalf("unexpected events %v", names)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	framework := newTestFramework()
+	metrics := NewMetrics()
+	framework.initialized = false
+	framework.SetMetrics(metrics)
+	framework.initialized = true
+
+	metricsTree := NewTree("test metrics")
+	framework.addTree(metricsTree)
+
+	selector := NewSelectorNode()
+	metricsTree.Root().SetChild(selector)
+	selector.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Failure })))
+	selector.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		if ctx.UpdateSeri() == 2 {
+			return Success
+		}
+		return Running
+	})))
+	if err := metricsTree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := framework.CreateEntity("test metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// func[1] runs, succeeds, then runs again and stopped.
+	entity.Update()
+	entity.Update()
+	entity.Update()
+	entity.Stop()
+	entity.Release()
+
+	counts := map[string][4]uint64{}
+	for _, n := range metrics.Nodes() {
+		if n.Tree != "test metrics" || !n.ID.Valid() {
+			t.Fatalf("unexpected node metrics %v", n)
+		}
+		counts[n.Path] = [4]uint64{n.Inits, n.Successes, n.Failures, n.Aborts}
+	}
+
+	expected := map[string][4]uint64{
+		"root/selector/func":    {2, 0, 2, 0},
+		"root/selector/func[1]": {2, 1, 0, 1},
+	}
+	for path, c := range expected {
+		if counts[path] != c {
+			t.Fatalf("%s: expected %v but get %v", path, c, counts[path])
+		}
+	}
+
+	if h := metrics.EntityUpdate(); h.Count != 3 || h.Counts[len(h.Counts)-1] != 3 {
+		t.Fatalf("unexpected entity update histogram %v", h)
+	}
+
+	// The nodes of tree reloaded share the metrics.
+	nodes := len(metrics.Nodes())
+	data, err := framework.MarshalXMLTree(metricsTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := new(tree)
+	if err := framework.UnmarshalXMLTree(data, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	node, _ := reloaded.NodeByPath("root/selector/func[1]")
+	if nm := metrics.node(reloaded, node); nm.inits != 2 || len(metrics.Nodes()) != nodes {
+		t.Fatalf("metrics of node reloaded not shared, %d inits, %d nodes", nm.inits, len(metrics.Nodes()))
+	}
+
+	pools := metrics.Pools()
+	if pools[0].Name != "agent" || pools[0].Gets == 0 || pools[0].HitRate() < 0 || pools[0].HitRate() > 1 {
+		t.Fatalf("unexpected pool metrics %v", pools[0])
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`bevtree_node_aborts_total{tree="test metrics",node_id="4",node_type="behavior",path="root/selector/func[1]"} 1`,
+		`bevtree_entity_update_seconds_count 3`,
+		`bevtree_pool_gets_total{pool="task:selector"}`,
+		`# TYPE bevtree_node_update_seconds histogram`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("%q not found in:\n%s", line, body)
+		}
+	}
+}
+
+func TestProfileLabels(t *testing.T) {
+	framework := newTestFramework()
+	framework.profile = true
+
+	profileTree := NewTree("test profile")
+	framework.addTree(profileTree)
+
+	// The labels are written in the goroutine profile.
+	var profile bytes.Buffer
+	profileTree.Root().SetChild(NewBevNode(newBevFunc(func(Context) Result {
+		pprof.Lookup("goroutine").WriteTo(&profile, 1)
+		return Success
+	})))
+
+	entity, err := framework.CreateEntity("test profile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity.Update()
+	entity.Release()
+
+	labels := fmt.Sprintf(`"%s":"%s"`, ProfileLabelBevType, function)
+	if !strings.Contains(profile.String(), labels) {
+		t.Fatalf("labels %s not found in:\n%s", labels, profile.String())
+	}
+
+	// The labels are cleared after callback.
+	profile.Reset()
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	if strings.Contains(profile.String(), labels) {
+		t.Fatal("labels not cleared")
+	}
+
+	// The labels of caller are kept.
+	callerLabels := `"caller":"test"`
+	pprof.Do(gocontext.Background(), pprof.Labels("caller", "test"), func(callerCtx gocontext.Context) {
+		entity, err := framework.CreateEntity("test profile", nil, WithProfileContext(callerCtx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entity.Release()
+
+		profile.Reset()
+		entity.Update()
+		if s := profile.String(); !strings.Contains(s, labels) || !strings.Contains(s, callerLabels) {
+			t.Fatalf("labels of callback and caller not found in:\n%s", s)
+		}
+
+		profile.Reset()
+		pprof.Lookup("goroutine").WriteTo(&profile, 1)
+		if s := profile.String(); strings.Contains(s, labels) || !strings.Contains(s, callerLabels) {
+			t.Fatalf("labels of caller not restored:\n%s", s)
+		}
+	})
+
+	// The labels of subtree node are restored after the callbacks of
+	// subtree.
+	entity, err = framework.CreateEntity("test profile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	outer := entity.Context().(*context)
+	subtreeNode := NewSubtreeNode(profileTree, false)
+	outer.setProfileLabels(subtreeNode)
+
+	inner := outer.cloneWithTree(profileTree, false).(*context)
+	inner.setProfileLabels(profileTree.Root().Child())
+	inner.clearProfileLabels()
+
+	profile.Reset()
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	outer.clearProfileLabels()
+
+	subtreeLabels := fmt.Sprintf(`"%s":"%s"`, ProfileLabelNodeType, subtree)
+	if s := profile.String(); strings.Contains(s, labels) || !strings.Contains(s, subtreeLabels) {
+		t.Fatalf("labels of subtree node not restored:\n%s", s)
+	}
+}
+
+func TestActiveNodes(t *testing.T) {
+	framework := newTestFramework()
+
+	activeTree := NewTree("test active nodes")
+	framework.addTree(activeTree)
+
+	paral := NewParallelNode()
+	activeTree.Root().SetChild(paral)
+	inverter := NewInverterNode()
+	paral.AddChild(inverter)
+	inverter.SetChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+	paral.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Running })))
+
+	entity, err := framework.CreateEntity("test active nodes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	if nodes := entity.ActiveNodes(); len(nodes) != 0 {
+		t.Fatalf("unexpected active nodes %v", nodes)
+	}
+
+	entity.Update()
+
+	var paths []string
+	var walk func(n *ActiveNode)
+	walk = func(n *ActiveNode) {
+		if n.Status != AgentRunning || n.LazyStopping() || n.LatestUpdateSeri != 1 {
+			t.Fatalf("%s: unexpected status %s %s %d", n.Path, n.Status, n.LazyStop, n.LatestUpdateSeri)
+		}
+
+		paths = append(paths, n.Path)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+
+	nodes := entity.ActiveNodes()
+	if len(nodes) != 1 || nodes[0].Node != activeTree.Root() {
+		t.Fatalf("unexpected active nodes %v", nodes)
+	}
+	walk(nodes[0])
+
+	if strings.Join(paths, ",") != "root,root/parallel,root/parallel/inverter,root/parallel/inverter/func,root/parallel/func" {
+		t.Fatalf("unexpected active nodes %v", paths)
+	}
+
+	entity.Stop()
+	if nodes := entity.ActiveNodes(); len(nodes) != 0 {
+		t.Fatalf("unexpected active nodes after stop %v", nodes)
+	}
+}
+
+type testDebugClient struct {
+	t      *testing.T
+	conn   net.Conn
+	dec    *json.Decoder
+	id     int64
+	events []*debugMessage
+}
+
+func (c *testDebugClient) read() *debugMessage {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg debugMessage
+	if err := c.dec.Decode(&msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return &msg
+}
+
+func (c *testDebugClient) call(req debugRequest) *debugMessage {
+	c.id++
+	req.ID = c.id
+	if err := json.NewEncoder(c.conn).Encode(&req); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.read()
+		if msg.ID == req.ID {
+			if !msg.OK {
+				c.t.Fatalf("%s: %s", req.Cmd, msg.Error)
+			}
+			return msg
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+func (c *testDebugClient) waitEvent(event string) *debugMessage {
+	for i, msg := range c.events {
+		if msg.Event == event {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return msg
+		}
+	}
+
+	for {
+		if msg := c.read(); msg.Event == event {
+			return msg
+		}
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	framework := newTestFramework()
+	debugger := NewDebugger()
+	framework.debugger = debugger
+	defer debugger.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go debugger.Serve(l)
+
+	debugTree := NewTree("test debugger")
+	framework.addTree(debugTree)
+
+	seq := NewSequenceNode()
+	debugTree.Root().SetChild(seq)
+	seq.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		ctx.DataSet().IncInt("a")
+		return Success
+	})))
+	seq.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		if b, _ := ctx.DataSet().GetInt("b"); b == 5 {
+			return Success
+		}
+		return Failure
+	})))
+	if err := debugTree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &testDebugClient{t: t, conn: conn, dec: json.NewDecoder(conn)}
+
+	c.call(debugRequest{Cmd: "break", Tree: "test debugger", Node: 4})
+
+	entity, err := framework.CreateEntity("test debugger", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity.Context().DataSet().SetInt("a", 0)
+	entity.Context().DataSet().SetInt("b", 0)
+	entity.Context().DataSet().Set("c", complex(1, 2))
+
+	if msg := c.call(debugRequest{Cmd: "entities"}); !strings.Contains(fmt.Sprint(msg.Result), "test debugger") {
+		t.Fatalf("unexpected entities %v", msg.Result)
+	}
+
+	if msg := c.call(debugRequest{Cmd: "tree", Entity: entity.ID()}); !strings.Contains(fmt.Sprint(msg.Result), "root/sequence/func[1]") {
+		t.Fatalf("unexpected tree %v", msg.Result)
+	}
+
+	results := make(chan Result, 1)
+	go func() { results <- entity.Update() }()
+
+	// Paused before the second behavior.
+	msg := c.waitEvent(debugEventPaused)
+	if s := msg.Snapshot; msg.Entity != entity.ID() || s.Paused == nil || s.Paused.Path != "root/sequence/func[1]" || string(s.DataSet["a"]) != "1" || string(s.DataSet["c"]) != `"(1+2i)"` {
+		t.Fatalf("unexpected paused snapshot %+v", s)
+	}
+
+	if len(msg.Snapshot.Nodes) != 1 || len(msg.Snapshot.Nodes[0].Children) != 1 || len(msg.Snapshot.Nodes[0].Children[0].Children) != 1 {
+		t.Fatalf("unexpected paused nodes %+v", msg.Snapshot.Nodes)
+	}
+
+	c.call(debugRequest{Cmd: "set", Entity: entity.ID(), Key: "b", Value: json.RawMessage("5")})
+	c.call(debugRequest{Cmd: "clear", Tree: "test debugger", Node: 4})
+	c.call(debugRequest{Cmd: "step", Entity: entity.ID()})
+
+	if r := <-results; r != Success {
+		t.Fatalf("should return %v but get %v", Success, r)
+	}
+
+	// Stepping pauses at the next agent, the root.
+	go func() { results <- entity.Update() }()
+	if msg := c.waitEvent(debugEventPaused); msg.Snapshot.Paused.Path != "root" {
+		t.Fatalf("unexpected paused node %v", msg.Snapshot.Paused)
+	}
+
+	c.call(debugRequest{Cmd: "watch", Entity: entity.ID()})
+	c.call(debugRequest{Cmd: "continue", Entity: entity.ID()})
+
+	if r := <-results; r != Success {
+		t.Fatalf("should return %v but get %v", Success, r)
+	}
+
+	if msg := c.waitEvent(debugEventSnapshot); string(msg.Snapshot.DataSet["a"]) != "2" || msg.Snapshot.UpdateSeri != 2 {
+		t.Fatalf("unexpected snapshot %+v", msg.Snapshot)
+	}
+
+	entity.Release()
+	c.waitEvent(debugEventReleased)
+}
+
+func TestViewer(t *testing.T) {
+	framework := newTestFramework()
+	debugger := NewDebugger()
+	framework.debugger = debugger
+	defer debugger.Close()
+
+	viewTree := NewTree("test viewer")
+	framework.addTree(viewTree)
+
+	sel := NewSelectorNode()
+	viewTree.Root().SetChild(sel)
+	sel.AddChild(NewBevNode(newBevFunc(func(Context) Result { return Failure })))
+	sel.AddChild(NewBevNode(newBevFunc(func(ctx Context) Result {
+		if ctx.UpdateSeri() == 1 {
+			return Success
+		}
+		return Running
+	})))
+	if err := viewTree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewViewerHandler(framework))
+	defer server.Close()
+
+	get := func(path string, v interface{}) string {
+		resp, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			t.Fatalf("get %s: %s", path, resp.Status)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != nil {
+			if err := json.Unmarshal(body, v); err != nil {
+				t.Fatalf("get %s: %v", path, err)
+			}
+		}
+
+		return string(body)
+	}
+
+	if page := get("/", nil); !strings.Contains(page, "viewer.js") {
+		t.Fatalf("unexpected page %q", page)
+	}
+
+	var names []string
+	if get("/api/trees", &names); len(names) != 1 || names[0] != "test viewer" {
+		t.Fatalf("unexpected trees %v", names)
+	}
+
+	var treeView struct {
+		Name string
+		Root *nodeView
+	}
+	get("/api/tree?name=test+viewer", &treeView)
+	if root := treeView.Root; root == nil || len(root.Children) != 1 || len(root.Children[0].Children) != 2 || root.Children[0].Children[1].Path != "root/selector/func[1]" {
+		t.Fatalf("unexpected tree %+v", treeView)
+	}
+
+	// The trees not loaded are not listed, and not loaded by requests.
+	framework.loadAll = false
+	lazy := &treeAsset{entry: &TreeEntry{Name: "test viewer lazy", Path: "lazy.xml"}, once: new(sync.Once)}
+	framework.treeAssets[lazy.entry.Name] = lazy
+	if get("/api/trees", &names); len(names) != 1 || names[0] != "test viewer" {
+		t.Fatalf("unexpected trees %v", names)
+	}
+	resp, err := server.Client().Get(server.URL + "/api/tree?name=test+viewer+lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || lazy.tree != nil {
+		t.Fatalf("get tree not loaded: %s", resp.Status)
+	}
+	delete(framework.treeAssets, lazy.entry.Name)
+	framework.loadAll = true
+
+	entity, err := framework.CreateEntity("test viewer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	// The snapshot is taken after the update following the first
+	// request.
+	var s *DebugSnapshot
+	if get(fmt.Sprintf("/api/entity?id=%d", entity.ID()), &s); s != nil {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+
+	entity.Update()
+
+	get(fmt.Sprintf("/api/entity?id=%d", entity.ID()), &s)
+	if s == nil || s.Results["root/selector/func"] != "failure" || s.Results["root/selector/func[1]"] != "success" || s.Results["root/selector"] != "success" {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+
+	// The latest results are kept, the nodes terminated in the latest
+	// update are listed.
+	entity.Update()
+
+	get(fmt.Sprintf("/api/entity?id=%d", entity.ID()), &s)
+	if s == nil || s.Results["root/selector"] != "success" || strings.Join(s.Terminated, ",") != "root/selector/func" {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+}
@@ -0,0 +1,785 @@
+package bevtree
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/fs"
+	"math"
+	"os"
+
+	"github.com/GodYY/gutils/assert"
+	"github.com/pkg/errors"
+)
+
+// The magic number of bevtree binary encoding.
+const binaryTreeMagic = "bevtreeB"
+
+// The version of bevtree binary encoding. It increases when the
+// encoding of built-in nodes changes.
+const BinaryTreeVersion = 1
+
+// The kinds of property in bevtree binary encoding.
+const (
+	binaryPropertyEnd = iota
+	binaryPropertyInt
+	binaryPropertyFloat
+	binaryPropertyBool
+	binaryPropertyString
+	binaryPropertyChild
+	binaryPropertyChildren
+)
+
+// BinaryMarshaler is the interface implemented by objects that can
+// marshal themselves into bevtree binary encoding.
+type BinaryMarshaler interface {
+	MarshalBTBinary(*BinaryEncoder) error
+}
+
+// BinaryUnmarshaler is the interface implemented by objects that
+// can unmarshal the bevtree binary encoding of themselves. The
+// data must be read in the order MarshalBTBinary wrote.
+type BinaryUnmarshaler interface {
+	UnmarshalBTBinary(*BinaryDecoder) error
+}
+
+// A BinaryEncoder writes the bevtree binary encoding to a buffer.
+// The node types and bev types are written once, then referred by
+// index.
+type BinaryEncoder struct {
+	framework *Framework
+	buf       []byte
+	symbols   map[string]uint64
+
+	// The IDs of nodes of the tree encoding.
+	ids nodeIDs
+}
+
+func newBinaryEncoder(framework *Framework) *BinaryEncoder {
+	assert.Assert(framework != nil, "framework nil")
+	return &BinaryEncoder{framework: framework, symbols: map[string]uint64{}}
+}
+
+func (e *BinaryEncoder) Framework() *Framework { return e.framework }
+
+func (e *BinaryEncoder) WriteUvarint(v uint64) { e.buf = appendUvarint(e.buf, v) }
+
+func (e *BinaryEncoder) WriteVarint(v int64) { e.buf = appendVarint(e.buf, v) }
+
+func (e *BinaryEncoder) WriteBool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *BinaryEncoder) WriteFloat32(v float32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *BinaryEncoder) WriteFloat64(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *BinaryEncoder) WriteString(s string) {
+	e.buf = appendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *BinaryEncoder) WriteBytes(b []byte) {
+	e.buf = appendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// Write s as symbol. A new symbol is written as 0 and the string,
+// then written as its index from 1.
+func (e *BinaryEncoder) writeSymbol(s string) {
+	if i, ok := e.symbols[s]; ok {
+		e.WriteUvarint(i)
+		return
+	}
+
+	e.WriteUvarint(0)
+	e.WriteString(s)
+	e.symbols[s] = uint64(len(e.symbols) + 1)
+}
+
+// EncodeElement writes the encoding of v. If v does not implement
+// BinaryMarshaler but PropertyMarshaler, or has fields tagged with
+// PropertyTagKey, the properties are written with their names.
+// Otherwise, its JSON members are written, see
+// JSONEncoder.EncodeElement.
+func (e *BinaryEncoder) EncodeElement(v interface{}) error {
+	if marshaler, ok := v.(BinaryMarshaler); ok {
+		return marshaler.MarshalBTBinary(e)
+	}
+
+	if marshaler, err := propertyMarshalerOf(v); err != nil {
+		return err
+	} else if marshaler != nil {
+		w := &binaryPropertyWriter{e: e}
+		if err := marshaler.MarshalBTProperties(w); err != nil {
+			return err
+		} else if w.err != nil {
+			return w.err
+		}
+
+		e.buf = append(e.buf, binaryPropertyEnd)
+		return nil
+	}
+
+	var data []byte
+	if _, ok := v.(JSONMarshaler); ok {
+		obj := NewJSONObject()
+		if err := newJSONEncoder(e.framework).EncodeElement(v, obj); err != nil {
+			return err
+		}
+		data, _ = obj.MarshalJSON()
+	} else {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	e.WriteBytes(data)
+	return nil
+}
+
+// EncodeNode writes the node type, ID and comment of n, then the
+// encoding of n.
+func (e *BinaryEncoder) EncodeNode(n Node) error {
+	if e.framework.getNodeMeta(n.NodeType()) == nil {
+		return errors.Errorf("meta of node type \"%s\" not found", n.NodeType().String())
+	}
+
+	e.writeSymbol(n.NodeType().String())
+	e.WriteUvarint(uint64(e.ids[n]))
+	e.WriteString(n.Comment())
+
+	return e.EncodeElement(n)
+}
+
+// The PropertyWriter of BinaryEncoder. A property is written as
+// its kind, name and value.
+type binaryPropertyWriter struct {
+	e   *BinaryEncoder
+	err error
+}
+
+func (w *binaryPropertyWriter) begin(kind byte, name string) {
+	w.e.buf = append(w.e.buf, kind)
+	w.e.writeSymbol(name)
+}
+
+func (w *binaryPropertyWriter) WriteInt(name string, v int64) {
+	w.begin(binaryPropertyInt, name)
+	w.e.WriteVarint(v)
+}
+
+func (w *binaryPropertyWriter) WriteFloat(name string, v float64) {
+	w.begin(binaryPropertyFloat, name)
+	w.e.WriteFloat64(v)
+}
+
+func (w *binaryPropertyWriter) WriteBool(name string, v bool) {
+	w.begin(binaryPropertyBool, name)
+	w.e.WriteBool(v)
+}
+
+func (w *binaryPropertyWriter) WriteString(name string, v string) {
+	w.begin(binaryPropertyString, name)
+	w.e.WriteString(v)
+}
+
+func (w *binaryPropertyWriter) WriteChild(child Node) {
+	if w.err != nil || child == nil {
+		return
+	}
+
+	w.e.buf = append(w.e.buf, binaryPropertyChild)
+	if err := w.e.EncodeNode(child); err != nil {
+		w.err = errors.WithMessage(err, "Marshal child")
+	}
+}
+
+func (w *binaryPropertyWriter) WriteChildren(count int, child func(int, PropertyWriter) Node) {
+	if w.err != nil || count == 0 {
+		return
+	}
+
+	w.e.buf = append(w.e.buf, binaryPropertyChildren)
+	w.e.WriteUvarint(uint64(count))
+
+	for i := 0; i < count; i++ {
+		var props propertyList
+		node := child(i, &props)
+		if props.err != nil {
+			w.err = errors.WithMessagef(props.err, "Marshal No.%d child", i)
+			return
+		}
+
+		for j, name := range props.names {
+			switch v := props.values[j].(type) {
+			case int64:
+				w.WriteInt(name, v)
+			case float64:
+				w.WriteFloat(name, v)
+			case bool:
+				w.WriteBool(name, v)
+			case string:
+				w.WriteString(name, v)
+			}
+		}
+		w.e.buf = append(w.e.buf, binaryPropertyEnd)
+
+		if err := w.e.EncodeNode(node); err != nil {
+			w.err = errors.WithMessagef(err, "Marshal No.%d child", i)
+			return
+		}
+	}
+}
+
+// A BinaryDecoder reads the bevtree binary encoding. The first
+// error is kept, the reads after return zero values, check it by
+// Err.
+type BinaryDecoder struct {
+	framework *Framework
+	data      []byte
+	off       int
+	err       error
+	symbols   []string
+
+	// The version of data.
+	version uint64
+
+	// The IDs of nodes of the tree decoding.
+	ids nodeIDs
+}
+
+func newBinaryDecoder(framework *Framework, data []byte) *BinaryDecoder {
+	assert.Assert(framework != nil, "framework nil")
+	return &BinaryDecoder{framework: framework, data: data}
+}
+
+func (d *BinaryDecoder) Framework() *Framework { return d.framework }
+
+// Version returns the version of the data decoding.
+func (d *BinaryDecoder) Version() uint64 { return d.version }
+
+// Err returns the first error occurred.
+func (d *BinaryDecoder) Err() error { return d.err }
+
+// Fail sets the error if no error occurred, with the offset.
+func (d *BinaryDecoder) Fail(err error) {
+	if d.err == nil {
+		d.err = errors.WithMessagef(err, "offset %d", d.off)
+	}
+}
+
+func (d *BinaryDecoder) ReadUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		d.Fail(errors.New("invalid uvarint"))
+		return 0
+	}
+
+	d.off += n
+	return v
+}
+
+func (d *BinaryDecoder) ReadVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.Fail(errors.New("invalid varint"))
+		return 0
+	}
+
+	d.off += n
+	return v
+}
+
+// Read the next n bytes.
+func (d *BinaryDecoder) next(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n > uint64(len(d.data)-d.off) {
+		d.Fail(errors.New("unexpected end of data"))
+		return nil
+	}
+
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b
+}
+
+func (d *BinaryDecoder) ReadBool() bool {
+	b := d.next(1)
+	if b == nil {
+		return false
+	}
+
+	switch b[0] {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.Fail(errors.Errorf("invalid bool %d", b[0]))
+		return false
+	}
+}
+
+func (d *BinaryDecoder) ReadFloat32() float32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+func (d *BinaryDecoder) ReadFloat64() float64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *BinaryDecoder) ReadString() string {
+	return string(d.next(d.ReadUvarint()))
+}
+
+// ReadBytes reads bytes, they refer to the data decoding.
+func (d *BinaryDecoder) ReadBytes() []byte {
+	return d.next(d.ReadUvarint())
+}
+
+func (d *BinaryDecoder) readSymbol() string {
+	i := d.ReadUvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	if i == 0 {
+		s := d.ReadString()
+		d.symbols = append(d.symbols, s)
+		return s
+	}
+
+	if i > uint64(len(d.symbols)) {
+		d.Fail(errors.Errorf("invalid symbol %d", i))
+		return ""
+	}
+
+	return d.symbols[i-1]
+}
+
+// DecodeElement reads the encoding of v. If v does not implement
+// BinaryUnmarshaler but PropertyUnmarshaler, or has fields tagged
+// with PropertyTagKey, the properties are read. Otherwise, its JSON
+// members are read, see JSONDecoder.DecodeElement.
+func (d *BinaryDecoder) DecodeElement(v interface{}) error {
+	if unmarshaler, ok := v.(BinaryUnmarshaler); ok {
+		if err := unmarshaler.UnmarshalBTBinary(d); err != nil {
+			return err
+		}
+		return d.err
+	}
+
+	if unmarshaler, err := propertyUnmarshalerOf(v); err != nil {
+		return err
+	} else if unmarshaler != nil {
+		r, err := d.readProperties()
+		if err != nil {
+			return err
+		}
+		return unmarshaler.UnmarshalBTProperties(r)
+	}
+
+	data := d.ReadBytes()
+	if d.err != nil {
+		return d.err
+	}
+
+	if _, ok := v.(JSONUnmarshaler); !ok {
+		return json.Unmarshal(data, v)
+	}
+
+	obj := NewJSONObject()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return err
+	}
+
+	return newJSONDecoder(d.framework).DecodeElement(v, obj)
+}
+
+// DecodeNode reads the node type, then creates the node with the
+// type to decode into it.
+func (d *BinaryDecoder) DecodeNode() (Node, error) {
+	nodeType := NodeType(d.readSymbol())
+	id := NodeID(d.ReadUvarint())
+	comment := d.ReadString()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	meta := d.framework.getNodeMeta(nodeType)
+	if meta == nil {
+		return nil, errors.Errorf("meta of node type %s not found", nodeType)
+	}
+
+	node := meta.createNode()
+	d.ids.set(node, id)
+	node.SetComment(comment)
+
+	if err := d.DecodeElement(node); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Read the properties until binaryPropertyEnd.
+func (d *BinaryDecoder) readProperties() (*propertyReader, error) {
+	r := newPropertyReader(d.framework)
+	for {
+		kind := d.next(1)
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		switch kind[0] {
+		case binaryPropertyEnd:
+			return r, nil
+
+		case binaryPropertyInt:
+			name := d.readSymbol()
+			r.values[name] = d.ReadVarint()
+
+		case binaryPropertyFloat:
+			name := d.readSymbol()
+			r.values[name] = d.ReadFloat64()
+
+		case binaryPropertyBool:
+			name := d.readSymbol()
+			r.values[name] = d.ReadBool()
+
+		case binaryPropertyString:
+			name := d.readSymbol()
+			r.values[name] = d.ReadString()
+
+		case binaryPropertyChild:
+			child, err := d.DecodeNode()
+			if err != nil {
+				return nil, errors.WithMessage(err, "Unmarshal child")
+			}
+			r.child = child
+
+		case binaryPropertyChildren:
+			childCount := d.readCount()
+			for i := 0; i < childCount && d.err == nil; i++ {
+				props, err := d.readProperties()
+				if err != nil {
+					return nil, errors.WithMessagef(err, "Unmarshal No.%d child", i)
+				}
+
+				child, err := d.DecodeNode()
+				if err != nil {
+					return nil, errors.WithMessagef(err, "Unmarshal No.%d child", i)
+				}
+
+				r.addChild(child, props)
+			}
+
+		default:
+			d.Fail(errors.Errorf("invalid property kind %d", kind[0]))
+		}
+
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+}
+
+// Read the count of elements, each takes one byte at least.
+func (d *BinaryDecoder) readCount() int {
+	n := d.ReadUvarint()
+	if n > uint64(len(d.data)-d.off) {
+		d.Fail(errors.Errorf("invalid count %d", n))
+		return 0
+	}
+	return int(n)
+}
+
+func MarshalBinaryTree(framework *Framework, t *tree) ([]byte, error) {
+	if framework == nil {
+		return nil, errors.New("marshal binary tree: framework nil")
+	}
+
+	if t == nil {
+		return nil, nil
+	}
+
+	e := newBinaryEncoder(framework)
+	e.buf = append(e.buf, binaryTreeMagic...)
+	e.WriteUvarint(BinaryTreeVersion)
+
+	if err := e.EncodeElement(t); err != nil {
+		return nil, errors.WithMessage(err, "marshal binary tree")
+	}
+
+	return e.buf, nil
+}
+
+func UnmarshalBinaryTree(framework *Framework, data []byte, t *tree) error {
+	if framework == nil {
+		return errors.New("unmarshal binary tree: framework nil")
+	}
+
+	if data == nil || t == nil {
+		return nil
+	}
+
+	if err := unmarshalBinaryTree(newBinaryDecoder(framework, data), t); err != nil {
+		return errors.WithMessage(err, "unmarshal binary tree")
+	}
+
+	return nil
+}
+
+func unmarshalBinaryTree(d *BinaryDecoder, t *tree) error {
+	if magic := d.next(uint64(len(binaryTreeMagic))); d.err != nil || string(magic) != binaryTreeMagic {
+		return errors.New("not bevtree binary encoding")
+	}
+
+	if d.version = d.ReadUvarint(); d.err != nil {
+		return d.err
+	} else if d.version == 0 || d.version > BinaryTreeVersion {
+		return errors.Errorf("unsupported version %d", d.version)
+	}
+
+	if err := d.DecodeElement(t); err != nil {
+		return err
+	}
+
+	if d.off != len(d.data) {
+		return errors.Errorf("%d bytes left", len(d.data)-d.off)
+	}
+
+	return nil
+}
+
+func EncodeBinaryTreeFile(framework *Framework, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("encode binary tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := MarshalBinaryTree(framework, t)
+	if err != nil {
+		return errors.WithMessagef(err, "encode binary tree file: \"%s\"", path)
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// DecodeBinaryTreeFS works like DecodeBinaryTreeFile but read the file
+// from fsys.
+func DecodeBinaryTreeFS(framework *Framework, fsys fs.FS, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("decode binary tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	if err := unmarshalBinaryTree(newBinaryDecoder(framework, data), t); err != nil {
+		return errors.WithMessagef(err, "decode binary tree file: \"%s\"", path)
+	}
+
+	return nil
+}
+
+func DecodeBinaryTreeFile(framework *Framework, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("decode binary tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := unmarshalBinaryTree(newBinaryDecoder(framework, data), t); err != nil {
+		return errors.WithMessagef(err, "decode binary tree file: \"%s\"", path)
+	}
+
+	return nil
+}
+
+// MarshalBinaryTree return an bevtree binary encoding of t.
+func (f *Framework) MarshalBinaryTree(t *tree) ([]byte, error) {
+	if data, err := MarshalBinaryTree(f, t); err != nil {
+		return nil, errors.WithMessage(err, "framework")
+	} else {
+		return data, nil
+	}
+}
+
+// UnmarshalBinaryTree parses the bevtree binary-encoded Tree
+// data and stores the result in the Tree pointed to by t.
+func (f *Framework) UnmarshalBinaryTree(data []byte, t *tree) error {
+	if err := UnmarshalBinaryTree(f, data, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// EncodeBinaryTreeFile works like MarshalBinaryTree but write
+// encoded data to file.
+func (f *Framework) EncodeBinaryTreeFile(path string, t *tree) error {
+	if err := EncodeBinaryTreeFile(f, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// DecodeBinaryTreeFile works like UnmarshalBinaryTree but read
+// encoded data from file.
+func (f *Framework) DecodeBinaryTreeFile(path string, t *tree) error {
+	if err := DecodeBinaryTreeFile(f, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// DecodeBinaryTreeFS works like DecodeBinaryTreeFile but read
+// the file from fsys.
+func (f *Framework) DecodeBinaryTreeFS(fsys fs.FS, path string, t *tree) error {
+	if err := DecodeBinaryTreeFS(f, fsys, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+func (t *tree) MarshalBTBinary(e *BinaryEncoder) error {
+	if t.name == "" {
+		return errors.New("Tree has no name")
+	}
+
+	// The missing IDs are assigned in the encoding only.
+	ids, _, err := t.completeNodeIDs()
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Marshal", t.name)
+	}
+	e.ids = ids
+
+	e.WriteString(t.name)
+	e.WriteString(t.comment)
+	e.WriteUvarint(uint64(ids[t._root]))
+
+	if err := e.EncodeElement(t._root); err != nil {
+		return errors.WithMessagef(err, "Tree %s Marshal root", t.name)
+	}
+
+	return nil
+}
+
+func (t *tree) UnmarshalBTBinary(d *BinaryDecoder) error {
+	t.name = d.ReadString()
+	t.comment = d.ReadString()
+	rootID := NodeID(d.ReadUvarint())
+	if d.Err() != nil {
+		return d.Err()
+	}
+
+	if t.name == "" {
+		return errors.New("tree has no name")
+	}
+
+	if t._root == nil {
+		t._root = newRootNode()
+	}
+	d.ids = nodeIDs{}
+	d.ids.set(t._root, rootID)
+
+	if err := d.DecodeElement(t._root); err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal root", t.name)
+	}
+
+	// The IDs are assigned on marshaling, but the custom nodes
+	// may not keep them.
+	t.ids = d.ids
+	if _, err := t.assignMissingNodeIDs(); err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal", t.name)
+	}
+
+	return nil
+}
+
+func (b *BevNode) MarshalBTBinary(e *BinaryEncoder) error {
+	bevType := b.bev.BevType()
+	if e.framework.getBevMeta(bevType) == nil {
+		return errors.Errorf("BevNode Marshal: meta of bev type \"%s\" not found", bevType.String())
+	}
+
+	e.writeSymbol(bevType.String())
+
+	if err := e.EncodeElement(b.bev); err != nil {
+		return errors.WithMessagef(err, "BevNode %s Marshal", bevType)
+	}
+
+	return nil
+}
+
+func (b *BevNode) UnmarshalBTBinary(d *BinaryDecoder) error {
+	bevType := BevType(d.readSymbol())
+	if d.Err() != nil {
+		return errors.WithMessage(d.Err(), "BevNode Unmarshal")
+	}
+
+	meta := d.framework.getBevMeta(bevType)
+	if meta == nil {
+		return errors.Errorf("BevNode Unmarshal: meta of bev type %s not found", bevType)
+	}
+
+	bev := meta.createBev()
+	if err := d.DecodeElement(bev); err != nil {
+		return errors.WithMessagef(err, "BevNode %s Unmarshal", bevType)
+	}
+
+	b.bev = bev
+	return nil
+}
@@ -0,0 +1,175 @@
+package bevtree
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const hookedIncr = BevType("hookedIncr")
+
+// The bev encodes itself in binary.
+type bevHookedIncr struct {
+	bevBBIncr
+}
+
+func (bevHookedIncr) BevType() BevType { return hookedIncr }
+
+func (b *bevHookedIncr) MarshalBTBinary(e *BinaryEncoder) error {
+	e.WriteString(b.Key)
+	e.WriteVarint(int64(b.Limited))
+	return nil
+}
+
+func (b *bevHookedIncr) UnmarshalBTBinary(d *BinaryDecoder) error {
+	b.Key = d.ReadString()
+	b.Limited = int(d.ReadVarint())
+	return d.Err()
+}
+
+func TestTreeMarshalBinary(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(hookedIncr, func() Bev { return new(bevHookedIncr) })
+
+	key := "key"
+	oldTree := buildCodecTestTree(framework, "二进制测试", "binary subtree", key)
+	oldTree.Root().Child().(*ParallelNode).AddChild(NewBevNode(&bevHookedIncr{bevBBIncr{Key: key, Limited: 1}}))
+	sum := runCodecTestTree(t, framework, "二进制测试", key, 3)
+
+	data, err := framework.MarshalBinaryTree(oldTree)
+	if err != nil {
+		t.Fatal("marshal Tree:", err)
+	}
+
+	newTree := new(tree)
+	if err := framework.UnmarshalBinaryTree(data, newTree); err != nil {
+		t.Fatal("unmarshal Tree:", err)
+	}
+
+	if newTree.Comment() != "codec test" {
+		t.Fatalf("comment %q not kept", newTree.Comment())
+	}
+
+	ids, _, _ := oldTree.completeNodeIDs()
+	walkNode(oldTree.Root(), func(n Node) bool {
+		if oldTree.NodeID(n).Valid() {
+			t.Fatalf("node %s assigned id on marshaling", NodePath(n))
+		}
+		if found := newTree.NodeByID(ids[n]); found == nil || NodePath(found) != NodePath(n) || found.Comment() != n.Comment() {
+			t.Fatalf("node %s id %s not kept after unmarshal", NodePath(n), ids[n])
+		}
+		return true
+	})
+
+	if again, err := framework.MarshalBinaryTree(newTree); err != nil {
+		t.Fatal("marshal unmarshaled Tree:", err)
+	} else if !bytes.Equal(data, again) {
+		t.Fatal("marshal unmarshaled Tree: data changed")
+	}
+
+	newTree.SetName("二进制测试2")
+	framework.addTree(newTree)
+
+	if v := runCodecTestTree(t, framework, "二进制测试2", key, 3); v != sum {
+		t.Fatalf("test Tree after unmarshal: sum(%d) != %d", v, sum)
+	}
+
+	// Corrupted data fails without panic.
+	for i := 0; i < len(data); i++ {
+		if err := framework.UnmarshalBinaryTree(data[:i], new(tree)); err == nil {
+			t.Fatalf("unmarshal %d of %d bytes should fail", i, len(data))
+		}
+	}
+
+	for _, version := range []byte{0, BinaryTreeVersion + 1} {
+		invalid := append([]byte(nil), data...)
+		invalid[len(binaryTreeMagic)] = version
+		if err := framework.UnmarshalBinaryTree(invalid, new(tree)); err == nil || !strings.Contains(err.Error(), "unsupported version") {
+			t.Fatalf("unmarshal version %d: %v", version, err)
+		}
+	}
+}
+
+func TestExportBinary(t *testing.T) {
+	key := "key"
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.xml")
+
+	framework := newTestFramework()
+	mainTree := buildCodecTestTree(framework, "main", "sub", key)
+	sub, _ := framework.getOrLoadTree("sub")
+	sum := runCodecTestTree(t, framework, "main", key, 1)
+
+	exporter := NewExporter(framework)
+	exporter.SetLoadAll(true)
+	exporter.AddTree(sub, "sub.bin")
+	exporter.AddTreeWithFormat(mainTree, "main.tree", TreeFormatBinary)
+	if err := exporter.Export(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	framework = NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	if err := framework.Init(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := runCodecTestTree(t, framework, "main", key, 1); v != sum {
+		t.Fatalf("test Tree loaded: sum(%d) != %d", v, sum)
+	}
+}
+
+// Build a tree of about n nodes.
+func buildBenchmarkTree(framework *Framework, n int) *tree {
+	benchTree := NewTree("benchmark")
+	parallel := NewParallelNode()
+	benchTree.Root().SetChild(parallel)
+
+	for i := 0; i < n/10; i++ {
+		seq := NewSequenceNode()
+		seq.SetComment(fmt.Sprintf("sequence %d", i))
+		for j := 0; j < 4; j++ {
+			seq.AddChild(NewBevNode(newBevBBIncr("key", j)))
+			repeater := NewRepeaterNode(j + 1)
+			repeater.SetChild(NewBevNode(newBevBBIncr("key", 1)))
+			seq.AddChild(repeater)
+		}
+		parallel.AddChild(seq)
+	}
+
+	return benchTree
+}
+
+func BenchmarkUnmarshalTree(b *testing.B) {
+	framework := newTestFramework()
+	benchTree := buildBenchmarkTree(framework, 1000)
+
+	formats := []struct {
+		name      string
+		marshal   func(*tree) ([]byte, error)
+		unmarshal func([]byte, *tree) error
+	}{
+		{"xml", framework.MarshalXMLTree, framework.UnmarshalXMLTree},
+		{"json", framework.MarshalJSONTree, framework.UnmarshalJSONTree},
+		{"binary", framework.MarshalBinaryTree, framework.UnmarshalBinaryTree},
+	}
+
+	for _, f := range formats {
+		data, err := f.marshal(benchTree)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(f.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := f.unmarshal(data, new(tree)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
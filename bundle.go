@@ -0,0 +1,299 @@
+package bevtree
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// The version of bundle manifest.
+const BundleVersion = 1
+
+// The name of manifest in bundle.
+const bundleManifestName = "manifest.json"
+
+// The name of config in bundle.
+const bundleConfigName = "config.xml"
+
+// The signature of zip archive.
+var zipSignature = []byte("PK\x03\x04")
+
+// BundleFile describes a file in bundle.
+type BundleFile struct {
+	// The slash-separated path in bundle.
+	Path string `json:"path"`
+
+	// The tree name, empty if not a tree file.
+	Tree string `json:"tree,omitempty"`
+
+	// The size of the file.
+	Size int64 `json:"size"`
+
+	// The hex encoded SHA-256 of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// BundleManifest describes the files in bundle.
+type BundleManifest struct {
+	Version int           `json:"version"`
+	Config  string        `json:"config"`
+	Files   []*BundleFile `json:"files"`
+}
+
+// Bundle is a zip archive of config and tree files, with a manifest
+// of their hashes. It implements fs.FS, the files are verified when
+// the bundle is read, and only the files in manifest can be opened.
+type Bundle struct {
+	zr       *zip.Reader
+	manifest *BundleManifest
+
+	// The paths of files in manifest.
+	files map[string]bool
+}
+
+// OpenBundle reads the bundle file.
+func OpenBundle(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ReadBundle(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open bundle \"%s\"", path)
+	}
+
+	return b, nil
+}
+
+// ReadBundle reads the bundle from r, and verifies the files in it.
+func ReadBundle(r io.ReaderAt, size int64) (*Bundle, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{zr: zr, files: map[string]bool{}}
+
+	data, err := fs.ReadFile(zr, bundleManifestName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read manifest")
+	}
+
+	b.manifest = new(BundleManifest)
+	if err := json.Unmarshal(data, b.manifest); err != nil {
+		return nil, errors.WithMessage(err, "read manifest")
+	}
+
+	if b.manifest.Version <= 0 || b.manifest.Version > BundleVersion {
+		return nil, errors.Errorf("unsupported bundle version %d", b.manifest.Version)
+	}
+
+	if err := b.verify(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Verify the files in manifest.
+func (b *Bundle) verify() error {
+	hasConfig := false
+	for _, file := range b.manifest.Files {
+		if file.Path == b.manifest.Config {
+			hasConfig = true
+		}
+		b.files[file.Path] = true
+
+		if err := b.verifyFile(file); err != nil {
+			return errors.WithMessagef(err, "bundle file \"%s\"", file.Path)
+		}
+	}
+
+	if !hasConfig {
+		return errors.Errorf("bundle config \"%s\" not in manifest", b.manifest.Config)
+	}
+
+	return nil
+}
+
+// Verify the size in zip header first, not to read the large files,
+// then the size and hash of the content.
+func (b *Bundle) verifyFile(file *BundleFile) error {
+	f, err := b.zr.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The uncompressed size of zip file.
+	if info.Size() != file.Size {
+		return errors.Errorf("size %d, but %d in manifest", info.Size(), file.Size)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.LimitReader(f, file.Size+1))
+	if err != nil {
+		return err
+	}
+
+	if n != file.Size {
+		return errors.Errorf("size %d, but %d in manifest", n, file.Size)
+	}
+
+	if hash := hex.EncodeToString(h.Sum(nil)); hash != file.SHA256 {
+		return errors.Errorf("sha256 %s, but %s in manifest", hash, file.SHA256)
+	}
+
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Manifest returns the manifest of bundle.
+func (b *Bundle) Manifest() *BundleManifest { return b.manifest }
+
+func (b *Bundle) Open(name string) (fs.File, error) {
+	// The files not in manifest are not verified.
+	if !b.files[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return b.zr.Open(name)
+}
+
+// Whether the file path is a bundle.
+func isBundleFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	var signature [4]byte
+	if _, err := io.ReadFull(file, signature[:]); err != nil {
+		return false
+	}
+
+	return bytes.Equal(signature[:], zipSignature)
+}
+
+// InitBundle works like Init, but reads the config and trees from
+// bundle.
+func (s *Framework) InitBundle(b *Bundle) error {
+	if b == nil {
+		return errors.New("bevtree framework init: bundle nil")
+	}
+
+	return s.init(b, b.manifest.Config)
+}
+
+// The WriteFS collects files in memory, in the order created.
+type bundleWriteFS struct {
+	paths []string
+	files map[string]*bytes.Buffer
+}
+
+type bundleWriteFile struct {
+	*bytes.Buffer
+}
+
+func (bundleWriteFile) Close() error { return nil }
+
+func (fsys *bundleWriteFS) Create(name string) (io.WriteCloser, error) {
+	if fsys.files[name] == nil {
+		fsys.paths = append(fsys.paths, name)
+	}
+
+	buf := new(bytes.Buffer)
+	fsys.files[name] = buf
+	return bundleWriteFile{buf}, nil
+}
+
+// ExportBundle writes the config and trees to a bundle file at path.
+func (e *Exporter) ExportBundle(path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if e := file.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	return e.WriteBundle(file)
+}
+
+// WriteBundle writes the config and trees as bundle to w.
+func (e *Exporter) WriteBundle(w io.Writer) error {
+	fsys := &bundleWriteFS{files: map[string]*bytes.Buffer{}}
+	if err := e.ExportFS(fsys, bundleConfigName); err != nil {
+		return errors.WithMessage(err, "bevtree exporter WriteBundle")
+	}
+
+	trees := make(map[string]string, len(e.config.TreeEntries))
+	for _, entry := range e.config.TreeEntries {
+		trees[path.Join(path.Dir(bundleConfigName), entry.Path)] = entry.Name
+	}
+
+	manifest := &BundleManifest{Version: BundleVersion, Config: bundleConfigName}
+	for _, p := range fsys.paths {
+		data := fsys.files[p].Bytes()
+		manifest.Files = append(manifest.Files, &BundleFile{
+			Path:   p,
+			Tree:   trees[p],
+			Size:   int64(len(data)),
+			SHA256: sha256Hex(data),
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", indent)
+	if err != nil {
+		return errors.WithMessage(err, "bevtree exporter WriteBundle")
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeZipFile(zw, bundleManifestName, manifestData); err != nil {
+		return errors.WithMessage(err, "bevtree exporter WriteBundle")
+	}
+
+	for _, p := range fsys.paths {
+		if err := writeZipFile(zw, p, fsys.files[p].Bytes()); err != nil {
+			return errors.WithMessage(err, "bevtree exporter WriteBundle")
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return errors.WithMessage(err, "bevtree exporter WriteBundle")
+	}
+
+	return nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
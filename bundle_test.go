@@ -0,0 +1,172 @@
+package bevtree
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	key := "key"
+	framework := newTestFramework()
+	mainTree := buildCodecTestTree(framework, "main", "sub", key)
+	sub, _ := framework.getOrLoadTree("sub")
+	sum := runCodecTestTree(t, framework, "main", key, 1)
+
+	exporter := NewExporter(framework)
+	exporter.AddTree(sub, "trees/sub.bin")
+	exporter.AddTree(mainTree, "trees/main.xml")
+
+	bundlePath := filepath.Join(t.TempDir(), "trees.bundle")
+	if err := exporter.ExportBundle(bundlePath); err != nil {
+		t.Fatal(err)
+	}
+
+	framework = NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	if err := framework.Init(bundlePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := runCodecTestTree(t, framework, "main", key, 1); v != sum {
+		t.Fatalf("sum(%d) != %d", v, sum)
+	}
+
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ReadBundle(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := b.Manifest()
+	if manifest.Version != BundleVersion || manifest.Config != "config.xml" || len(manifest.Files) != 3 {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+
+	trees := map[string]string{}
+	for _, file := range manifest.Files {
+		trees[file.Path] = file.Tree
+	}
+	if trees["trees/sub.bin"] != "sub" || trees["trees/main.xml"] != "main" || trees["config.xml"] != "" {
+		t.Fatalf("invalid manifest files: %v", trees)
+	}
+
+	// Rewrite the bundle with the file content replaced.
+	rewrite := func(name string, replace func([]byte) []byte) []byte {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if f.Name == name {
+				if content = replace(content); content == nil {
+					continue
+				}
+			}
+
+			if err := writeZipFile(zw, f.Name, content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for name, c := range map[string]struct {
+		data []byte
+		msg  string
+	}{
+		"truncated": {data[:len(data)/2], "zip"},
+		"corrupted": {rewrite("trees/main.xml", func(b []byte) []byte {
+			return bytes.Replace(b, []byte("main"), []byte("mian"), 1)
+		}), `bundle file "trees/main.xml": sha256`},
+		"resized": {rewrite("trees/sub.bin", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}), `bundle file "trees/sub.bin": size`},
+		"missing": {rewrite("trees/sub.bin", func([]byte) []byte {
+			return nil
+		}), `bundle file "trees/sub.bin"`},
+		"no manifest": {rewrite(bundleManifestName, func([]byte) []byte {
+			return nil
+		}), "read manifest"},
+		"new version": {rewrite(bundleManifestName, func(b []byte) []byte {
+			var m BundleManifest
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatal(err)
+			}
+			m.Version = BundleVersion + 1
+			b, _ = json.Marshal(&m)
+			return b
+		}), "unsupported bundle version"},
+	} {
+		path := filepath.Join(t.TempDir(), "trees.bundle")
+		if err := os.WriteFile(path, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := NewFramework().Init(path); err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Fatalf("%s: error %v, want %s", name, err, c.msg)
+		}
+	}
+	// The tree not in manifest can't be opened.
+	unlisted := rewrite(bundleManifestName, func(b []byte) []byte {
+		var m BundleManifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		files := m.Files[:0]
+		for _, file := range m.Files {
+			if file.Tree != "sub" {
+				files = append(files, file)
+			}
+		}
+		m.Files = files
+		b, _ = json.Marshal(&m)
+		return b
+	})
+
+	b, err = ReadBundle(bytes.NewReader(unlisted), int64(len(unlisted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Open("trees/sub.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("open file not in manifest: %v", err)
+	}
+
+	framework = NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	if err := framework.InitBundle(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := framework.GetOrLoadTree("main"); err == nil || !strings.Contains(err.Error(), fs.ErrNotExist.Error()) {
+		t.Fatalf("load tree not in manifest: %v", err)
+	}
+}
@@ -0,0 +1,380 @@
+package bevtree
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// NodeCategory indicates how many children a node type has.
+type NodeCategory string
+
+const (
+	NodeCategoryComposite = NodeCategory("composite") // The node has children.
+	NodeCategoryDecorator = NodeCategory("decorator") // The node has a child.
+	NodeCategoryLeaf      = NodeCategory("leaf")      // The node has no child.
+)
+
+// ParamType is the type of parameter.
+type ParamType string
+
+const (
+	ParamInt      = ParamType("int")
+	ParamUint     = ParamType("uint")
+	ParamFloat    = ParamType("float")
+	ParamBool     = ParamType("bool")
+	ParamString   = ParamType("string")
+	ParamDuration = ParamType("duration") // In the format of time.ParseDuration.
+)
+
+var paramTypes = map[ParamType]reflect.Type{
+	ParamInt:      reflect.TypeOf(int64(0)),
+	ParamUint:     reflect.TypeOf(uint64(0)),
+	ParamFloat:    reflect.TypeOf(float64(0)),
+	ParamBool:     reflect.TypeOf(false),
+	ParamString:   reflect.TypeOf(""),
+	ParamDuration: durationType,
+}
+
+// Get the parameter type of t.
+func paramTypeOf(t reflect.Type) ParamType {
+	if t == durationType {
+		return ParamDuration
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return ParamBool
+	case reflect.String:
+		return ParamString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ParamInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ParamUint
+	default:
+		return ParamFloat
+	}
+}
+
+// ParamDescriptor describes a parameter, which is a property of node
+// or bev in tree files. The Default, Min, Max and Enum are in the
+// format of the options of PropertyTagKey.
+type ParamDescriptor struct {
+	Name        string    `json:"name"`
+	Type        ParamType `json:"type"`
+	Required    bool      `json:"required,omitempty"`
+	Default     string    `json:"default,omitempty"`
+	Min         string    `json:"min,omitempty"`
+	Max         string    `json:"max,omitempty"`
+	Enum        []string  `json:"enum,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+// Convert to tagged field.
+func (p *ParamDescriptor) tagField() (*tagField, error) {
+	if p.Name == "" {
+		return nil, errors.New("param name empty")
+	}
+
+	typ := paramTypes[p.Type]
+	if typ == nil {
+		return nil, errors.Errorf("param \"%s\": invalid type \"%s\"", p.Name, p.Type)
+	}
+
+	f := &tagField{name: p.Name, typ: typ, required: p.Required}
+	for _, option := range [...]struct{ key, value string }{
+		{"default", p.Default},
+		{"min", p.Min},
+		{"max", p.Max},
+	} {
+		if option.value == "" {
+			continue
+		}
+
+		if err := f.setOption(option.key, option.value); err != nil {
+			return nil, errors.WithMessagef(err, "param \"%s\"", p.Name)
+		}
+	}
+
+	f.enum = p.Enum
+
+	if err := f.check(); err != nil {
+		return nil, errors.WithMessagef(err, "param \"%s\"", p.Name)
+	}
+
+	return f, nil
+}
+
+// Get the descriptor of tagged field.
+func paramDescriptorOf(f *tagField) *ParamDescriptor {
+	p := &ParamDescriptor{
+		Name:     f.name,
+		Type:     paramTypeOf(f.typ),
+		Required: f.required,
+		Min:      f.minS,
+		Max:      f.maxS,
+		Enum:     append([]string(nil), f.enum...),
+	}
+
+	if f.def.IsValid() {
+		p.Default = formatTagValue(f.def)
+	}
+
+	return p
+}
+
+// Convert params to tagged fields.
+func paramTagFields(params []*ParamDescriptor) ([]*tagField, error) {
+	fields := make([]*tagField, 0, len(params))
+	names := map[string]bool{}
+	for _, p := range params {
+		if p == nil {
+			return nil, errors.New("param nil")
+		}
+
+		f, err := p.tagField()
+		if err != nil {
+			return nil, err
+		}
+
+		if names[f.name] {
+			return nil, errors.Errorf("param \"%s\" duplicated", f.name)
+		}
+		names[f.name] = true
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// Get the descriptors of tagged fields, nil if open.
+func paramDescriptorsOf(schema *typeSchema, fields []*tagField) []*ParamDescriptor {
+	if schema.open {
+		return nil
+	}
+
+	params := make([]*ParamDescriptor, 0, len(fields))
+	for _, f := range fields {
+		params = append(params, paramDescriptorOf(f))
+	}
+
+	return params
+}
+
+// Copy params.
+func copyParams(params []*ParamDescriptor) []*ParamDescriptor {
+	if params == nil {
+		return nil
+	}
+
+	c := make([]*ParamDescriptor, len(params))
+	for i, p := range params {
+		if p == nil {
+			continue
+		}
+
+		cp := *p
+		cp.Enum = append([]string(nil), p.Enum...)
+		c[i] = &cp
+	}
+
+	return c
+}
+
+// NodeDescriptor describes a node type for editors. The Type is set
+// by the framework on registering.
+type NodeDescriptor struct {
+	Type     NodeType     `json:"type"`
+	Category NodeCategory `json:"category"`
+
+	// The number of children allowed, -1 MaxChildren means unlimited.
+	// The zero MaxChildren is 1 for decorators, unlimited for
+	// composites.
+	MinChildren int `json:"minChildren"`
+	MaxChildren int `json:"maxChildren"`
+
+	// The parameters of node, nil means unknown.
+	Params []*ParamDescriptor `json:"params"`
+
+	// The parameters of each child, only for composites.
+	ChildParams []*ParamDescriptor `json:"childParams,omitempty"`
+
+	Description string `json:"description,omitempty"`
+}
+
+// Check and complete the descriptor of node type, returns the schema
+// described.
+func (d *NodeDescriptor) normalize(nodeType NodeType) (*typeSchema, error) {
+	if d.Type != "" && d.Type != nodeType {
+		return nil, errors.Errorf("different type \"%s\"", d.Type.String())
+	}
+	d.Type = nodeType
+
+	switch d.Category {
+	case NodeCategoryLeaf:
+		if d.MinChildren != 0 || d.MaxChildren != 0 {
+			return nil, errors.New("leaf with children")
+		}
+
+	case NodeCategoryDecorator:
+		if d.MaxChildren == 0 {
+			d.MaxChildren = 1
+		}
+		if d.MaxChildren != 1 || d.MinChildren < 0 || d.MinChildren > 1 {
+			return nil, errors.Errorf("decorator with %d-%d children", d.MinChildren, d.MaxChildren)
+		}
+
+	case NodeCategoryComposite:
+		if d.MaxChildren == 0 {
+			d.MaxChildren = -1
+		}
+		if d.MinChildren < 0 || d.MaxChildren < -1 || (d.MaxChildren >= 0 && d.MaxChildren < d.MinChildren) {
+			return nil, errors.Errorf("composite with %d-%d children", d.MinChildren, d.MaxChildren)
+		}
+
+	default:
+		return nil, errors.Errorf("invalid category \"%s\"", d.Category)
+	}
+
+	if len(d.ChildParams) > 0 && d.Category != NodeCategoryComposite {
+		return nil, errors.New("child params without children")
+	}
+
+	if d.Params == nil {
+		d.Params = []*ParamDescriptor{}
+	}
+
+	props, err := paramTagFields(d.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	childProps, err := paramTagFields(d.ChildParams)
+	if err != nil {
+		return nil, errors.WithMessage(err, "child")
+	}
+
+	return &typeSchema{
+		props:      props,
+		child:      d.Category == NodeCategoryDecorator,
+		children:   d.Category == NodeCategoryComposite,
+		childProps: childProps,
+	}, nil
+}
+
+func (d *NodeDescriptor) copy() *NodeDescriptor {
+	c := *d
+	c.Params = copyParams(d.Params)
+	c.ChildParams = copyParams(d.ChildParams)
+	return &c
+}
+
+// BevDescriptor describes a bev type for editors. The Type is set by
+// the framework on registering.
+type BevDescriptor struct {
+	Type BevType `json:"type"`
+
+	// The parameters of bev, nil means unknown.
+	Params []*ParamDescriptor `json:"params"`
+
+	Description string `json:"description,omitempty"`
+}
+
+// Check and complete the descriptor of bev type, returns the schema
+// described.
+func (d *BevDescriptor) normalize(bevType BevType) (*typeSchema, error) {
+	if d.Type != "" && d.Type != bevType {
+		return nil, errors.Errorf("different type \"%s\"", d.Type.String())
+	}
+	d.Type = bevType
+
+	if d.Params == nil {
+		d.Params = []*ParamDescriptor{}
+	}
+
+	props, err := paramTagFields(d.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &typeSchema{props: props}, nil
+}
+
+func (d *BevDescriptor) copy() *BevDescriptor {
+	c := *d
+	c.Params = copyParams(d.Params)
+	return &c
+}
+
+// Catalog contains the descriptors of registered node and bev types,
+// in the order of type.
+type Catalog struct {
+	Nodes []*NodeDescriptor `json:"nodes"`
+	Bevs  []*BevDescriptor  `json:"bevs"`
+}
+
+// Get the descriptor of node type, derived from the tags if not
+// registered with one. The category is empty if unknown.
+func (s *Framework) nodeDescriptor(nodeType NodeType) *NodeDescriptor {
+	meta := s.getNodeMeta(nodeType)
+	if meta.desc != nil {
+		return meta.desc.copy()
+	}
+
+	schema := s.nodeSchema(nodeType)
+	d := &NodeDescriptor{Type: nodeType, Params: paramDescriptorsOf(schema, schema.props)}
+	if !schema.open {
+		d.Category = NodeCategoryLeaf
+	}
+
+	return d
+}
+
+// Get the descriptor of bev type, derived from the tags if not
+// registered with one.
+func (s *Framework) bevDescriptor(bevType BevType) *BevDescriptor {
+	meta := s.getBevMeta(bevType)
+	if meta.desc != nil {
+		return meta.desc.copy()
+	}
+
+	schema := s.bevSchema(bevType)
+	return &BevDescriptor{Type: bevType, Params: paramDescriptorsOf(schema, schema.props)}
+}
+
+// Catalog returns the descriptors of all registered node and bev
+// types. The types registered without descriptor are described by
+// the tags of PropertyTagKey, or unknown if they have their own codecs.
+func (s *Framework) Catalog() *Catalog {
+	c := &Catalog{
+		Nodes: make([]*NodeDescriptor, 0, len(s.nodeMetas)),
+		Bevs:  make([]*BevDescriptor, 0, len(s.bevMetas)),
+	}
+
+	for nodeType := range s.nodeMetas {
+		c.Nodes = append(c.Nodes, s.nodeDescriptor(nodeType))
+	}
+	sort.Slice(c.Nodes, func(i, j int) bool { return c.Nodes[i].Type < c.Nodes[j].Type })
+
+	for _, bevType := range s.schemaBevTypes() {
+		c.Bevs = append(c.Bevs, s.bevDescriptor(bevType))
+	}
+
+	return c
+}
+
+// ExportCatalog writes the catalog to w in JSON.
+func (s *Framework) ExportCatalog(w io.Writer) error {
+	data, err := json.MarshalIndent(s.Catalog(), "", indent)
+	if err != nil {
+		return errors.WithMessage(err, "marshal catalog")
+	}
+
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,130 @@
+package bevtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	framework := NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} }, &BevDescriptor{
+		Params: []*ParamDescriptor{
+			{Name: "Key", Type: ParamString, Required: true},
+			{Name: "Limited", Type: ParamInt, Default: "1", Min: "1"},
+		},
+		Description: "Increase the value of key in blackboard.",
+	})
+	framework.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	catalog := framework.Catalog()
+
+	nodes := map[NodeType]*NodeDescriptor{}
+	for _, d := range catalog.Nodes {
+		nodes[d.Type] = d
+	}
+
+	if d := nodes[sequence]; d == nil || d.Category != NodeCategoryComposite || d.MinChildren != 1 || d.MaxChildren != -1 {
+		t.Fatalf("sequence: %+v", d)
+	}
+
+	if d := nodes[repeater]; d == nil || d.Category != NodeCategoryDecorator || d.MaxChildren != 1 ||
+		len(d.Params) != 1 || d.Params[0].Name != PropertyLimited || d.Params[0].Type != ParamInt {
+		t.Fatalf("repeater: %+v", d)
+	}
+
+	if d := nodes[weightSelector]; d == nil || len(d.ChildParams) != 1 || d.ChildParams[0].Type != ParamFloat || d.ChildParams[0].Min != "" {
+		t.Fatalf("weightselector: %+v", d)
+	}
+
+	if len(catalog.Bevs) != 2 || catalog.Bevs[0].Type != blackboardIncr || catalog.Bevs[1].Type != tagIncr {
+		t.Fatalf("bevs: %+v", catalog.Bevs)
+	}
+
+	// The bev without descriptor is described by the tags.
+	want := []*ParamDescriptor{
+		{Name: "key", Type: ParamString, Required: true},
+		{Name: "limited", Type: ParamInt, Default: "1", Min: "1", Max: "10"},
+		{Name: "mode", Type: ParamString, Default: "add", Enum: []string{"add", "sub"}},
+		{Name: "delay", Type: ParamDuration, Min: "0s"},
+	}
+	if !reflect.DeepEqual(catalog.Bevs[1].Params, want) {
+		t.Fatalf("tagged params: %+v", catalog.Bevs[1].Params)
+	}
+
+	// The catalog returned is a copy.
+	catalog.Bevs[0].Params[0].Name = "changed"
+	if framework.Catalog().Bevs[0].Params[0].Name != "Key" {
+		t.Fatal("catalog not copied")
+	}
+
+	var buf bytes.Buffer
+	if err := framework.ExportCatalog(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported Catalog
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&exported, framework.Catalog()) {
+		t.Fatalf("exported catalog:\n%s", buf.String())
+	}
+
+	// The schema follows the descriptor.
+	schema := framework.bevSchema(blackboardIncr)
+	if len(schema.props) != 2 || schema.open {
+		t.Fatalf("schema of descriptor: %+v", schema)
+	}
+}
+
+func TestInvalidDescriptor(t *testing.T) {
+	register := func(desc interface{}) (msg string) {
+		defer func() {
+			if r := recover(); r != nil {
+				msg = fmt.Sprint(r)
+			}
+		}()
+
+		m := newMeta()
+		switch d := desc.(type) {
+		case *NodeDescriptor:
+			m.RegisterNodeType(sequence+"2", func() Node { return new(seqNode2) }, func() Task { return &sequenceTask{} }, d)
+		case *BevDescriptor:
+			m.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} }, d)
+		}
+		return ""
+	}
+
+	for i, c := range []struct {
+		desc interface{}
+		msg  string
+	}{
+		{&NodeDescriptor{Category: NodeCategoryLeaf, MinChildren: 1}, "leaf with children"},
+		{&NodeDescriptor{Category: NodeCategoryDecorator, MaxChildren: 2}, "decorator with 0-2 children"},
+		{&NodeDescriptor{Category: NodeCategoryComposite, MinChildren: 3, MaxChildren: 2}, "composite with 3-2 children"},
+		{&NodeDescriptor{Category: "unknown"}, `invalid category "unknown"`},
+		{&NodeDescriptor{Category: NodeCategoryLeaf, ChildParams: []*ParamDescriptor{{Name: "w", Type: ParamFloat}}}, "child params without children"},
+		{&NodeDescriptor{Type: sequence, Category: NodeCategoryComposite}, `different type "sequence"`},
+		{&BevDescriptor{Params: []*ParamDescriptor{{Name: "a", Type: "complex"}}}, `invalid type "complex"`},
+		{&BevDescriptor{Params: []*ParamDescriptor{{Name: "a", Type: ParamInt, Default: "x"}}}, "option default"},
+		{&BevDescriptor{Params: []*ParamDescriptor{{Name: "a", Type: ParamInt, Default: "0", Min: "1"}}}, "option default"},
+		{&BevDescriptor{Params: []*ParamDescriptor{{Name: "a", Type: ParamInt}, {Name: "a", Type: ParamInt}}}, `param "a" duplicated`},
+		{&BevDescriptor{Params: []*ParamDescriptor{nil}}, "param nil"},
+	} {
+		if msg := register(c.desc); !strings.Contains(msg, c.msg) {
+			t.Fatalf("case %d: panic %q, want %q", i, msg, c.msg)
+		}
+	}
+}
+
+// The sequence node of another type.
+type seqNode2 struct {
+	SequenceNode
+}
+
+func (seqNode2) NodeType() NodeType { return sequence + "2" }
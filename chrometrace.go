@@ -0,0 +1,451 @@
+package bevtree
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Chrome trace event, see the Trace Event Format.
+type chromeTraceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Ph    string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Pid   uint64                 `json:"pid"`
+	Tid   uint64                 `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// An open span of agent.
+type chromeTraceSpan struct {
+	name   string
+	path   string
+	result Result
+
+	// The thread the span is on, and whether the span is the first
+	// open span of the thread.
+	tid   uint64
+	first bool
+
+	// The parent span and the number of open child spans.
+	parent   *chromeTraceSpan
+	children int
+}
+
+// The track of entity.
+type chromeTraceTrack struct {
+	spans []*chromeTraceSpan
+
+	// The threads with open spans.
+	threads map[uint64]bool
+}
+
+// Build Chrome trace events and write them as they happen. Each
+// entity is a process, the agents are duration spans nested by the
+// parent chain, on the thread of entity ID. A span is on the thread
+// of its parent if it's the only open child, or on another thread,
+// so the concurrent children of parallel nodes don't overlap on one
+// thread.
+type chromeTraceBuilder struct {
+	w      *bufio.Writer
+	n      int
+	err    error
+	start  time.Time
+	latest time.Time
+	tracks map[uint64]*chromeTraceTrack
+}
+
+func newChromeTraceBuilder(w io.Writer) *chromeTraceBuilder {
+	return &chromeTraceBuilder{
+		w:      bufio.NewWriter(w),
+		tracks: map[uint64]*chromeTraceTrack{},
+	}
+}
+
+// Get the timestamp in microseconds since the first event.
+func (b *chromeTraceBuilder) ts(t time.Time) float64 {
+	if b.start.IsZero() {
+		b.start = t
+	}
+	if t.After(b.latest) {
+		b.latest = t
+	}
+	return float64(t.Sub(b.start).Nanoseconds()) / 1e3
+}
+
+// Write an event, in the JSON Array Format.
+func (b *chromeTraceBuilder) write(e *chromeTraceEvent) {
+	if b.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	if b.n == 0 {
+		b.w.WriteString("[\n")
+	} else {
+		b.w.WriteString(",\n")
+	}
+	b.n++
+
+	_, b.err = b.w.Write(data)
+}
+
+// Write the end of events, and flush.
+func (b *chromeTraceBuilder) finish() error {
+	if b.err == nil {
+		if b.n == 0 {
+			b.w.WriteString("[")
+		}
+		b.w.WriteString("\n]\n")
+	}
+
+	return b.flush()
+}
+
+func (b *chromeTraceBuilder) flush() error {
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+	return b.err
+}
+
+func (b *chromeTraceBuilder) track(entity uint64, tree string) *chromeTraceTrack {
+	track := b.tracks[entity]
+	if track == nil {
+		track = &chromeTraceTrack{threads: map[uint64]bool{}}
+		b.tracks[entity] = track
+		b.write(&chromeTraceEvent{
+			Name: "process_name",
+			Ph:   "M",
+			Pid:  entity,
+			Tid:  entity,
+			Args: map[string]interface{}{"name": fmt.Sprintf("%s #%d", tree, entity)},
+		})
+	}
+	return track
+}
+
+func (b *chromeTraceBuilder) begin(entity uint64, tree, name, path string, id NodeID, t time.Time) {
+	track := b.track(entity, tree)
+
+	s := &chromeTraceSpan{name: name, path: path, result: Running}
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		if j := track.find(path[:i]); j >= 0 {
+			s.parent = track.spans[j]
+		}
+	}
+
+	if s.parent != nil && s.parent.children == 0 {
+		s.tid = s.parent.tid
+	} else {
+		s.tid = track.thread(entity)
+		s.first = true
+	}
+
+	if s.parent != nil {
+		s.parent.children++
+	}
+
+	track.spans = append(track.spans, s)
+
+	b.write(&chromeTraceEvent{
+		Name: name,
+		Cat:  "bevtree",
+		Ph:   "B",
+		Ts:   b.ts(t),
+		Pid:  entity,
+		Tid:  s.tid,
+		Args: map[string]interface{}{
+			"path": path,
+			"id":   uint32(id),
+		},
+	})
+}
+
+// Set the result of the latest open span of node.
+func (b *chromeTraceBuilder) result(entity uint64, path string, result Result) {
+	if track := b.tracks[entity]; track != nil {
+		if i := track.find(path); i >= 0 {
+			track.spans[i].result = result
+		}
+	}
+}
+
+// End the span of node, the open spans of descendants end too.
+func (b *chromeTraceBuilder) end(entity uint64, path string, t time.Time) {
+	track := b.tracks[entity]
+	if track == nil {
+		return
+	}
+
+	i := track.find(path)
+	if i < 0 {
+		return
+	}
+
+	prefix := path + "/"
+	for j := len(track.spans) - 1; j > i; j-- {
+		if strings.HasPrefix(track.spans[j].path, prefix) {
+			b.emit(entity, track, track.spans[j], t)
+			track.spans = append(track.spans[:j], track.spans[j+1:]...)
+		}
+	}
+
+	b.emit(entity, track, track.spans[i], t)
+	track.spans = append(track.spans[:i], track.spans[i+1:]...)
+}
+
+// End all the open spans of entity, and forget it.
+func (b *chromeTraceBuilder) close(entity uint64, t time.Time) {
+	if track := b.tracks[entity]; track != nil {
+		for j := len(track.spans) - 1; j >= 0; j-- {
+			b.emit(entity, track, track.spans[j], t)
+		}
+		delete(b.tracks, entity)
+	}
+}
+
+// End all the open spans at the latest time.
+func (b *chromeTraceBuilder) closeAll() {
+	for entity := range b.tracks {
+		b.close(entity, b.latest)
+	}
+}
+
+func (b *chromeTraceBuilder) instant(entity uint64, name string, result Result, t time.Time) {
+	if b.tracks[entity] == nil {
+		return
+	}
+
+	b.write(&chromeTraceEvent{
+		Name:  name,
+		Cat:   "bevtree",
+		Ph:    "i",
+		Ts:    b.ts(t),
+		Pid:   entity,
+		Tid:   entity,
+		Scope: "t",
+		Args:  map[string]interface{}{"result": result.String()},
+	})
+}
+
+func (b *chromeTraceBuilder) emit(entity uint64, track *chromeTraceTrack, s *chromeTraceSpan, t time.Time) {
+	if s.parent != nil {
+		s.parent.children--
+	}
+	if s.first {
+		delete(track.threads, s.tid)
+	}
+
+	b.write(&chromeTraceEvent{
+		Name: s.name,
+		Cat:  "bevtree",
+		Ph:   "E",
+		Ts:   b.ts(t),
+		Pid:  entity,
+		Tid:  s.tid,
+		Args: map[string]interface{}{"result": s.result.String()},
+	})
+}
+
+// Get a thread without open spans, the thread of entity ID first.
+func (t *chromeTraceTrack) thread(entity uint64) uint64 {
+	tid := entity
+	for t.threads[tid] {
+		tid++
+	}
+	t.threads[tid] = true
+	return tid
+}
+
+func (t *chromeTraceTrack) find(path string) int {
+	for i := len(t.spans) - 1; i >= 0; i-- {
+		if t.spans[i].path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// ChromeTraceExporter is an Observer writes the execution of entities
+// as Chrome Trace Event JSON, which can be opened in chrome://tracing
+// or Perfetto. Each entity is a process, the nodes are duration spans
+// from the creation to the destruction of their agents, nested by the
+// parent chain on the thread of entity ID. The concurrent children of
+// parallel nodes are on other threads. The events are written as they
+// happen, in the JSON Array Format, which can be opened without the
+// end written by Close. The time is the time of entity, Context.Now.
+type ChromeTraceExporter struct {
+	mtx    sync.Mutex
+	b      *chromeTraceBuilder
+	closed bool
+}
+
+// NewChromeTraceExporter creates a ChromeTraceExporter writes to w.
+func NewChromeTraceExporter(w io.Writer) *ChromeTraceExporter {
+	return &ChromeTraceExporter{b: newChromeTraceBuilder(w)}
+}
+
+func (c *ChromeTraceExporter) OnEvent(ev *Event) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	ctx := ev.Entity.Context()
+	id := ev.Entity.ID()
+	now := ctx.Now()
+
+	switch ev.Type {
+	case EventAgentCreate:
+		c.b.begin(id, ctx.Tree().Name(), nodePathName(ev.Node), NodePath(ev.Node), ctx.Tree().NodeID(ev.Node), now)
+
+	case EventAgentInit, EventAgentUpdate, EventAgentChildTerminated:
+		if ev.Result != Running {
+			c.b.result(id, NodePath(ev.Node), ev.Result)
+		}
+
+	case EventAgentDestroy:
+		c.b.end(id, NodePath(ev.Node), now)
+
+	case EventEntityUpdate:
+		c.b.instant(id, "update", ev.Result, now)
+
+	case EventEntityRelease:
+		c.b.close(id, now)
+	}
+}
+
+// Flush writes the buffered events to the underlying writer.
+func (c *ChromeTraceExporter) Flush() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.b.flush()
+}
+
+// Close ends the agents still running at the time of the latest
+// event, and writes the end of events. The events after Close are
+// ignored.
+func (c *ChromeTraceExporter) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return c.b.err
+	}
+
+	c.closed = true
+	c.b.closeAll()
+	return c.b.finish()
+}
+
+// Convert the frame read from trace file.
+func (b *chromeTraceBuilder) frame(f *TraceFrame) {
+	// Entity IDs are unique in session.
+	entity := uint64(f.Session)<<48 | f.Entity
+
+	for _, e := range f.Events {
+		n := f.Node(e)
+		if n == nil {
+			continue
+		}
+
+		switch e.Type {
+		case EventAgentCreate:
+			name := string(n.NodeType)
+			if i := strings.LastIndexByte(n.Path, '/'); i >= 0 {
+				name = n.Path[i+1:]
+			}
+			if i := strings.IndexByte(name, '['); i >= 0 {
+				name = name[:i]
+			}
+			b.begin(entity, n.Tree, name, n.Path, n.ID, f.Time)
+
+		case EventAgentInit, EventAgentUpdate, EventAgentChildTerminated:
+			if e.Result != Running {
+				b.result(entity, n.Path, e.Result)
+			}
+
+		case EventAgentDestroy:
+			b.end(entity, n.Path, f.Time)
+		}
+	}
+
+	if f.Released {
+		b.close(entity, f.Time)
+	} else {
+		b.instant(entity, "update", f.Result, f.Time)
+	}
+}
+
+// WriteChromeTrace converts the frames read from trace file to
+// Chrome Trace Event JSON and writes to w. The time of events is
+// the time of frames, so nodes started and ended in the same
+// update have no duration.
+func WriteChromeTrace(w io.Writer, frames []*TraceFrame) error {
+	b := newChromeTraceBuilder(w)
+	for _, f := range frames {
+		b.frame(f)
+	}
+
+	b.closeAll()
+	return b.finish()
+}
+
+// WriteChromeTraceFile converts the trace file at tracePath to
+// Chrome Trace Event JSON file at path. The frames are converted
+// as read.
+func WriteChromeTraceFile(path, tracePath string) error {
+	traceFile, err := os.Open(tracePath)
+	if err != nil {
+		return errors.WithMessage(err, "write chrome trace file")
+	}
+	defer traceFile.Close()
+
+	r, err := NewTraceReader(traceFile)
+	if err != nil {
+		return errors.WithMessagef(err, "write chrome trace file: read \"%s\"", tracePath)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithMessage(err, "write chrome trace file")
+	}
+
+	b := newChromeTraceBuilder(file)
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			file.Close()
+			return errors.WithMessagef(err, "write chrome trace file: read \"%s\"", tracePath)
+		}
+
+		b.frame(f)
+	}
+
+	b.closeAll()
+	if err := b.finish(); err != nil {
+		file.Close()
+		return errors.WithMessagef(err, "write chrome trace file \"%s\"", path)
+	}
+
+	return file.Close()
+}
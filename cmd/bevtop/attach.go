@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GodYY/bevtree"
+	"github.com/pkg/errors"
+)
+
+// The request to debug server.
+type debugRequest struct {
+	ID     int64  `json:"id"`
+	Cmd    string `json:"cmd"`
+	Entity uint64 `json:"entity,omitempty"`
+}
+
+// The response or event from debug server.
+type debugMessage struct {
+	ID       int64                  `json:"id"`
+	OK       bool                   `json:"ok"`
+	Error    string                 `json:"error"`
+	Result   json.RawMessage        `json:"result"`
+	Event    string                 `json:"event"`
+	Entity   uint64                 `json:"entity"`
+	Snapshot *bevtree.DebugSnapshot `json:"snapshot"`
+}
+
+// The node of tree from debug server.
+type debugTreeNode struct {
+	ID       bevtree.NodeID   `json:"id"`
+	NodeType bevtree.NodeType `json:"nodetype"`
+	BevType  bevtree.BevType  `json:"bevtype"`
+	Subtree  string           `json:"subtree"`
+	Path     string           `json:"path"`
+	Children []*debugTreeNode `json:"children"`
+}
+
+// The frame or note received.
+type liveEvent struct {
+	frame *frame
+	note  string
+}
+
+type debugClient struct {
+	conn   net.Conn
+	dec    *json.Decoder
+	entity uint64
+
+	mtx sync.Mutex
+	enc *json.Encoder
+	seq int64
+
+	events chan liveEvent
+}
+
+// Attach to the debug server at addr, watch the entity.
+func attach(addr string, entity uint64, treeName string, history int) (*view, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "attach")
+	}
+
+	c := &debugClient{
+		conn:   conn,
+		dec:    json.NewDecoder(bufio.NewReader(conn)),
+		enc:    json.NewEncoder(conn),
+		events: make(chan liveEvent, 64),
+	}
+
+	v, err := c.init(entity, treeName)
+	if err != nil {
+		conn.Close()
+		return nil, errors.WithMessagef(err, "attach %s", addr)
+	}
+
+	v.history = history
+	v.follow = true
+	v.client = c
+
+	go c.readLoop()
+	return v, nil
+}
+
+// Call the command and wait for the response. The events before
+// the response are dropped.
+func (c *debugClient) call(cmd string, entity uint64, result interface{}) error {
+	c.mtx.Lock()
+	c.seq++
+	id := c.seq
+	err := c.enc.Encode(&debugRequest{ID: id, Cmd: cmd, Entity: entity})
+	c.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		var msg debugMessage
+		if err := c.dec.Decode(&msg); err != nil {
+			return err
+		}
+
+		if msg.ID != id {
+			continue
+		}
+
+		if msg.Error != "" {
+			return errors.Errorf("%s: %s", cmd, msg.Error)
+		}
+
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+
+		return nil
+	}
+}
+
+// Send the command to the entity watched, the response is
+// received by readLoop.
+func (c *debugClient) send(cmd string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.seq++
+	return c.enc.Encode(&debugRequest{ID: c.seq, Cmd: cmd, Entity: c.entity})
+}
+
+func (c *debugClient) close() { c.conn.Close() }
+
+// Choose the entity, get the tree and watch.
+func (c *debugClient) init(entity uint64, treeName string) (*view, error) {
+	var entities []bevtree.DebugEntity
+	if err := c.call("entities", 0, &entities); err != nil {
+		return nil, err
+	}
+
+	var e *bevtree.DebugEntity
+	for i := range entities {
+		if (entity == 0 || entities[i].ID == entity) && (treeName == "" || entities[i].Tree == treeName) {
+			e = &entities[i]
+			break
+		}
+	}
+
+	if e == nil {
+		return nil, errors.New("entity not found")
+	}
+
+	c.entity = e.ID
+
+	var root debugTreeNode
+	if err := c.call("tree", e.ID, &root); err != nil {
+		return nil, err
+	}
+
+	if err := c.call("watch", e.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return &view{
+		title:   fmt.Sprintf("%s #%d", e.Tree, e.ID),
+		outline: debugOutline(&root),
+	}, nil
+}
+
+// Build the outline of the tree from debug server.
+func debugOutline(root *debugTreeNode) []outlineNode {
+	var outline []outlineNode
+
+	var walk func(n *debugTreeNode, depth int)
+	walk = func(n *debugTreeNode, depth int) {
+		label := n.Path[strings.LastIndexByte(n.Path, '/')+1:] + " #" + n.ID.String()
+		if n.Subtree != "" {
+			label += " -> " + n.Subtree
+		}
+
+		outline = append(outline, outlineNode{key: n.Path, label: label, depth: depth})
+		for _, child := range n.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(root, 0)
+
+	return outline
+}
+
+func (c *debugClient) readLoop() {
+	defer close(c.events)
+
+	for {
+		var msg debugMessage
+		if err := c.dec.Decode(&msg); err != nil {
+			return
+		}
+
+		switch {
+		case msg.Error != "":
+			c.events <- liveEvent{note: msg.Error}
+
+		case msg.Entity != c.entity:
+
+		case msg.Event == "snapshot" || msg.Event == "paused":
+			if msg.Snapshot != nil {
+				ev := liveEvent{frame: snapshotFrame(msg.Snapshot)}
+				if msg.Event == "paused" {
+					ev.note = "paused"
+				}
+				c.events <- ev
+			}
+
+		case msg.Event == "resumed":
+			c.events <- liveEvent{note: "resumed"}
+
+		case msg.Event == "released":
+			c.events <- liveEvent{note: "entity released"}
+			return
+		}
+	}
+}
+
+func snapshotFrame(s *bevtree.DebugSnapshot) *frame {
+	f := &frame{
+		updateSeri: s.UpdateSeri,
+		status:     map[string]string{},
+	}
+
+	// Only the nodes terminated in the update are marked.
+	for _, path := range s.Terminated {
+		f.status[path] = s.Results[path]
+	}
+
+	var walk func([]*bevtree.DebugNode)
+	walk = func(nodes []*bevtree.DebugNode) {
+		for _, n := range nodes {
+			f.status[n.Path] = "running"
+			walk(n.Children)
+		}
+	}
+	walk(s.Nodes)
+
+	if s.Paused != nil {
+		f.paused = s.Paused.Path
+		f.desc = "paused before " + s.Paused.Path
+	}
+
+	for k, v := range s.DataSet {
+		f.data = append(f.data, k+"="+string(v))
+	}
+	sort.Strings(f.data)
+
+	return f
+}
@@ -0,0 +1,69 @@
+// Command bevtop shows the behavior tree of an entity in the
+// terminal, live from the debug server of a process, see
+// bevtree.Debugger, or replayed from a trace file, see
+// bevtree.TraceRecorder.
+//
+//	bevtop [-entity id] [-tree name] host:port
+//	bevtop -trace file [-entity id] [-tree name]
+//
+// The tree is drawn as an indented outline, the nodes are marked
+// running (*), success (+) or failure (-) in the update shown. The
+// updates are kept as history to scroll through:
+//
+//	left, h      previous update
+//	right, l     next update
+//	up, k        scroll up
+//	down, j      scroll down
+//	g, G         first, latest update
+//	f            follow the latest update
+//	P, c, s      pause, continue, step the entity when attached
+//	q            quit
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: bevtop [-entity id] [-tree name] [-history n] host:port\n")
+	fmt.Fprintf(os.Stderr, "       bevtop -trace file [-entity id] [-tree name]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	var (
+		tracePath = flag.String("trace", "", "replay the trace `file`")
+		entity    = flag.Uint64("entity", 0, "show the entity of `id`, the first entity by default")
+		treeName  = flag.String("tree", "", "show the first entity of tree `name`")
+		history   = flag.Int("history", 1000, "keep the latest `n` updates when attached")
+	)
+
+	flag.Usage = usage
+	flag.Parse()
+
+	var v *view
+	var err error
+	if *tracePath != "" {
+		if flag.NArg() != 0 {
+			usage()
+		}
+		v, err = replay(*tracePath, *entity, *treeName)
+	} else {
+		if flag.NArg() != 1 {
+			usage()
+		}
+		v, err = attach(flag.Arg(0), *entity, *treeName, *history)
+	}
+
+	if err == nil {
+		err = v.run()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bevtop:", err)
+		os.Exit(1)
+	}
+}
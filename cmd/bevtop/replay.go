@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GodYY/bevtree"
+	"github.com/pkg/errors"
+)
+
+// Replay the frames of entity in the trace file.
+func replay(path string, entity uint64, treeName string) (*view, error) {
+	all, err := bevtree.ReadTraceFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Choose the first entity matches, the entity IDs are unique
+	// in session.
+	var first *bevtree.TraceFrame
+	for _, f := range all {
+		if (entity == 0 || f.Entity == entity) && (treeName == "" || f.Tree == treeName) {
+			first = f
+			break
+		}
+	}
+
+	if first == nil {
+		return nil, errors.Errorf("replay %s: entity not found", path)
+	}
+
+	var frames []*bevtree.TraceFrame
+	for _, f := range all {
+		if f.Session == first.Session && f.Entity == first.Entity {
+			frames = append(frames, f)
+		}
+	}
+
+	v := &view{
+		title:   fmt.Sprintf("%s #%d", first.Tree, first.Entity),
+		outline: traceOutline(first.Tree, frames),
+	}
+
+	data := map[string]string{}
+	for _, tf := range frames {
+		v.frames = append(v.frames, traceFrame(first.Tree, tf, data))
+	}
+
+	return v, nil
+}
+
+// Get the outline key of node, the nodes of subtrees are prefixed
+// by the tree name.
+func traceKey(tree string, n *bevtree.TraceNode) string {
+	if n.Tree == tree {
+		return n.Path
+	}
+	return n.Tree + ":" + n.Path
+}
+
+// Build the outline of the nodes appeared in frames. The trace
+// records only the nodes run, the siblings are ordered by ID. The
+// subtrees follow the tree.
+func traceOutline(tree string, frames []*bevtree.TraceFrame) []outlineNode {
+	type treeNodes struct {
+		name     string
+		nodes    map[string]*bevtree.TraceNode
+		children map[string][]*bevtree.TraceNode
+	}
+
+	var trees []*treeNodes
+	byName := map[string]*treeNodes{}
+
+	add := func(n *bevtree.TraceNode) {
+		if n == nil {
+			return
+		}
+
+		t := byName[n.Tree]
+		if t == nil {
+			t = &treeNodes{name: n.Tree, nodes: map[string]*bevtree.TraceNode{}, children: map[string][]*bevtree.TraceNode{}}
+			byName[n.Tree] = t
+			trees = append(trees, t)
+		}
+
+		if t.nodes[n.Path] == nil {
+			t.nodes[n.Path] = n
+			parent := ""
+			if i := strings.LastIndexByte(n.Path, '/'); i >= 0 {
+				parent = n.Path[:i]
+			}
+			t.children[parent] = append(t.children[parent], n)
+		}
+	}
+
+	for _, f := range frames {
+		for _, e := range f.Events {
+			add(f.Node(e))
+		}
+		for _, n := range f.Running {
+			add(n)
+		}
+	}
+
+	sort.SliceStable(trees, func(i, j int) bool { return trees[i].name == tree && trees[j].name != tree })
+
+	var outline []outlineNode
+	for _, t := range trees {
+		depth := 0
+		if t.name != tree {
+			outline = append(outline, outlineNode{label: "subtree " + t.name})
+			depth = 1
+		}
+
+		var walk func(parent string, depth int)
+		walk = func(parent string, depth int) {
+			children := t.children[parent]
+			sort.Slice(children, func(i, j int) bool {
+				if children[i].ID != children[j].ID {
+					return children[i].ID < children[j].ID
+				}
+				return children[i].Path < children[j].Path
+			})
+
+			for _, n := range children {
+				outline = append(outline, outlineNode{
+					key:   traceKey(tree, n),
+					label: n.Path[strings.LastIndexByte(n.Path, '/')+1:] + " #" + n.ID.String(),
+					depth: depth,
+				})
+				walk(n.Path, depth+1)
+			}
+		}
+
+		walk("", depth)
+
+		// The nodes whose parents never appeared.
+		var orphans []string
+		for path := range t.nodes {
+			if i := strings.LastIndexByte(path, '/'); i >= 0 && t.nodes[path[:i]] == nil {
+				orphans = append(orphans, path)
+			}
+		}
+		sort.Strings(orphans)
+
+		for _, path := range orphans {
+			n := t.nodes[path]
+			outline = append(outline, outlineNode{
+				key:   traceKey(tree, n),
+				label: n.Path + " #" + n.ID.String(),
+				depth: depth,
+			})
+			walk(n.Path, depth+1)
+		}
+	}
+
+	return outline
+}
+
+// Convert the trace frame. The DataSet values are accumulated in
+// data.
+func traceFrame(tree string, tf *bevtree.TraceFrame, data map[string]string) *frame {
+	f := &frame{
+		updateSeri: tf.UpdateSeri,
+		status:     map[string]string{},
+		desc:       tf.Time.Format("15:04:05.000") + "  result " + tf.Result.String(),
+	}
+
+	if tf.Released {
+		f.desc = tf.Time.Format("15:04:05.000") + "  released"
+	}
+
+	for _, e := range tf.Events {
+		n := tf.Node(e)
+		if n == nil {
+			continue
+		}
+
+		switch e.Type {
+		case bevtree.EventAgentInit, bevtree.EventAgentUpdate, bevtree.EventAgentChildTerminated:
+			if e.Result != bevtree.Running {
+				f.status[traceKey(tree, n)] = e.Result.String()
+			}
+		}
+	}
+
+	for _, n := range tf.Running {
+		f.status[traceKey(tree, n)] = "running"
+	}
+
+	for _, c := range tf.DataSet {
+		if c.Removed {
+			delete(data, c.Key)
+		} else {
+			data[c.Key] = c.Value
+		}
+	}
+
+	for k, v := range data {
+		f.data = append(f.data, k+"="+v)
+	}
+	sort.Strings(f.data)
+
+	return f
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// The state of entity after an update.
+type frame struct {
+	updateSeri uint32
+
+	// The description of update, e.g. the time and result.
+	desc string
+
+	// The status of nodes, running, success or failure, keyed by
+	// outline key.
+	status map[string]string
+
+	// The key of node paused before.
+	paused string
+
+	// The values of DataSet, formatted as key=value.
+	data []string
+}
+
+// A line of outline.
+type outlineNode struct {
+	key   string
+	label string
+	depth int
+}
+
+// The terminal view of entity.
+type view struct {
+	title   string
+	outline []outlineNode
+	frames  []*frame
+	cur     int
+	follow  bool
+	scroll  int
+	note    string
+	history int
+
+	// The debug client when attached, nil when replaying.
+	client *debugClient
+}
+
+// Add frame to history, the view moves to it if following.
+func (v *view) add(f *frame) {
+	v.frames = append(v.frames, f)
+	if v.history > 0 && len(v.frames) > v.history {
+		n := len(v.frames) - v.history
+		v.frames = append(v.frames[:0], v.frames[n:]...)
+		if v.cur -= n; v.cur < 0 {
+			v.cur = 0
+		}
+	}
+
+	if v.follow {
+		v.cur = len(v.frames) - 1
+	}
+}
+
+// Handle key, returns false to quit.
+func (v *view) key(k string) bool {
+	switch k {
+	case "q", "\x03":
+		return false
+
+	case "left", "h":
+		if v.cur > 0 {
+			v.cur--
+		}
+		v.follow = false
+
+	case "right", "l":
+		if v.cur < len(v.frames)-1 {
+			v.cur++
+		}
+		v.follow = v.client != nil && v.cur == len(v.frames)-1
+
+	case "up", "k":
+		if v.scroll > 0 {
+			v.scroll--
+		}
+
+	case "down", "j":
+		v.scroll++
+
+	case "g":
+		v.cur = 0
+		v.follow = false
+
+	case "G":
+		v.cur = len(v.frames) - 1
+		v.follow = v.client != nil
+
+	case "f":
+		if v.client != nil {
+			v.follow = !v.follow
+			if v.follow {
+				v.cur = len(v.frames) - 1
+			}
+		}
+
+	case "P":
+		v.command("pause")
+
+	case "c":
+		v.command("continue")
+
+	case "s":
+		v.command("step")
+	}
+
+	return true
+}
+
+func (v *view) command(cmd string) {
+	if v.client == nil {
+		return
+	}
+
+	if err := v.client.send(cmd); err != nil {
+		v.note = err.Error()
+	} else {
+		v.note = cmd
+	}
+}
+
+const redrawInterval = 100 * time.Millisecond
+
+// Run the view until quit.
+func (v *view) run() error {
+	t, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	keys := readKeys(os.Stdin)
+
+	var events <-chan liveEvent
+	if v.client != nil {
+		events = v.client.events
+		defer v.client.close()
+	}
+
+	// The updates are drawn at most every redrawInterval.
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+
+	v.draw(t)
+	dirty := false
+	for {
+		select {
+		case k, ok := <-keys:
+			if !ok || !v.key(k) {
+				return nil
+			}
+			v.draw(t)
+			dirty = false
+
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				v.note = "disconnected"
+			} else {
+				if ev.frame != nil {
+					v.add(ev.frame)
+				}
+				if ev.note != "" {
+					v.note = ev.note
+				}
+			}
+			dirty = true
+
+		case <-ticker.C:
+			if dirty {
+				v.draw(t)
+				dirty = false
+			}
+		}
+	}
+}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiInverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+)
+
+var statusMarks = map[string]struct{ mark, color string }{
+	"running": {"*", ansiYellow},
+	"success": {"+", ansiGreen},
+	"failure": {"-", ansiRed},
+}
+
+func (v *view) draw(t *terminal) {
+	rows, cols := t.size()
+	w := bufio.NewWriter(os.Stdout)
+	v.render(w, rows, cols)
+	w.Flush()
+}
+
+// Render the view to w in the terminal size.
+func (v *view) render(w *bufio.Writer, rows, cols int) {
+	// Home and clear.
+	w.WriteString("\x1b[H\x1b[2J")
+
+	line := func(color, s string) {
+		w.WriteString(color)
+		w.WriteString(truncate(s, cols))
+		w.WriteString(ansiReset)
+		w.WriteString("\r\n")
+	}
+
+	var f *frame
+	header := "bevtop  " + v.title
+	if len(v.frames) > 0 {
+		f = v.frames[v.cur]
+		header += fmt.Sprintf("  update %d (%d/%d)", f.updateSeri, v.cur+1, len(v.frames))
+	} else {
+		header += "  waiting for update"
+	}
+	if v.follow {
+		header += "  FOLLOW"
+	}
+	line(ansiBold, header)
+
+	desc := ""
+	if f != nil {
+		desc = f.desc
+	}
+	line(ansiDim, desc)
+
+	// The rows left for outline, reserve the data and help lines.
+	height := rows - 5
+	if height < 1 {
+		height = 1
+	}
+	if max := len(v.outline) - height; v.scroll > max {
+		v.scroll = max
+	}
+	if v.scroll < 0 {
+		v.scroll = 0
+	}
+
+	for i := v.scroll; i < len(v.outline) && i < v.scroll+height; i++ {
+		n := &v.outline[i]
+		mark, color := " ", ""
+		if f != nil {
+			if m, ok := statusMarks[f.status[n.key]]; ok {
+				mark, color = m.mark, m.color
+			}
+			if f.paused == n.key {
+				color += ansiInverse
+			}
+		}
+		line(color, fmt.Sprintf("%s[%s] %s", strings.Repeat("  ", n.depth), mark, n.label))
+	}
+
+	for i := len(v.outline) - v.scroll; i < height; i++ {
+		w.WriteString("\r\n")
+	}
+
+	data := ""
+	if f != nil {
+		data = "data: " + strings.Join(f.data, " ")
+	}
+	line("", data)
+
+	help := "←/→ update  ↑/↓ scroll  g/G first/latest  q quit"
+	if v.client != nil {
+		help += "  f follow  P pause  c continue  s step"
+	}
+	if v.note != "" {
+		help += "  | " + v.note
+	}
+	line(ansiDim, help)
+}
+
+// Truncate s to n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	r := []rune(s)
+	return string(r[:n])
+}
+
+// The terminal in cbreak mode, keys are read without echo and
+// line buffering.
+type terminal struct {
+	state      string
+	rows, cols int
+	sized      time.Time
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, errors.WithMessage(err, "stdin is not a terminal")
+	}
+
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return nil, errors.WithMessage(err, "set terminal mode")
+	}
+
+	// Switch to the alternate screen, hide cursor.
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	return &terminal{state: state}, nil
+}
+
+func (t *terminal) close() {
+	os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	stty(t.state)
+}
+
+// Get the rows and columns of terminal, checked at most every
+// second.
+func (t *terminal) size() (int, int) {
+	if time.Since(t.sized) < time.Second {
+		return t.rows, t.cols
+	}
+
+	t.rows, t.cols, t.sized = 24, 80, time.Now()
+	if out, err := stty("size"); err == nil {
+		if fields := strings.Fields(out); len(fields) == 2 {
+			rows, err1 := strconv.Atoi(fields[0])
+			cols, err2 := strconv.Atoi(fields[1])
+			if err1 == nil && err2 == nil && rows > 0 && cols > 0 {
+				t.rows, t.cols = rows, cols
+			}
+		}
+	}
+
+	return t.rows, t.cols
+}
+
+// Read keys from f, the arrow keys are named up, down, left and
+// right.
+func readKeys(f *os.File) <-chan string {
+	keys := make(chan string)
+
+	go func() {
+		defer close(keys)
+
+		r := bufio.NewReader(f)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+
+			k := string(b)
+			if b == '\x1b' && r.Buffered() >= 2 {
+				seq := make([]byte, 2)
+				r.Read(seq)
+				switch string(seq) {
+				case "[A":
+					k = "up"
+				case "[B":
+					k = "down"
+				case "[C":
+					k = "right"
+				case "[D":
+					k = "left"
+				}
+			}
+
+			keys <- k
+		}
+	}()
+
+	return keys
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GodYY/bevtree"
+)
+
+const count = bevtree.BevType("count")
+
+// Succeed after Limited updates.
+type bevCount struct {
+	Limited int
+}
+
+func (bevCount) BevType() bevtree.BevType { return count }
+
+func (b *bevCount) CreateInstance() bevtree.BevInstance {
+	return &bevCountEntity{bevCount: b}
+}
+
+func (b *bevCount) DestroyInstance(bevtree.BevInstance) {}
+
+type bevCountEntity struct {
+	*bevCount
+	n int
+}
+
+func (b *bevCountEntity) BevType() bevtree.BevType      { return count }
+func (b *bevCountEntity) OnInit(_ bevtree.Context) bool { return true }
+func (b *bevCountEntity) OnTerminate(_ bevtree.Context) {}
+
+func (b *bevCountEntity) OnUpdate(_ bevtree.Context) bevtree.Result {
+	if b.n++; b.n >= b.Limited {
+		return bevtree.Success
+	}
+	return bevtree.Running
+}
+
+// Render the frame at cur, returns the lines.
+func renderLines(v *view, cur int) []string {
+	v.cur = cur
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	v.render(w, 24, 80)
+	w.Flush()
+
+	return strings.Split(buf.String(), "\r\n")
+}
+
+// Get the line of outline contains label.
+func findLine(lines []string, label string) string {
+	for _, line := range lines {
+		if strings.Contains(line, label) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestSnapshotView(t *testing.T) {
+	root := &debugTreeNode{
+		ID:       1,
+		NodeType: "root",
+		Path:     "root",
+		Children: []*debugTreeNode{{
+			ID:       2,
+			NodeType: "selector",
+			Path:     "root/selector",
+			Children: []*debugTreeNode{
+				{ID: 3, NodeType: "bev", BevType: "func", Path: "root/selector/func"},
+				{ID: 4, NodeType: "bev", BevType: "func", Path: "root/selector/func[1]"},
+			},
+		}},
+	}
+
+	// The result of func[1] is of an earlier update.
+	s := &bevtree.DebugSnapshot{
+		UpdateSeri: 2,
+		Nodes: []*bevtree.DebugNode{{
+			Path: "root",
+			Children: []*bevtree.DebugNode{{
+				Path:     "root/selector",
+				Children: []*bevtree.DebugNode{{Path: "root/selector/func"}},
+			}},
+		}},
+		DataSet: map[string]json.RawMessage{"key": json.RawMessage("1")},
+		Results: map[string]string{
+			"root/selector/func":    "failure",
+			"root/selector/func[1]": "success",
+		},
+		Terminated: []string{},
+	}
+
+	v := &view{title: "test", outline: debugOutline(root)}
+	v.add(snapshotFrame(s))
+
+	s.UpdateSeri = 3
+	s.Nodes = []*bevtree.DebugNode{}
+	s.Terminated = []string{"root/selector/func"}
+	v.add(snapshotFrame(s))
+
+	if len(v.outline) != 4 || v.outline[3].depth != 2 {
+		t.Fatalf("unexpected outline %v", v.outline)
+	}
+
+	lines := renderLines(v, 0)
+	if !strings.Contains(lines[0], "update 2 (1/2)") {
+		t.Fatalf("unexpected header %q", lines[0])
+	}
+	if line := findLine(lines, "] func[1] #4"); !strings.Contains(line, "[ ] func[1] #4") {
+		t.Fatalf("the stale result marked: %q", line)
+	}
+	if line := findLine(lines, "] func #3"); !strings.Contains(line, "    [*] func #3") {
+		t.Fatalf("the running node not marked: %q", line)
+	}
+	if line := findLine(lines, "data:"); !strings.Contains(line, "data: key=1") {
+		t.Fatalf("unexpected data %q", line)
+	}
+
+	lines = renderLines(v, 1)
+	if line := findLine(lines, "] func #3"); !strings.Contains(line, "    [-] func #3") {
+		t.Fatalf("the terminated node not marked: %q", line)
+	}
+	if line := findLine(lines, "] selector #2"); !strings.Contains(line, "  [ ] selector #2") {
+		t.Fatalf("unexpected selector %q", line)
+	}
+}
+
+func TestReplayView(t *testing.T) {
+	framework := bevtree.NewFramework()
+	framework.RegisterBevType(count, func() bevtree.Bev { return new(bevCount) })
+
+	tree := bevtree.NewTree("test replay")
+	seq := bevtree.NewSequenceNode()
+	tree.Root().SetChild(seq)
+	seq.AddChild(bevtree.NewBevNode(&bevCount{Limited: 2}))
+	if err := tree.AssignNodeIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.xml")
+	exporter := bevtree.NewExporter(framework)
+	exporter.AddTree(tree, "replay.xml")
+	if err := exporter.Export(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := framework.Init(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tracePath := filepath.Join(dir, "test.trace")
+	recorder, err := bevtree.CreateTraceFile(tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := framework.CreateEntity("test replay", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity.SetObserver(recorder)
+	entity.Update()
+	entity.Update()
+	entity.Release()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := replay(tracePath, 0, "test replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(v.frames) != 3 || len(v.outline) != 3 {
+		t.Fatalf("unexpected frames %d, outline %v", len(v.frames), v.outline)
+	}
+
+	lines := renderLines(v, 0)
+	if !strings.Contains(lines[0], "test replay #") || !strings.Contains(lines[1], "result running") {
+		t.Fatalf("unexpected header %q %q", lines[0], lines[1])
+	}
+	if line := findLine(lines, "] count #"); !strings.Contains(line, "    [*] count #") {
+		t.Fatalf("the running node not marked: %q", line)
+	}
+
+	lines = renderLines(v, 1)
+	if line := findLine(lines, "] count #"); !strings.Contains(line, "    [+] count #") {
+		t.Fatalf("the terminated node not marked: %q", line)
+	}
+	if line := findLine(lines, "] sequence #"); !strings.Contains(line, "  [+] sequence #") {
+		t.Fatalf("the terminated node not marked: %q", line)
+	}
+
+	if _, err := replay(tracePath, 0, "test other"); err == nil {
+		t.Fatal("replay tree not traced should fail")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"math/rand"
 
 	"github.com/GodYY/gutils/assert"
+	"github.com/pkg/errors"
 )
 
 // The CompositeNode Interface represents the common functions that
@@ -60,6 +61,20 @@ func (c *compositeNode) RemoveChild(idx int) Node {
 	return child
 }
 
+func (c *compositeNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteChildren(len(c.children), func(i int, _ PropertyWriter) Node { return c.children[i] })
+	return nil
+}
+
+// Read the children with parent.
+func (c *compositeNode) unmarshalProperties(p PropertyReader, parent Node) {
+	for i := 0; i < p.ChildCount(); i++ {
+		child, _ := p.ChildAt(i)
+		c.addChild(child)
+		child.SetParent(parent)
+	}
+}
+
 // Sequence node runs child node one bye one until a child
 // returns failure. It returns the result of the last
 // running node.
@@ -80,6 +95,11 @@ func (s *SequenceNode) AddChild(child Node) {
 	child.SetParent(s)
 }
 
+func (s *SequenceNode) UnmarshalBTProperties(p PropertyReader) error {
+	s.compositeNode.unmarshalProperties(p, s)
+	return nil
+}
+
 // The sequence node task.
 type sequenceTask struct {
 	node        *SequenceNode
@@ -135,6 +155,11 @@ func (s *SelectorNode) AddChild(child Node) {
 	child.SetParent(s)
 }
 
+func (s *SelectorNode) UnmarshalBTProperties(p PropertyReader) error {
+	s.compositeNode.unmarshalProperties(p, s)
+	return nil
+}
+
 // The selector node task.
 type selectorTask struct {
 	node        *SelectorNode
@@ -170,8 +195,8 @@ func (s *selectorTask) OnChildTerminated(result Result, nextChildNodes NodeList,
 	}
 }
 
-// Get a random sequence of nodes.
-func genRandNodes(nodes []Node) []Node {
+// Get a random sequence of nodes with r.
+func genRandNodes(nodes []Node, r *rand.Rand) []Node {
 	count := len(nodes)
 	if count == 0 {
 		return nil
@@ -183,7 +208,7 @@ func genRandNodes(nodes []Node) []Node {
 			result[i] = nodes[i]
 		}
 
-		k := rand.Intn(i + 1)
+		k := r.Intn(i + 1)
 		if k != i {
 			if result[k] == nil {
 				result[k] = nodes[k]
@@ -220,6 +245,11 @@ func (s *RandSequenceNode) AddChild(child Node) {
 	child.SetParent(s)
 }
 
+func (s *RandSequenceNode) UnmarshalBTProperties(p PropertyReader) error {
+	s.compositeNode.unmarshalProperties(p, s)
+	return nil
+}
+
 // The randome sequence node task.
 type randSequenceTask struct {
 	node        *RandSequenceNode
@@ -235,7 +265,7 @@ func (s *randSequenceTask) OnCreate(node Node) {
 }
 
 func (s *randSequenceTask) OnInit(nextChildNodes NodeList, ctx Context) bool {
-	if s.childs = genRandNodes(s.node.children); len(s.childs) == 0 {
+	if s.childs = genRandNodes(s.node.children, ctx.Rand()); len(s.childs) == 0 {
 		return false
 	} else {
 		nextChildNodes.PushNode(s.childs[s.curChildIdx])
@@ -280,6 +310,11 @@ func (s *RandSelectorNode) AddChild(child Node) {
 	child.SetParent(s)
 }
 
+func (s *RandSelectorNode) UnmarshalBTProperties(p PropertyReader) error {
+	s.compositeNode.unmarshalProperties(p, s)
+	return nil
+}
+
 // The random selector task.
 type randSelectorTask struct {
 	node        *RandSelectorNode
@@ -295,7 +330,7 @@ func (s *randSelectorTask) OnCreate(node Node) {
 }
 
 func (s *randSelectorTask) OnInit(nextChildNodes NodeList, ctx Context) bool {
-	s.childs = genRandNodes(s.node.children)
+	s.childs = genRandNodes(s.node.children, ctx.Rand())
 	if len(s.childs) == 0 {
 		return false
 	} else {
@@ -362,6 +397,43 @@ func (n *WeightSelectorNode) AddChild(child Node, weight float32) {
 	n.children = append(n.children, &weightNode{node: child, weight: weight})
 }
 
+func (n *WeightSelectorNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteChildren(len(n.children), func(i int, w PropertyWriter) Node {
+		w.WriteFloat(PropertyWeight, float64(n.children[i].weight))
+		return n.children[i].node
+	})
+	return nil
+}
+
+func (n *WeightSelectorNode) UnmarshalBTProperties(p PropertyReader) error {
+	children := make([]*weightNode, 0, p.ChildCount())
+	var total float32
+	for i := 0; i < p.ChildCount(); i++ {
+		child, props := p.ChildAt(i)
+		weight, err := props.ReadFloat(PropertyWeight)
+		if err != nil {
+			return errors.WithMessagef(err, "No.%d child", i)
+		}
+
+		// The same as AddChild.
+		if weight <= 0 {
+			return errors.Errorf("No.%d child: weight %v <= 0", i, weight)
+		}
+		if total += float32(weight); total > 1 {
+			return errors.Errorf("No.%d child: total weight > 1", i)
+		}
+
+		children = append(children, &weightNode{node: child, weight: float32(weight)})
+	}
+
+	for _, child := range children {
+		child.node.SetParent(n)
+	}
+
+	n.children = children
+	return nil
+}
+
 type weightSelectorTask struct {
 	node *WeightSelectorNode
 }
@@ -386,7 +458,7 @@ func (t *weightSelectorTask) OnInit(nextChildNodes NodeList, ctx Context) bool {
 		return false
 	}
 
-	r := rand.Float32()
+	r := ctx.Rand().Float32()
 	w := float32(0)
 	for i := 0; i < t.node.ChildCount(); i++ {
 		node, weight := t.node.Child(i)
@@ -445,6 +517,11 @@ func (p *ParallelNode) AddChild(child Node) {
 	child.SetParent(p)
 }
 
+func (p *ParallelNode) UnmarshalBTProperties(r PropertyReader) error {
+	p.compositeNode.unmarshalProperties(r, p)
+	return nil
+}
+
 // The parallel node task.
 type parallelTask struct {
 	node      *ParallelNode
@@ -1,13 +1,28 @@
 package bevtree
 
 import (
+	"bytes"
 	"encoding/xml"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// The format of tree file.
+type TreeFormat string
+
+// Tree formats.
+const (
+	TreeFormatXML    = TreeFormat("xml")
+	TreeFormatJSON   = TreeFormat("json")
+	TreeFormatBinary = TreeFormat("binary")
+)
+
 // Tree resource entry.
 type TreeEntry struct {
 	// Tree name.
@@ -15,10 +30,96 @@ type TreeEntry struct {
 
 	// Tree path.
 	Path string `xml:"path,attr"`
+
+	// Tree format. If empty, the format is chosen by the extension
+	// of path, XML by default.
+	Format TreeFormat `xml:"format,attr,omitempty"`
+}
+
+func (e *TreeEntry) format() TreeFormat {
+	if e.Format != "" {
+		return e.Format
+	}
+
+	return treeFormatOf(e.Path)
+}
+
+// Get the tree format by the extension of path.
+func treeFormatOf(p string) TreeFormat {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".json":
+		return TreeFormatJSON
+	case ".bin":
+		return TreeFormatBinary
+	default:
+		return TreeFormatXML
+	}
+}
+
+// Decode the tree file in format, from the file system of framework
+// if set.
+func (s *Framework) decodeTreeFile(path string, format TreeFormat, t *tree) error {
+	if s.fsys != nil {
+		return s.decodeTreeFS(s.fsys, path, format, t)
+	}
+
+	switch format {
+	case TreeFormatXML:
+		return s.DecodeXMLTreeFile(path, t)
+	case TreeFormatJSON:
+		return s.DecodeJSONTreeFile(path, t)
+	case TreeFormatBinary:
+		return s.DecodeBinaryTreeFile(path, t)
+	default:
+		return errors.Errorf("decode tree file \"%s\": unknown format \"%s\"", path, format)
+	}
+}
+
+// Decode the tree file in format from fsys.
+func (s *Framework) decodeTreeFS(fsys fs.FS, path string, format TreeFormat, t *tree) error {
+	switch format {
+	case TreeFormatXML:
+		return s.DecodeXMLTreeFS(fsys, path, t)
+	case TreeFormatJSON:
+		return s.DecodeJSONTreeFS(fsys, path, t)
+	case TreeFormatBinary:
+		return s.DecodeBinaryTreeFS(fsys, path, t)
+	default:
+		return errors.Errorf("decode tree file \"%s\": unknown format \"%s\"", path, format)
+	}
+}
+
+// Write the encoding of tree in format to w.
+func (s *Framework) encodeTree(w io.Writer, format TreeFormat, t *tree) error {
+	var data []byte
+	var err error
+
+	switch format {
+	case TreeFormatXML:
+		return encodeXMLTree(s, w, t)
+	case TreeFormatJSON:
+		if data, err = MarshalJSONTree(s, t); err == nil {
+			data = append(data, '\n')
+		}
+	case TreeFormatBinary:
+		data, err = MarshalBinaryTree(s, t)
+	default:
+		return errors.Errorf("unknown format \"%s\"", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
 }
 
 // Framework config.
 type Config struct {
+	// Format version, see Framework.ConfigXMLVersion.
+	Version int `xml:"version,attr,omitempty"`
+
 	// Load all trees when initializing.
 	LoadAll bool `xml:"loadall"`
 
@@ -26,7 +127,7 @@ type Config struct {
 	TreeEntries []*TreeEntry `xml:"bevtrees>bevtree"`
 }
 
-func loadConfig(path string) (*Config, error) {
+func (s *Framework) loadConfig(path string) (*Config, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -34,7 +135,33 @@ func loadConfig(path string) (*Config, error) {
 
 	defer file.Close()
 
-	decoder := xml.NewDecoder(file)
+	return s.readConfig(file, path)
+}
+
+func (s *Framework) loadConfigFS(fsys fs.FS, path string) (*Config, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return s.readConfig(file, path)
+}
+
+// Read the config from r, the older versions are migrated before
+// decoding.
+func (s *Framework) readConfig(r io.Reader, path string) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, _, err = s.migrateXML(data, XMLStringConfig, s.configMigrations, path); err != nil {
+		return nil, errors.WithMessagef(err, "load config %s", path)
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
 
 	xmlNameConfig := XMLName(XMLStringConfig)
 	var cfgStart xml.StartElement
@@ -58,18 +185,18 @@ func loadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
-func saveConfig(config *Config, path string) (err error) {
+func saveConfig(config *Config, fsys WriteFS, path string) (err error) {
 	if config == nil {
 		return nil
 	}
 
-	file, err := os.Create(path)
+	file, err := fsys.Create(path)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
-		if e := file.Close(); e != nil {
+		if e := file.Close(); err == nil {
 			err = e
 		}
 	}()
@@ -85,6 +212,30 @@ func saveConfig(config *Config, path string) (err error) {
 	return nil
 }
 
+// WriteFS is the file system that the Exporter writes to.
+type WriteFS interface {
+	// Create creates or truncates the file name, and the parent
+	// directories if not exist. The name is slash-separated.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirWriteFS returns a WriteFS for the files under the directory
+// dir of operation system.
+func DirWriteFS(dir string) WriteFS {
+	return dirWriteFS(dir)
+}
+
+type dirWriteFS string
+
+func (dir dirWriteFS) Create(name string) (io.WriteCloser, error) {
+	p := filepath.Join(string(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return os.Create(p)
+}
+
 // The tool for exporting config and tree resources.
 type Exporter struct {
 	framework *Framework
@@ -104,7 +255,15 @@ func (e *Exporter) SetLoadAll(loadall bool) {
 	e.config.LoadAll = loadall
 }
 
+// AddTree adds the tree exported to path, the format is chosen by
+// the extension of path.
 func (e *Exporter) AddTree(tree *tree, path string) error {
+	return e.AddTreeWithFormat(tree, path, "")
+}
+
+// AddTreeWithFormat adds the tree exported to path in format. The
+// format is recorded in config if not empty.
+func (e *Exporter) AddTreeWithFormat(tree *tree, path string, format TreeFormat) error {
 	if tree == nil {
 		return nil
 	}
@@ -114,12 +273,19 @@ func (e *Exporter) AddTree(tree *tree, path string) error {
 	}
 
 	e.trees[tree.Name()] = tree
-	e.config.TreeEntries = append(e.config.TreeEntries, &TreeEntry{Name: tree.Name(), Path: path})
+	e.config.TreeEntries = append(e.config.TreeEntries, &TreeEntry{Name: tree.Name(), Path: path, Format: format})
 
 	return nil
 }
 
+// Export writes the config to configPath, and the trees to their
+// paths relative to the directory of config.
 func (e *Exporter) Export(configPath string) error {
+	return e.ExportFS(dirWriteFS(""), configPath)
+}
+
+// ExportFS works like Export but writes the files to fsys.
+func (e *Exporter) ExportFS(fsys WriteFS, configPath string) error {
 	for _, ta := range e.config.TreeEntries {
 		tree := e.trees[ta.Name]
 		if tree == nil {
@@ -129,27 +295,32 @@ func (e *Exporter) Export(configPath string) error {
 
 	rootPath := path.Dir(configPath)
 
-	if err := os.MkdirAll(rootPath, os.ModePerm); err != nil && !os.IsExist(err) {
-		return err
-	}
-
 	for _, ta := range e.config.TreeEntries {
-		tree := e.trees[ta.Name]
-
 		treepath := path.Join(rootPath, ta.Path)
-		dir := path.Dir(treepath)
-		if err := os.MkdirAll(dir, os.ModePerm); err != nil && !os.IsExist(err) {
-			return err
-		}
-
-		if err := e.framework.EncodeXMLTreeFile(treepath, tree); err != nil {
-			return err
+		if err := e.exportTree(fsys, treepath, ta.format(), e.trees[ta.Name]); err != nil {
+			return errors.WithMessagef(err, "bevtree exporter Export: tree \"%s\" to \"%s\"", ta.Name, treepath)
 		}
 	}
 
-	if err := saveConfig(e.config, configPath); err != nil {
+	e.config.Version = e.framework.ConfigXMLVersion()
+	if err := saveConfig(e.config, fsys, configPath); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (e *Exporter) exportTree(fsys WriteFS, path string, format TreeFormat, t *tree) (err error) {
+	file, err := fsys.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if e := file.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	return e.framework.encodeTree(file, format, t)
+}
@@ -0,0 +1,110 @@
+package bevtree
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// The WriteFS writes files to fstest.MapFS.
+type mapWriteFS fstest.MapFS
+
+type mapWriteFile struct {
+	bytes.Buffer
+	fsys mapWriteFS
+	name string
+}
+
+func (f *mapWriteFile) Close() error {
+	f.fsys[f.name] = &fstest.MapFile{Data: f.Bytes()}
+	return nil
+}
+
+func (fsys mapWriteFS) Create(name string) (io.WriteCloser, error) {
+	return &mapWriteFile{fsys: fsys, name: name}, nil
+}
+
+// The WriteFS writes files to zip archive.
+type zipWriteFS struct {
+	*zip.Writer
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (fsys zipWriteFS) Create(name string) (io.WriteCloser, error) {
+	w, err := fsys.Writer.Create(name)
+	return nopWriteCloser{w}, err
+}
+
+func TestInitFS(t *testing.T) {
+	key := "key"
+	framework := newTestFramework()
+	mainTree := buildCodecTestTree(framework, "main", "sub", key)
+	sub, _ := framework.getOrLoadTree("sub")
+	sum := runCodecTestTree(t, framework, "main", key, 1)
+
+	exporter := NewExporter(framework)
+	exporter.AddTree(sub, "trees/sub.bin")
+	exporter.AddTree(mainTree, "trees/main.json")
+
+	mapFS := fstest.MapFS{}
+	if err := exporter.ExportFS(mapWriteFS(mapFS), "bevtree/config.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := exporter.ExportFS(zipWriteFS{zw}, "config.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zipFS, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		fsys    fs.FS
+		cfgPath string
+	}{
+		{"map", mapFS, "bevtree/config.xml"},
+		{"zip", zipFS, "config.xml"},
+	} {
+		framework := NewFramework()
+		framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+		if err := framework.InitFS(c.fsys, c.cfgPath); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		// The trees are loaded on demand.
+		if v := runCodecTestTree(t, framework, "main", key, 1); v != sum {
+			t.Fatalf("%s: sum(%d) != %d", c.name, v, sum)
+		}
+	}
+
+	delete(mapFS, "bevtree/trees/sub.bin")
+	framework = NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	if err := framework.InitFS(mapFS, "bevtree/config.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := framework.GetOrLoadTree("main"); err == nil {
+		t.Fatal("load tree without subtree file should fail")
+	}
+
+	if err := NewFramework().InitFS(mapFS, "config.xml"); err == nil {
+		t.Fatal("init without config file should fail")
+	}
+}
@@ -1,6 +1,8 @@
 package bevtree
 
 import (
+	gocontext "context"
+	"math/rand"
 	"reflect"
 	"strings"
 	"time"
@@ -20,9 +22,22 @@ type Context interface {
 	// Get the update serial number.
 	UpdateSeri() uint32
 
+	// Get the time of the latest update.
+	Now() time.Time
+
+	// Get the time passed between the latest two updates.
+	DeltaTime() time.Duration
+
+	// Get the time passed since the entity started.
+	ElapsedTime() time.Duration
+
 	// Get data-set.
 	DataSet() DataSet
 
+	// Get the random source of the entity. Seed it with
+	// WithRandSeed to make the running reproducible.
+	Rand() *rand.Rand
+
 	// Get Framework.
 	framework() *Framework
 
@@ -32,11 +47,23 @@ type Context interface {
 	// Reset the Context.
 	reset()
 
-	// Update.
-	update()
+	// Update with the time passed since the latest update.
+	update(dt time.Duration)
 
 	cloneWithTree(tree Tree, independentDataSet bool) Context
 
+	// Get the observer of entity.
+	getObserver() Observer
+
+	// Set the observer of entity.
+	setObserver(Observer)
+
+	// Set the pprof labels of node to current goroutine.
+	setProfileLabels(node Node)
+
+	// Restore the pprof labels of current goroutine.
+	clearProfileLabels()
+
 	internal
 }
 
@@ -47,13 +74,45 @@ type context struct {
 	updateSeri   uint32
 	dataSet      *dataSet
 	dataSetOwner bool
+	randSeed     int64
+	randSeeded   bool
+	rand         *rand.Rand
+	time         *contextTime
+	timeOwner    bool
+	observer     Observer
+
+	// The pprof labels of the caller, the labels of the running
+	// callback, and the context running the subtree.
+	profileCtx    gocontext.Context
+	profileCur    gocontext.Context
+	profileParent *context
 
 	internalImpl
 }
 
-func newContext(framework *Framework, tree Tree, userData interface{}) *context {
+// The time state of context.
+type contextTime struct {
+	now         time.Time
+	deltaTime   time.Duration
+	elapsedTime time.Duration
+}
+
+func (t *contextTime) reset(now time.Time) {
+	t.now = now
+	t.deltaTime = 0
+	t.elapsedTime = 0
+}
+
+func (t *contextTime) advance(dt time.Duration) {
+	t.now = t.now.Add(dt)
+	t.deltaTime = dt
+	t.elapsedTime += dt
+}
+
+func newContext(framework *Framework, tree Tree, userData interface{}, opts *entityOptions) *context {
 	assert.Assert(framework != nil, "framework nil")
 	assert.Assert(tree != nil, "tree nil")
+	assert.Assert(opts != nil, "opts nil")
 
 	ctx := &context{
 		_framework:   framework,
@@ -61,6 +120,12 @@ func newContext(framework *Framework, tree Tree, userData interface{}) *context
 		userData:     userData,
 		dataSet:      newDataSet(),
 		dataSetOwner: true,
+		randSeed:     opts.randSeed,
+		randSeeded:   opts.randSeeded,
+		time:         &contextTime{now: framework.now()},
+		timeOwner:    true,
+		observer:     framework.observer,
+		profileCtx:   opts.profileCtx,
 	}
 
 	return ctx
@@ -76,6 +141,30 @@ func (ctx *context) DataSet() DataSet { return ctx.dataSet }
 
 func (ctx *context) UpdateSeri() uint32 { return ctx.updateSeri }
 
+func (ctx *context) Now() time.Time { return ctx.time.now }
+
+func (ctx *context) DeltaTime() time.Duration { return ctx.time.deltaTime }
+
+func (ctx *context) ElapsedTime() time.Duration { return ctx.time.elapsedTime }
+
+// Rand creates the random source lazily, most of entities
+// never use it.
+func (ctx *context) Rand() *rand.Rand {
+	if ctx.rand == nil {
+		if ctx.randSeeded {
+			ctx.rand = rand.New(rand.NewSource(ctx.randSeed))
+		} else {
+			ctx.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+	}
+
+	return ctx.rand
+}
+
+func (ctx *context) getObserver() Observer { return ctx.observer }
+
+func (ctx *context) setObserver(o Observer) { ctx.observer = o }
+
 func (ctx *context) release() {
 	if ctx.dataSetOwner {
 		ctx.dataSet.Clear()
@@ -83,6 +172,12 @@ func (ctx *context) release() {
 	ctx.dataSet = nil
 	ctx.userData = nil
 	ctx.tree = nil
+	ctx.rand = nil
+	ctx.time = nil
+	ctx.observer = nil
+	ctx.profileCtx = nil
+	ctx.profileCur = nil
+	ctx.profileParent = nil
 }
 
 func (ctx *context) reset() {
@@ -90,9 +185,23 @@ func (ctx *context) reset() {
 	if ctx.dataSetOwner {
 		ctx.dataSet.Clear()
 	}
+
+	if ctx.timeOwner {
+		ctx.time.reset(ctx._framework.now())
+	}
+
+	// Reseed, the seeded entity runs the same way again.
+	if ctx.randSeeded && ctx.rand != nil {
+		ctx.rand.Seed(ctx.randSeed)
+	}
 }
 
-func (ctx *context) update() { ctx.updateSeri++ }
+func (ctx *context) update(dt time.Duration) {
+	ctx.updateSeri++
+	if ctx.timeOwner {
+		ctx.time.advance(dt)
+	}
+}
 
 func (ctx *context) cloneWithTree(tree Tree, independentDataSet bool) Context {
 	assert.Assert(tree != nil, "tree nil")
@@ -102,6 +211,17 @@ func (ctx *context) cloneWithTree(tree Tree, independentDataSet bool) Context {
 		tree:       tree,
 		userData:   ctx.userData,
 		updateSeri: ctx.updateSeri,
+
+		// Subtree shares the random source and time of the
+		// entity.
+		rand:      ctx.Rand(),
+		time:      ctx.time,
+		timeOwner: false,
+
+		// Subtree is observed by the observer of the entity.
+		observer: ctx.observer,
+
+		profileParent: ctx,
 	}
 
 	if independentDataSet {
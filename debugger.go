@@ -0,0 +1,631 @@
+package bevtree
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// The breakpoint on node of tree.
+type debugBreakpoint struct {
+	Tree string `json:"tree"`
+	Node NodeID `json:"node"`
+}
+
+// DebugNode is the snapshot of running agent in DebugSnapshot.
+type DebugNode struct {
+	ID       NodeID       `json:"id"`
+	NodeType NodeType     `json:"nodetype"`
+	Path     string       `json:"path"`
+	Status   AgentStatus  `json:"status"`
+	LazyStop LazyStop     `json:"lazystop"`
+	Children []*DebugNode `json:"children,omitempty"`
+}
+
+func newDebugNode(n *ActiveNode) *DebugNode {
+	dn := &DebugNode{
+		ID:       n.ID,
+		NodeType: n.Node.NodeType(),
+		Path:     n.Path,
+		Status:   n.Status,
+		LazyStop: n.LazyStop,
+	}
+
+	for _, c := range n.Children {
+		dn.Children = append(dn.Children, newDebugNode(c))
+	}
+
+	return dn
+}
+
+// DebugSnapshot is the snapshot of entity.
+type DebugSnapshot struct {
+	Entity     uint64 `json:"entity"`
+	Tree       string `json:"tree"`
+	UpdateSeri uint32 `json:"updateseri"`
+
+	// The node paused before, nil if not paused.
+	Paused *DebugNode `json:"paused,omitempty"`
+
+	// The running agents, see Entity.ActiveNodes.
+	Nodes []*DebugNode `json:"nodes"`
+
+	// The JSON encodings of the values of DataSet, encoded when the
+	// snapshot is taken. The values can't be encoded in JSON are
+	// formatted by fmt.Sprint.
+	DataSet map[string]json.RawMessage `json:"dataset"`
+
+	// The latest results of nodes terminated, keyed by node path.
+	Results map[string]string `json:"results"`
+
+	// The sorted paths of nodes terminated in the latest update.
+	Terminated []string `json:"terminated"`
+}
+
+// DebugEntity describes an entity in Debugger.
+type DebugEntity struct {
+	ID         uint64 `json:"id"`
+	Tree       string `json:"tree"`
+	UpdateSeri uint32 `json:"updateseri"`
+	Paused     bool   `json:"paused"`
+}
+
+// The commands to paused entity.
+const (
+	debugCmdContinue = iota
+	debugCmdStep
+	debugCmdSet
+	debugCmdSnapshot
+)
+
+type debugCmd struct {
+	typ   int
+	key   string
+	value json.RawMessage
+	reply chan error
+}
+
+// The debugging state of entity. It doesn't refer to the entity,
+// so the entity not released can be finalized.
+type debugEntity struct {
+	id   uint64
+	tree string
+	t    Tree
+
+	// Set to pause before the next agent update, accessed
+	// atomically.
+	pauseFlag int32
+
+	// The latest results of nodes, accessed on the goroutine
+	// updating entity.
+	results map[Node]debugResult
+
+	// The following fields are protected by Debugger.mtx.
+	updateSeri uint32
+	paused     bool
+	watchers   int
+	snapshot   bool
+	keepLast   bool
+	last       *DebugSnapshot
+	pending    []func(*entity) error
+
+	// The commands to paused entity.
+	cmds chan debugCmd
+}
+
+// Debugger pauses entities before updating the agents of nodes
+// with breakpoints, or on request, and serves the clients to
+// inspect and control entities, see Serve. Set it by
+// Framework.SetDebugger.
+//
+// A paused entity blocks the goroutine calling Entity.Update
+// until continued. The debugger doesn't keep the entities alive,
+// the entities not released are still finalized.
+type Debugger struct {
+	mtx         sync.Mutex
+	entities    map[uint64]*debugEntity
+	breakpoints map[debugBreakpoint]bool
+	nbreak      int32
+	clients     map[*debugClient]bool
+	closed      chan struct{}
+	closeOnce   sync.Once
+	servers     []debugServer
+}
+
+// NewDebugger creates a Debugger.
+func NewDebugger() *Debugger {
+	return &Debugger{
+		entities:    map[uint64]*debugEntity{},
+		breakpoints: map[debugBreakpoint]bool{},
+		clients:     map[*debugClient]bool{},
+		closed:      make(chan struct{}),
+	}
+}
+
+// SetDebugger sets the debugger of entities.
+func (s *Framework) SetDebugger(d *Debugger) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.debugger = d
+}
+
+// SetBreakpoint sets a breakpoint on the node of tree.
+func (d *Debugger) SetBreakpoint(tree string, node NodeID) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	bp := debugBreakpoint{Tree: tree, Node: node}
+	if !d.breakpoints[bp] {
+		d.breakpoints[bp] = true
+		atomic.AddInt32(&d.nbreak, 1)
+	}
+}
+
+// ClearBreakpoint clears the breakpoint on the node of tree.
+func (d *Debugger) ClearBreakpoint(tree string, node NodeID) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	bp := debugBreakpoint{Tree: tree, Node: node}
+	if d.breakpoints[bp] {
+		delete(d.breakpoints, bp)
+		atomic.AddInt32(&d.nbreak, -1)
+	}
+}
+
+// Get the breakpoints, sorted by tree and node.
+func (d *Debugger) breakpointList() []debugBreakpoint {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	bps := make([]debugBreakpoint, 0, len(d.breakpoints))
+	for bp := range d.breakpoints {
+		bps = append(bps, bp)
+	}
+
+	sort.Slice(bps, func(i, j int) bool {
+		if bps[i].Tree != bps[j].Tree {
+			return bps[i].Tree < bps[j].Tree
+		}
+		return bps[i].Node < bps[j].Node
+	})
+
+	return bps
+}
+
+// Entities returns the entities alive, sorted by ID.
+func (d *Debugger) Entities() []DebugEntity {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	entities := make([]DebugEntity, 0, len(d.entities))
+	for id, de := range d.entities {
+		entities = append(entities, DebugEntity{
+			ID:         id,
+			Tree:       de.tree,
+			UpdateSeri: de.updateSeri,
+			Paused:     de.paused,
+		})
+	}
+
+	sort.Slice(entities, func(i, j int) bool { return entities[i].ID < entities[j].ID })
+	return entities
+}
+
+func (d *Debugger) getEntity(id uint64) (*debugEntity, error) {
+	de := d.entities[id]
+	if de == nil {
+		return nil, errors.Errorf("entity %d not found", id)
+	}
+	return de, nil
+}
+
+// Pause pauses the entity before the next agent update.
+func (d *Debugger) Pause(id uint64) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	de, err := d.getEntity(id)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&de.pauseFlag, 1)
+	return nil
+}
+
+// Continue continues the paused entity.
+func (d *Debugger) Continue(id uint64) error {
+	return d.sendCmd(id, debugCmd{typ: debugCmdContinue}, nil)
+}
+
+// Step continues the paused entity, and pauses it before the
+// next agent update.
+func (d *Debugger) Step(id uint64) error {
+	return d.sendCmd(id, debugCmd{typ: debugCmdStep}, nil)
+}
+
+// SetValue sets the value of key in the DataSet of entity. The
+// value is decoded from JSON, in the type of the current value
+// if possible. null removes the key. The value is set at once if
+// the entity is paused, or before the next update.
+func (d *Debugger) SetValue(id uint64, key string, value json.RawMessage) error {
+	return d.sendCmd(id, debugCmd{typ: debugCmdSet, key: key, value: value}, func(de *debugEntity) error {
+		de.pending = append(de.pending, func(e *entity) error { return setDebugValue(e.ctx.DataSet(), key, value) })
+		return nil
+	})
+}
+
+// Request a snapshot of entity. It is sent to clients watching
+// the entity at once if the entity is paused, or after the next
+// update.
+func (d *Debugger) requestSnapshot(id uint64) error {
+	return d.sendCmd(id, debugCmd{typ: debugCmdSnapshot}, func(de *debugEntity) error {
+		de.snapshot = true
+		return nil
+	})
+}
+
+// Send command to paused entity and wait for reply. If the entity
+// is not paused, notPaused is called with Debugger.mtx held, or an
+// error is returned if it is nil.
+func (d *Debugger) sendCmd(id uint64, cmd debugCmd, notPaused func(*debugEntity) error) error {
+	cmd.reply = make(chan error, 1)
+
+	d.mtx.Lock()
+	de, err := d.getEntity(id)
+	if err != nil {
+		d.mtx.Unlock()
+		return err
+	}
+
+	if !de.paused {
+		defer d.mtx.Unlock()
+		if notPaused != nil {
+			return notPaused(de)
+		}
+		return errors.Errorf("entity %d not paused", id)
+	}
+
+	select {
+	case de.cmds <- cmd:
+	default:
+		d.mtx.Unlock()
+		return errors.Errorf("entity %d busy", id)
+	}
+	d.mtx.Unlock()
+
+	select {
+	case err := <-cmd.reply:
+		return err
+	case <-d.closed:
+		return errors.New("debugger closed")
+	}
+}
+
+// Close closes the servers and the clients, and continues the
+// paused entities. The entities are not paused after closing.
+func (d *Debugger) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+
+	d.mtx.Lock()
+	servers := d.servers
+	d.servers = nil
+	clients := d.clients
+	d.clients = map[*debugClient]bool{}
+	d.mtx.Unlock()
+
+	var err error
+	for _, s := range servers {
+		if serr := s.Close(); err == nil {
+			err = serr
+		}
+	}
+
+	for c := range clients {
+		c.close()
+	}
+
+	return err
+}
+
+func (d *Debugger) isClosed() bool {
+	select {
+	case <-d.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Register the entity.
+func (d *Debugger) addEntity(e *entity) *debugEntity {
+	de := &debugEntity{
+		id:      e.id,
+		tree:    e.ctx.Tree().Name(),
+		t:       e.ctx.Tree(),
+		results: map[Node]debugResult{},
+		cmds:    make(chan debugCmd, 16),
+	}
+
+	d.mtx.Lock()
+	d.entities[e.id] = de
+	d.mtx.Unlock()
+
+	return de
+}
+
+// Unregister the entity.
+func (d *Debugger) removeEntity(e *entity) {
+	d.mtx.Lock()
+	delete(d.entities, e.id)
+	d.mtx.Unlock()
+
+	d.broadcast(&debugMessage{Event: debugEventReleased, Entity: e.id})
+}
+
+// Called at the beginning of entity update, apply the pending
+// changes.
+func (d *Debugger) beginUpdate(e *entity) {
+	de := e.debug
+
+	d.mtx.Lock()
+	pending := de.pending
+	de.pending = nil
+	d.mtx.Unlock()
+
+	for _, f := range pending {
+		if err := f(e); err != nil {
+			e.ctx.framework().log(LogWarn, "debugger", LogField{Key: LogKeyEntity, Value: e.id}, LogField{Key: LogKeyError, Value: err})
+		}
+	}
+}
+
+// Called at the end of entity update, send snapshot to the
+// clients watching.
+func (d *Debugger) endUpdate(e *entity) {
+	de := e.debug
+
+	d.mtx.Lock()
+	de.updateSeri = e.ctx.UpdateSeri()
+	send := de.watchers > 0 || de.snapshot
+	keep := de.keepLast
+	de.snapshot = false
+	de.keepLast = false
+	d.mtx.Unlock()
+
+	if send {
+		d.sendSnapshot(e, nil)
+	} else if keep {
+		d.keepSnapshot(de, d.snapshot(e, nil))
+	}
+}
+
+// The result of node, and the update in which it terminated.
+type debugResult struct {
+	result     Result
+	updateSeri uint32
+}
+
+func (de *debugEntity) setResult(node Node, result Result, updateSeri uint32) {
+	de.results[node] = debugResult{result: result, updateSeri: updateSeri}
+}
+
+// Keep the snapshot as the latest.
+func (d *Debugger) keepSnapshot(de *debugEntity, s *DebugSnapshot) {
+	d.mtx.Lock()
+	de.last = s
+	d.mtx.Unlock()
+}
+
+// LatestSnapshot returns the latest snapshot of entity, nil if
+// not taken yet. A new snapshot is taken after the next update,
+// so polling it keeps the snapshot fresh.
+func (d *Debugger) LatestSnapshot(id uint64) (*DebugSnapshot, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	de, err := d.getEntity(id)
+	if err != nil {
+		return nil, err
+	}
+
+	de.keepLast = true
+	return de.last, nil
+}
+
+// Get the nodes of the tree of entity.
+func (d *Debugger) entityTree(id uint64) (*nodeView, error) {
+	d.mtx.Lock()
+	de, err := d.getEntity(id)
+	d.mtx.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newNodeView(de.t, de.t.root()), nil
+}
+
+// Called before updating agent, pause if needed.
+func (d *Debugger) beforeAgentUpdate(e *entity, a *agent) {
+	de := e.debug
+	if atomic.LoadInt32(&de.pauseFlag) == 0 && (atomic.LoadInt32(&d.nbreak) == 0 || !d.hitBreakpoint(de, a.node)) {
+		return
+	}
+
+	if d.isClosed() {
+		return
+	}
+
+	atomic.StoreInt32(&de.pauseFlag, 0)
+	d.pause(e, a)
+}
+
+func (d *Debugger) hitBreakpoint(de *debugEntity, node Node) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.breakpoints[debugBreakpoint{Tree: de.tree, Node: de.t.NodeID(node)}]
+}
+
+// Pause the entity before updating a, serve the commands until
+// continued.
+func (d *Debugger) pause(e *entity, a *agent) {
+	de := e.debug
+
+	d.mtx.Lock()
+	de.paused = true
+	de.updateSeri = e.ctx.UpdateSeri()
+	d.mtx.Unlock()
+
+	s := d.snapshot(e, a)
+	d.keepSnapshot(de, s)
+	d.broadcast(&debugMessage{Event: debugEventPaused, Entity: de.id, Snapshot: s})
+
+	for {
+		var cmd debugCmd
+		select {
+		case cmd = <-de.cmds:
+		case <-d.closed:
+			d.resume(de, false)
+			return
+		}
+
+		switch cmd.typ {
+		case debugCmdContinue, debugCmdStep:
+			d.resume(de, cmd.typ == debugCmdStep)
+			cmd.reply <- nil
+			d.broadcast(&debugMessage{Event: debugEventResumed, Entity: de.id})
+			return
+
+		case debugCmdSet:
+			err := setDebugValue(e.ctx.DataSet(), cmd.key, cmd.value)
+			cmd.reply <- err
+			if err == nil {
+				d.sendSnapshot(e, a)
+			}
+
+		case debugCmdSnapshot:
+			cmd.reply <- nil
+			d.sendSnapshot(e, a)
+		}
+	}
+}
+
+// Mark the entity resumed, the commands queued fail.
+func (d *Debugger) resume(de *debugEntity, step bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	de.paused = false
+	if step {
+		atomic.StoreInt32(&de.pauseFlag, 1)
+	}
+
+	for {
+		select {
+		case cmd := <-de.cmds:
+			cmd.reply <- errors.Errorf("entity %d not paused", de.id)
+		default:
+			return
+		}
+	}
+}
+
+// Take the snapshot of entity. It must be called on the goroutine
+// updating the entity.
+func (d *Debugger) snapshot(e *entity, paused *agent) *DebugSnapshot {
+	de := e.debug
+
+	s := &DebugSnapshot{
+		Entity:     e.id,
+		Tree:       de.tree,
+		UpdateSeri: e.ctx.UpdateSeri(),
+		Nodes:      []*DebugNode{},
+		DataSet:    map[string]json.RawMessage{},
+		Results:    make(map[string]string, len(de.results)),
+		Terminated: []string{},
+	}
+
+	for node, r := range de.results {
+		path := NodePath(node)
+		s.Results[path] = r.result.String()
+		if r.updateSeri == s.UpdateSeri {
+			s.Terminated = append(s.Terminated, path)
+		}
+	}
+	sort.Strings(s.Terminated)
+
+	for _, n := range e.activeNodes(paused) {
+		s.Nodes = append(s.Nodes, newDebugNode(n))
+	}
+
+	if paused != nil {
+		s.Paused = newDebugNode(newActiveNode(e.ctx.Tree(), paused))
+		s.Paused.Children = nil
+	}
+
+	if ds, ok := e.ctx.DataSet().(*dataSet); ok {
+		for k, v := range ds.keyValues {
+			data, err := json.Marshal(v)
+			if err != nil {
+				data, _ = json.Marshal(fmt.Sprint(v))
+			}
+			s.DataSet[k] = data
+		}
+	}
+
+	return s
+}
+
+// Send snapshot to the clients watching the entity, or all
+// clients if the snapshot was requested.
+func (d *Debugger) sendSnapshot(e *entity, paused *agent) {
+	de := e.debug
+	msg := &debugMessage{Event: debugEventSnapshot, Entity: de.id, Snapshot: d.snapshot(e, paused)}
+	d.keepSnapshot(de, msg.Snapshot)
+
+	d.mtx.Lock()
+	clients := make([]*debugClient, 0, len(d.clients))
+	for c := range d.clients {
+		clients = append(clients, c)
+	}
+	d.mtx.Unlock()
+
+	for _, c := range clients {
+		if c.isWatching(de.id) || c.takeSnapshotRequest(de.id) {
+			c.send(msg)
+		}
+	}
+}
+
+// Set value of key in DataSet, decoded from JSON.
+func setDebugValue(ds DataSet, key string, value json.RawMessage) error {
+	if len(value) == 0 || string(value) == "null" {
+		ds.Remove(key)
+		return nil
+	}
+
+	if cur := ds.Get(key); cur != nil {
+		v := reflect.New(reflect.TypeOf(cur))
+		if err := json.Unmarshal(value, v.Interface()); err == nil {
+			ds.Set(key, v.Elem().Interface())
+			return nil
+		}
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return errors.WithMessagef(err, "set value of \"%s\"", key)
+	}
+
+	ds.Set(key, v)
+	return nil
+}
@@ -0,0 +1,325 @@
+package bevtree
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The server accepting debug clients.
+type debugServer interface {
+	Close() error
+}
+
+// The events sent to debug clients.
+const (
+	// The entity paused, with snapshot.
+	debugEventPaused = "paused"
+
+	// The entity resumed.
+	debugEventResumed = "resumed"
+
+	// The snapshot of entity.
+	debugEventSnapshot = "snapshot"
+
+	// The entity released.
+	debugEventReleased = "released"
+)
+
+// The request of debug client, one JSON object per line.
+//
+//	entities                     list entities
+//	tree {entity}                get the nodes of the tree of entity
+//	watch, unwatch {entity}      receive snapshot after each update
+//	snapshot {entity}            receive snapshot once
+//	break, clear {tree, node}    set or clear breakpoint
+//	breakpoints                  list breakpoints
+//	pause {entity}               pause before the next agent update
+//	continue, step {entity}      continue the paused entity
+//	set {entity, key, value}     set value in DataSet, null removes
+type debugRequest struct {
+	ID     int64           `json:"id"`
+	Cmd    string          `json:"cmd"`
+	Entity uint64          `json:"entity,omitempty"`
+	Tree   string          `json:"tree,omitempty"`
+	Node   NodeID          `json:"node,omitempty"`
+	Key    string          `json:"key,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// The message sent to debug client, a response to request with
+// the same ID, or an event.
+type debugMessage struct {
+	ID       int64          `json:"id,omitempty"`
+	OK       bool           `json:"ok,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Result   interface{}    `json:"result,omitempty"`
+	Event    string         `json:"event,omitempty"`
+	Entity   uint64         `json:"entity,omitempty"`
+	Snapshot *DebugSnapshot `json:"snapshot,omitempty"`
+}
+
+// The size of message queue of client. The events are dropped
+// if the client is too slow.
+const debugClientQueueSize = 256
+
+type debugClient struct {
+	d    *Debugger
+	conn net.Conn
+	out  chan *debugMessage
+	done chan struct{}
+	once sync.Once
+
+	mtx       sync.Mutex
+	watching  map[uint64]bool
+	snapshots map[uint64]bool
+}
+
+// ListenAndServe listens on the TCP address addr and serves debug
+// clients.
+func (d *Debugger) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.WithMessage(err, "debugger listen")
+	}
+
+	return d.Serve(l)
+}
+
+// Serve accepts debug clients on l until the debugger closed.
+// The clients send requests and receive responses and events in
+// JSON, one object per line.
+func (d *Debugger) Serve(l net.Listener) error {
+	d.mtx.Lock()
+	if d.isClosed() {
+		d.mtx.Unlock()
+		l.Close()
+		return errors.New("debugger closed")
+	}
+	d.servers = append(d.servers, l)
+	d.mtx.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if d.isClosed() {
+				return nil
+			}
+			return errors.WithMessage(err, "debugger accept")
+		}
+
+		c := &debugClient{
+			d:         d,
+			conn:      conn,
+			out:       make(chan *debugMessage, debugClientQueueSize),
+			done:      make(chan struct{}),
+			watching:  map[uint64]bool{},
+			snapshots: map[uint64]bool{},
+		}
+
+		d.mtx.Lock()
+		if d.isClosed() {
+			d.mtx.Unlock()
+			conn.Close()
+			return nil
+		}
+		d.clients[c] = true
+		d.mtx.Unlock()
+
+		go c.writeLoop()
+		go c.readLoop()
+	}
+}
+
+// Send message to all clients.
+func (d *Debugger) broadcast(msg *debugMessage) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	for c := range d.clients {
+		c.send(msg)
+	}
+}
+
+func (d *Debugger) removeClient(c *debugClient) {
+	d.mtx.Lock()
+	delete(d.clients, c)
+
+	c.mtx.Lock()
+	for id := range c.watching {
+		if de := d.entities[id]; de != nil {
+			de.watchers--
+		}
+	}
+	c.watching = map[uint64]bool{}
+	c.mtx.Unlock()
+
+	d.mtx.Unlock()
+}
+
+func (d *Debugger) watch(c *debugClient, id uint64, watch bool) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	de, err := d.getEntity(id)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.watching[id] != watch {
+		if watch {
+			c.watching[id] = true
+			de.watchers++
+		} else {
+			delete(c.watching, id)
+			de.watchers--
+		}
+	}
+
+	return nil
+}
+
+// Queue the message, drop it if the queue is full.
+func (c *debugClient) send(msg *debugMessage) {
+	select {
+	case c.out <- msg:
+	default:
+	}
+}
+
+func (c *debugClient) isWatching(entity uint64) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.watching[entity]
+}
+
+func (c *debugClient) takeSnapshotRequest(entity uint64) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.snapshots[entity] {
+		delete(c.snapshots, entity)
+		return true
+	}
+	return false
+}
+
+func (c *debugClient) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *debugClient) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	enc := json.NewEncoder(w)
+
+	for {
+		select {
+		case msg := <-c.out:
+			if err := enc.Encode(msg); err != nil {
+				c.close()
+				return
+			}
+
+			// Write the queued messages together.
+			if len(c.out) == 0 {
+				if err := w.Flush(); err != nil {
+					c.close()
+					return
+				}
+			}
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *debugClient) readLoop() {
+	defer func() {
+		c.d.removeClient(c)
+		c.close()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(c.conn))
+	for {
+		var req debugRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		c.handle(&req)
+	}
+}
+
+func (c *debugClient) handle(req *debugRequest) {
+	d := c.d
+	resp := &debugMessage{ID: req.ID}
+
+	var err error
+	switch req.Cmd {
+	case "entities":
+		resp.Result = d.Entities()
+
+	case "tree":
+		var view *nodeView
+		if view, err = d.entityTree(req.Entity); err == nil {
+			resp.Result = view
+		}
+
+	case "watch":
+		err = d.watch(c, req.Entity, true)
+
+	case "unwatch":
+		err = d.watch(c, req.Entity, false)
+
+	case "snapshot":
+		c.mtx.Lock()
+		c.snapshots[req.Entity] = true
+		c.mtx.Unlock()
+		err = d.requestSnapshot(req.Entity)
+
+	case "break":
+		d.SetBreakpoint(req.Tree, req.Node)
+
+	case "clear":
+		d.ClearBreakpoint(req.Tree, req.Node)
+
+	case "breakpoints":
+		resp.Result = d.breakpointList()
+
+	case "pause":
+		err = d.Pause(req.Entity)
+
+	case "continue":
+		err = d.Continue(req.Entity)
+
+	case "step":
+		err = d.Step(req.Entity)
+
+	case "set":
+		err = d.SetValue(req.Entity, req.Key, req.Value)
+
+	default:
+		err = errors.Errorf("unknown command \"%s\"", req.Cmd)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.OK = true
+	}
+
+	select {
+	case c.out <- resp:
+	case <-c.done:
+	}
+}
@@ -1,6 +1,9 @@
 package bevtree
 
-import "github.com/GodYY/gutils/assert"
+import (
+	"github.com/GodYY/gutils/assert"
+	"github.com/pkg/errors"
+)
 
 // DecoratorNode interface indicates the functions that
 // a decorator node in behavior tree must implement.
@@ -42,6 +45,11 @@ func (d *decoratorNode) setChild(child Node) bool {
 	return child != nil
 }
 
+func (d *decoratorNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteChild(d.child)
+	return nil
+}
+
 // Inverter node runs child and returns the reverse value of
 // child's result.
 type InverterNode struct {
@@ -60,6 +68,13 @@ func (i *InverterNode) SetChild(child Node) {
 	}
 }
 
+func (i *InverterNode) UnmarshalBTProperties(p PropertyReader) error {
+	if child := p.Child(); child != nil {
+		i.SetChild(child)
+	}
+	return nil
+}
+
 // The inverter node task.
 type inverterTask struct {
 	node *InverterNode
@@ -105,6 +120,13 @@ func (s *SucceederNode) SetChild(child Node) {
 	}
 }
 
+func (s *SucceederNode) UnmarshalBTProperties(p PropertyReader) error {
+	if child := p.Child(); child != nil {
+		s.SetChild(child)
+	}
+	return nil
+}
+
 // Succeeder node task.
 type succeederTask struct {
 	node *SucceederNode
@@ -154,6 +176,31 @@ func (r *RepeaterNode) SetChild(child Node) {
 
 func (r *RepeaterNode) Limited() int { return r.limited }
 
+func (r *RepeaterNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteInt(PropertyLimited, int64(r.limited))
+	w.WriteChild(r.child)
+	return nil
+}
+
+func (r *RepeaterNode) UnmarshalBTProperties(p PropertyReader) error {
+	limited, err := p.ReadInt(PropertyLimited)
+	if err != nil {
+		return err
+	}
+
+	// The same as NewRepeaterNode.
+	if limited < 1 {
+		return errors.Errorf("limited %d < 1", limited)
+	}
+
+	r.limited = int(limited)
+
+	if child := p.Child(); child != nil {
+		r.SetChild(child)
+	}
+	return nil
+}
+
 // Repeater node task.
 type repeaterTask struct {
 	node  *RepeaterNode
@@ -210,6 +257,26 @@ func (r *RepeatUntilFailNode) SetChild(child Node) {
 
 func (r *RepeatUntilFailNode) SuccessOnFail() bool { return r.successOnFail }
 
+func (r *RepeatUntilFailNode) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteBool(PropertySuccessOnFail, r.successOnFail)
+	w.WriteChild(r.child)
+	return nil
+}
+
+func (r *RepeatUntilFailNode) UnmarshalBTProperties(p PropertyReader) error {
+	successOnFail, err := p.ReadBool(PropertySuccessOnFail)
+	if err != nil {
+		return err
+	}
+
+	r.successOnFail = successOnFail
+
+	if child := p.Child(); child != nil {
+		r.SetChild(child)
+	}
+	return nil
+}
+
 // RepeatUntilFail node task.
 type repeatUntilFailTask struct {
 	node *RepeatUntilFailNode
@@ -1,9 +1,11 @@
 package bevtree
 
 import (
+	gocontext "context"
 	"fmt"
-	"log"
 	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/GodYY/gutils/assert"
 	"github.com/GodYY/gutils/finalize"
@@ -50,18 +52,60 @@ func (nl *nodeList) len() int { return nl.l.getLen() }
 
 func (nl *nodeList) clear() { nl.l.init() }
 
+// The options to create entity.
+type entityOptions struct {
+	randSeed   int64
+	randSeeded bool
+	profileCtx gocontext.Context
+}
+
+func newEntityOptions(opts []EntityOption) *entityOptions {
+	o := &entityOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// EntityOption configures the entity in Framework.CreateEntity.
+type EntityOption func(*entityOptions)
+
+// WithRandSeed seeds the random source of the entity, the
+// entity makes the same random decisions with the same seed.
+func WithRandSeed(seed int64) EntityOption {
+	return func(o *entityOptions) {
+		o.randSeed = seed
+		o.randSeeded = true
+	}
+}
+
 // Entity used to run a behavior tree.
 type Entity interface {
+	// Get the entity ID, unique in Framework.
+	ID() uint64
+
 	// Get the context.
 	Context() Context
 
 	// Update behavior tree and get a result from this
-	// update.
+	// update. The time passed is read from the Clock of
+	// Framework.
 	Update() Result
 
+	// UpdateDelta works like Update, but the time passed
+	// is specified by dt, for fixed-timestep simulation.
+	UpdateDelta(dt time.Duration) Result
+
 	// Stops running the bahavior tree.
 	Stop()
 
+	// Set the observer of the entity, it replaces the
+	// observer of Framework. nil means no observer.
+	SetObserver(Observer)
+
+	// Get the snapshot of running agents.
+	ActiveNodes() []*ActiveNode
+
 	// If the entity is no longer used, call Release to
 	// release resource of it.
 	Release()
@@ -93,10 +137,14 @@ type agent struct {
 	latestUpdateSeri uint32
 
 	// Store the current status.
-	st status
+	st AgentStatus
 
 	// Store the lazyStop type.
-	lzStop lazyStop
+	lzStop LazyStop
+
+	// Whether the task initialized. The status is still AgentNone until
+	// the first update returns.
+	initialized bool
 
 	// agent placeholder int the work queue.
 	elem *element
@@ -109,8 +157,9 @@ func (a *agent) onCreate(node Node, task Task) {
 	a.node = node
 	a.task = task
 	a.latestUpdateSeri = 0
-	a.st = sNone
-	a.lzStop = lzsNone
+	a.st = AgentNone
+	a.lzStop = LazyStopNone
+	a.initialized = false
 }
 
 // onDestroy is called before the agent is destroyed.
@@ -177,23 +226,23 @@ func (a *agent) removeChild(child *agent) {
 }
 
 func (a *agent) getParent() *agent         { return a.parent }
-func (a *agent) getStatus() status         { return a.st }
-func (a *agent) setStatus(st status)       { a.st = st }
-func (a *agent) getLZStop() lazyStop       { return a.lzStop }
-func (a *agent) setLZStop(lzStop lazyStop) { a.lzStop = lzStop }
+func (a *agent) getStatus() AgentStatus    { return a.st }
+func (a *agent) setStatus(st AgentStatus)  { a.st = st }
+func (a *agent) getLZStop() LazyStop       { return a.lzStop }
+func (a *agent) setLZStop(lzStop LazyStop) { a.lzStop = lzStop }
 func (a *agent) getElem() *element         { return a.elem }
 func (a *agent) setElem(elem *element)     { a.elem = elem }
 
 // Running logic of the agent.
 func (a *agent) update(entity *entity) Result {
-	if debug {
-		log.Printf("agent nodetype:%v update %v %v", a.node.NodeType(), a.getStatus(), a.getLZStop())
+	if entity.logEnabled(LogDebug) {
+		entity.logAgent(LogDebug, "agent update", a, LogField{Key: "status", Value: a.getStatus()}, LogField{Key: "lazystop", Value: a.getLZStop()})
 	}
 
 	st := a.getStatus()
 
 	if debug {
-		assert.NotEqualF(st, sDestroyed, "agent nodetype:%v already destroyed", a.node.NodeType())
+		assert.NotEqualF(st, AgentDestroyed, "agent nodetype:%v already destroyed", a.node.NodeType())
 	}
 
 	// Update seri.
@@ -201,18 +250,35 @@ func (a *agent) update(entity *entity) Result {
 
 	// lazy Stop before Update.
 	lzStop := a.getLZStop()
-	if lzStop == lzsBeforeUpdate {
+	if lzStop == LazyStopBeforeUpdate {
 		return a.doLazyStop(entity)
 	}
 
 	// init.
-	if st == sNone {
-		if !a.task.OnInit(entity.getChildNodeList(), entity.Context()) {
-			a.task.OnTerminate(entity.Context())
-			a.setStatus(sTerminated)
+	if st == AgentNone {
+		if entity.metrics != nil {
+			entity.metrics.nodeInit(entity.ctx.Tree(), a.node)
+		}
+
+		if entity.profile {
+			entity.ctx.setProfileLabels(a.node)
+		}
+
+		ok := a.task.OnInit(entity.getChildNodeList(), entity.Context())
+
+		if entity.profile {
+			entity.ctx.clearProfileLabels()
+		}
+
+		if !ok {
+			entity.notify(EventAgentInit, a, Failure)
+			a.terminate(entity.Context(), AgentTerminated, TerminateCompleted, Failure)
 			return Failure
 		}
 
+		a.initialized = true
+		entity.notify(EventAgentInit, a, Running)
+
 		if debug {
 			switch a.task.TaskType() {
 			case Single:
@@ -230,19 +296,35 @@ func (a *agent) update(entity *entity) Result {
 	}
 
 	// Update.
-	result := a.task.OnUpdate(entity.Context())
+	if entity.profile {
+		entity.ctx.setProfileLabels(a.node)
+	}
+
+	var result Result
+	if entity.metrics != nil {
+		start := time.Now()
+		result = a.task.OnUpdate(entity.Context())
+		entity.metrics.nodeUpdate(entity.ctx.Tree(), a.node, time.Since(start))
+	} else {
+		result = a.task.OnUpdate(entity.Context())
+	}
+
+	if entity.profile {
+		entity.ctx.clearProfileLabels()
+	}
+
+	entity.notify(EventAgentUpdate, a, result)
 
 	// lazy Stop after Update
-	if lzStop == lzsAfterUpdate {
+	if lzStop == LazyStopAfterUpdate {
 		return a.doLazyStop(entity)
 	}
 
 	if result == Running {
-		a.setStatus(sRunning)
+		a.setStatus(AgentRunning)
 	} else {
 		// terminate.
-		a.task.OnTerminate(entity.Context())
-		a.setStatus(sTerminated)
+		a.terminate(entity.Context(), AgentTerminated, TerminateCompleted, result)
 	}
 
 	return result
@@ -260,14 +342,16 @@ func (a *agent) processNextChildren(entity *entity) {
 }
 
 // If the agent is running, stop it. remove all child agents,
-// notify the task to terminate.
-func (a *agent) stop(ctx Context) {
-	if a.getStatus() != sRunning {
+// notify the task to terminate with reason.
+func (a *agent) stop(entity *entity, reason TerminateReason) {
+	if a.getStatus() != AgentRunning {
 		return
 	}
 
-	if debug {
-		log.Printf("agent nodetype:%v stop", a.node.NodeType())
+	entity.notify(EventAgentStop, a, Failure)
+
+	if entity.logEnabled(LogDebug) {
+		entity.logAgent(LogDebug, "agent stop", a, LogField{Key: "reason", Value: reason})
 	}
 
 	child := a.firstChild
@@ -277,48 +361,73 @@ func (a *agent) stop(ctx Context) {
 		a.removeChild(agent)
 	}
 
-	a.task.OnTerminate(ctx)
-	a.setStatus(sStopped)
-	a.setLZStop(lzsNone)
+	a.terminate(entity.Context(), AgentStopped, reason, Failure)
+	a.setLZStop(LazyStopNone)
 }
 
 // Lazy-Stop the agent if it is running and not set with
 // lazy-stop state yet.
 func (a *agent) lazyStop(entity *entity) {
-	if debug {
-		log.Printf("agent nodetype:%v lazyStop", a.node.NodeType())
+	if entity.logEnabled(LogDebug) {
+		entity.logAgent(LogDebug, "agent lazy stop", a, LogField{Key: "status", Value: a.getStatus()})
 	}
 
 	st := a.getStatus()
-	if st == sStopped || st == sTerminated || a.getLZStop() != lzsNone {
+	if st == AgentStopped || st == AgentTerminated || a.getLZStop() != LazyStopNone {
 		return
 	}
 
 	if a.latestUpdateSeri != entity.getUpdateSeri() {
 		// Not updated on the latest updating.
 		// Stop after update.
-		a.setLZStop(lzsAfterUpdate)
+		a.setLZStop(LazyStopAfterUpdate)
 	} else {
 		// Updated on the latest updating.
 		// Stop before update.
-		a.setLZStop(lzsBeforeUpdate)
+		a.setLZStop(LazyStopBeforeUpdate)
 	}
 
 	// Lazy-Stop need agent to update again.
-	if a.elem == nil || a.getLZStop() == lzsBeforeUpdate {
+	if a.elem == nil || a.getLZStop() == LazyStopBeforeUpdate {
 		entity.pushAgent(a)
 	}
 }
 
 // The implementation of Lazy-Stop on agent.
 func (a *agent) doLazyStop(entity *entity) Result {
+	entity.notify(EventAgentLazyStop, a, Failure)
 	a.lazyStopChildren(entity)
-	a.task.OnTerminate(entity.Context())
-	a.setStatus(sStopped)
-	a.setLZStop(lzsNone)
+	a.terminate(entity.Context(), AgentStopped, TerminateAborted, Failure)
+	a.setLZStop(LazyStopNone)
 	return Failure
 }
 
+// Set the final status st and notify the task to terminate.
+// The status is set first, so that a panic raised by the task
+// can be distinguished from the one before terminating.
+func (a *agent) terminate(ctx Context, st AgentStatus, reason TerminateReason, result Result) {
+	a.setStatus(st)
+
+	if m := ctx.framework().metrics; m != nil {
+		m.nodeTerminate(ctx.Tree(), a.node, reason, result)
+	}
+
+	profile := ctx.framework().profile
+	if profile {
+		ctx.setProfileLabels(a.node)
+	}
+
+	if h, ok := a.task.(TerminateHandler); ok {
+		h.OnTerminateWithReason(ctx, reason, result)
+	} else {
+		a.task.OnTerminate(ctx)
+	}
+
+	if profile {
+		ctx.clearProfileLabels()
+	}
+}
+
 func (a *agent) lazyStopChildren(entity *entity) {
 	child := a.firstChild
 	for child != nil {
@@ -331,8 +440,11 @@ func (a *agent) lazyStopChildren(entity *entity) {
 
 // onChildTerminated is called when a child agent is terminated.
 func (a *agent) onChildTerminated(child *agent, result Result, entity *entity) Result {
+	if entity.logEnabled(LogDebug) {
+		entity.logAgent(LogDebug, "agent child terminated", a, LogField{Key: "result", Value: result})
+	}
+
 	if debug {
-		log.Printf("agent nodetype:%v onChildTerminated %v", a.node.NodeType(), result)
 		assert.Assert(a.task.TaskType() != Single, "shouldnt be singletask")
 		assert.Assert(child.getParent() == a, "invalid child")
 		assert.NotEqual(result, Running, "child terminated with running")
@@ -342,17 +454,28 @@ func (a *agent) onChildTerminated(child *agent, result Result, entity *entity) R
 	a.removeChild(child)
 
 	// Not running, Failure.
-	if a.getStatus() != sRunning {
+	if a.getStatus() != AgentRunning {
 		return Failure
 	}
 
 	// Lazy-Stopping, Running.
-	if a.getLZStop() != lzsNone {
+	if a.getLZStop() != LazyStopNone {
 		return Running
 	}
 
 	// Invoke task.OnChildTerminated to make decision.
-	if result = a.task.OnChildTerminated(result, entity.getChildNodeList(), entity.Context()); result == Running {
+	if entity.profile {
+		entity.ctx.setProfileLabels(a.node)
+	}
+
+	result = a.task.OnChildTerminated(result, entity.getChildNodeList(), entity.Context())
+
+	if entity.profile {
+		entity.ctx.clearProfileLabels()
+	}
+	entity.notify(EventAgentChildTerminated, a, result)
+
+	if result == Running {
 		if debug {
 			switch a.task.TaskType() {
 			case Serial:
@@ -372,9 +495,8 @@ func (a *agent) onChildTerminated(child *agent, result Result, entity *entity) R
 		// Lazy-Stop children, avoid nested calls.
 		a.lazyStopChildren(entity)
 
-		a.task.OnTerminate(entity.Context())
-		a.setStatus(sTerminated)
-		a.setLZStop(lzsNone)
+		a.terminate(entity.Context(), AgentTerminated, TerminateCompleted, result)
+		a.setLZStop(LazyStopNone)
 	}
 
 	return result
@@ -385,6 +507,9 @@ var agentPool = newPool(func() interface{} { return &agent{} })
 
 // Entity implementation.
 type entity struct {
+	// The entity ID.
+	id uint64
+
 	// The context.
 	ctx Context
 
@@ -399,6 +524,19 @@ type entity struct {
 	// subsequent child nodes.
 	childNodeList *nodeList
 
+	// The observer, nil if not observed.
+	observer Observer
+
+	// The metrics registry, nil if not collected.
+	metrics *Metrics
+
+	// Whether to run task callbacks with pprof labels.
+	profile bool
+
+	// The debugger and the debugging state, nil if not debugged.
+	debugger *Debugger
+	debug    *debugEntity
+
 	internalImpl
 }
 
@@ -406,13 +544,28 @@ func newEntity(ctx Context) *entity {
 	assert.Assert(ctx != nil, "ctx nil")
 
 	entity := &entity{
+		id:            atomic.AddUint64(&ctx.framework().entitySeri, 1),
 		ctx:           ctx,
 		agentList:     newList(),
 		childNodeList: newNodeList(),
+		observer:      ctx.getObserver(),
+		metrics:       ctx.framework().metrics,
+		profile:       ctx.framework().profile,
+		debugger:      ctx.framework().debugger,
+	}
+
+	if entity.debugger != nil {
+		entity.debug = entity.debugger.addEntity(entity)
 	}
 
 	finalize.SetFinalizer(entity)
 
+	entity.notify(EventEntityCreate, nil, Running)
+
+	if entity.logEnabled(LogDebug) {
+		entity.log(LogDebug, "entity create")
+	}
+
 	return entity
 }
 
@@ -420,11 +573,28 @@ func newEntity(ctx Context) *entity {
 // release resource of it.
 func (e *entity) Release() {
 	finalize.UnsetFinalizer(e)
+
+	if e.logEnabled(LogDebug) {
+		e.log(LogDebug, "entity release")
+	}
+
 	e.release()
 }
 
 func (e *entity) release() {
-	e.clearAgent()
+	if e.debugger != nil {
+		e.debugger.removeEntity(e)
+		e.debugger = nil
+		e.debug = nil
+	}
+
+	e.clearAgent(TerminateStopped)
+
+	// The release event is the last event of entity, after the events
+	// of agents stopped.
+	e.notify(EventEntityRelease, nil, Failure)
+	e.observer = nil
+
 	e.agentList = nil
 	e.childNodeList.clear()
 	e.childNodeList = nil
@@ -436,12 +606,17 @@ func (e *entity) release() {
 // Finalizer will be called by GC if there is no explicitly
 // call Release.
 func (e *entity) Finalizer() {
-	if debug {
-		log.Println("Env.Finalizer")
+	if e.logEnabled(LogDebug) {
+		e.log(LogDebug, "entity finalizer")
 	}
+
+	// Not to notify observer on the finalizer goroutine.
+	e.observer = nil
 	e.release()
 }
 
+func (e *entity) ID() uint64 { return e.id }
+
 func (e *entity) Context() Context { return e.ctx }
 
 func (e *entity) getUpdateSeri() uint32 { return e.ctx.UpdateSeri() }
@@ -517,7 +692,8 @@ func (e *entity) removeAgent(agent *agent) {
 	}
 }
 
-func (e *entity) clearAgent() {
+// Stop and destroy all agents, the tasks terminate with reason.
+func (e *entity) clearAgent(reason TerminateReason) {
 	elem := e.agentList.front()
 	for elem != nil {
 		next := elem.getNext()
@@ -530,7 +706,7 @@ func (e *entity) clearAgent() {
 			for agent != nil {
 				agent.setElem(nil)
 				parent := agent.getParent()
-				agent.stop(e.ctx)
+				e.stopAgent(agent, reason)
 				e.destroyAgent(agent)
 				agent = parent
 			}
@@ -554,6 +730,8 @@ func (e *entity) createAgent(node Node) *agent {
 	agent := agentPool.get().(*agent)
 	agent.onCreate(node, task)
 
+	e.notify(EventAgentCreate, agent, Running)
+
 	return agent
 }
 
@@ -568,6 +746,8 @@ func (e *entity) destroyAgent(agent *agent) {
 		panic(fmt.Sprintf("node type \"%s\" meta not found, %s", node.NodeType(), reflect.TypeOf(node).Elem().Name()))
 	}
 
+	e.notify(EventAgentDestroy, agent, Failure)
+
 	nodeMETA.destroyTask(agent.task)
 	agent.onDestroy()
 	agentPool.put(agent)
@@ -576,8 +756,27 @@ func (e *entity) destroyAgent(agent *agent) {
 // Update used to update the behavior tree and get a result
 // from this update.
 func (e *entity) Update() Result {
+	return e.update(e.ctx.framework().now().Sub(e.ctx.Now()))
+}
+
+// UpdateDelta works like Update, but the time passed is
+// specified by dt.
+func (e *entity) UpdateDelta(dt time.Duration) Result {
+	return e.update(dt)
+}
+
+func (e *entity) update(dt time.Duration) Result {
+	if e.metrics != nil {
+		start := time.Now()
+		defer func() { e.metrics.entityUpdate(time.Since(start)) }()
+	}
+
+	if e.debugger != nil {
+		e.debugger.beginUpdate(e)
+	}
+
 	e.lazyPushUpdateBoundary()
-	e.ctx.update()
+	e.ctx.update(dt)
 
 	if e.noAgents() {
 		// No agents indicate the behavior tree was not run yet
@@ -592,14 +791,22 @@ func (e *entity) Update() Result {
 	// Run agent one by one until there are no agents at current
 	// updating or back to root node.
 	for agent := e.popAgent(); agent != nil; agent = e.popAgent() {
-		r := agent.update(e)
+		if e.debugger != nil {
+			e.debugger.beforeAgentUpdate(e, agent)
+		}
+
+		r := e.updateAgent(agent)
 		st := agent.getStatus()
-		if st == sStopped {
+
+		if e.debugger != nil && st == AgentTerminated {
+			e.debug.setResult(agent.node, r, e.ctx.UpdateSeri())
+		}
+		if st == AgentStopped {
 			e.destroyAgent(agent)
 			continue
 		}
 
-		if st == sTerminated {
+		if st == AgentTerminated {
 			// agent terminated, submit result to parent for
 			// making decision.
 
@@ -610,9 +817,13 @@ func (e *entity) Update() Result {
 			// Submit result to parent until no parent.
 			for agent.getParent() != nil {
 				parent := agent.getParent()
-				parentTerminated := parent.getStatus() != sRunning
+				parentTerminated := parent.getStatus() != AgentRunning
+
+				r = e.onChildTerminated(parent, agent, r)
 
-				r = parent.onChildTerminated(agent, r, e)
+				if e.debugger != nil && !parentTerminated && r != Running {
+					e.debug.setResult(parent.node, r, e.ctx.UpdateSeri())
+				}
 				if parentTerminated || r == Running {
 					// Parent already terminated or still running, stop.
 					isBackToRoot = false
@@ -648,12 +859,27 @@ func (e *entity) Update() Result {
 
 	assert.Assert(result == Running || e.noAgents(), "Update terminated but already has agents")
 
+	e.notify(EventEntityUpdate, nil, result)
+
+	if e.debugger != nil {
+		e.debugger.endUpdate(e)
+	}
+
+	if e.logEnabled(LogDebug) {
+		e.log(LogDebug, "entity update", LogField{Key: "result", Value: result})
+	}
+
 	return result
 }
 
 // Stop stops running the behavior tree.
 func (e *entity) Stop() {
+	e.notify(EventEntityStop, nil, Failure)
+
+	if e.logEnabled(LogDebug) {
+		e.log(LogDebug, "entity stop")
+	}
 	e.ctx.reset()
-	e.clearAgent()
+	e.clearAgent(TerminateStopped)
 	e.agentUpdateBoundary = nil
 }
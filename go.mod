@@ -1,6 +1,6 @@
 module github.com/GodYY/bevtree
 
-go 1.15
+go 1.16
 
 require (
 	github.com/GodYY/gutils v0.0.0-20211118030014-d2e0c9daca69
@@ -0,0 +1,796 @@
+package bevtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+
+	"github.com/GodYY/gutils/assert"
+	"github.com/pkg/errors"
+)
+
+// JSON strings.
+const (
+	// json key for name.
+	JSONStringName = "name"
+
+	// json key for comment.
+	JSONStringComment = "comment"
+
+	// json key for node ID.
+	JSONStringID = "id"
+
+	// json key for NodeType.
+	JSONStringNodeType = "nodetype"
+
+	// json key for BevType.
+	JSONStringBevType = "bevtype"
+
+	// json key for the members of Bev.
+	JSONStringBev = "bev"
+
+	// json key for root node.
+	JSONStringRoot = "root"
+
+	// json key for children.
+	JSONStringChildren = "children"
+
+	// json key for child.
+	JSONStringChild = "child"
+
+	// json key for weight.
+	JSONStringWeight = "weight"
+
+	// json key for limited.
+	JSONStringLimited = "limited"
+
+	// json key for success on fail.
+	JSONStringSuccessOnFail = "successonfail"
+
+	// json key for subtree.
+	JSONStringSubtree = "subtree"
+)
+
+// JSONObject holds the members of a bevtree JSON object. The
+// members are encoded in the order they were set.
+type JSONObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// NewJSONObject creates an empty JSONObject.
+func NewJSONObject() *JSONObject {
+	return &JSONObject{values: map[string]json.RawMessage{}}
+}
+
+// Keys returns the keys of members in order.
+func (o *JSONObject) Keys() []string { return o.keys }
+
+// Has reports whether the member key exists.
+func (o *JSONObject) Has(key string) bool {
+	_, ok := o.values[key]
+	return ok
+}
+
+// Raw returns the encoding of member key, nil if not found.
+func (o *JSONObject) Raw(key string) json.RawMessage { return o.values[key] }
+
+// SetRaw sets the member key to the encoding data.
+func (o *JSONObject) SetRaw(key string, data json.RawMessage) {
+	if o.values == nil {
+		o.values = map[string]json.RawMessage{}
+	}
+
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+
+	o.values[key] = data
+}
+
+// Set sets the member key to the JSON encoding of v.
+func (o *JSONObject) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithMessagef(err, "set \"%s\"", key)
+	}
+
+	o.SetRaw(key, data)
+	return nil
+}
+
+// Get decodes the member key into v. It returns an error if the
+// member not found.
+func (o *JSONObject) Get(key string, v interface{}) error {
+	data, ok := o.values[key]
+	if !ok {
+		return errors.Errorf("member \"%s\" not found", key)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.WithMessagef(err, "get \"%s\"", key)
+	}
+
+	return nil
+}
+
+// Object returns the member key as JSONObject, nil if not found
+// or null.
+func (o *JSONObject) Object(key string) (*JSONObject, error) {
+	data, ok := o.values[key]
+	if !ok || string(data) == "null" {
+		return nil, nil
+	}
+
+	obj := NewJSONObject()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return nil, errors.WithMessagef(err, "get \"%s\"", key)
+	}
+
+	return obj, nil
+}
+
+func (o *JSONObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o *JSONObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	if token, err := dec.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return errors.Errorf("expect object but found %v", token)
+	}
+
+	o.keys = o.keys[:0]
+	o.values = map[string]json.RawMessage{}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+
+		o.SetRaw(token.(string), value)
+	}
+
+	_, err := dec.Token()
+	return err
+}
+
+// JSONMarshaler is the interface implemented by objects that can
+// marshal themselves into the members of bevtree JSON object.
+type JSONMarshaler interface {
+	MarshalBTJSON(*JSONEncoder, *JSONObject) error
+}
+
+// JSONUnmarshaler is the interface implemented by objects that can
+// unmarshal the bevtree JSON object description of themselves.
+type JSONUnmarshaler interface {
+	UnmarshalBTJSON(*JSONDecoder, *JSONObject) error
+}
+
+// A JSONEncoder encodes the objects of behavior tree to bevtree
+// JSON objects.
+type JSONEncoder struct {
+	framework *Framework
+
+	// The IDs of nodes of the tree encoding.
+	ids nodeIDs
+}
+
+func newJSONEncoder(framework *Framework) *JSONEncoder {
+	assert.Assert(framework != nil, "framework nil")
+	return &JSONEncoder{framework: framework}
+}
+
+func (e *JSONEncoder) Framework() *Framework { return e.framework }
+
+// EncodeElement encodes v into the members of obj. If v does not
+// implement JSONMarshaler but PropertyMarshaler, or has fields tagged
+// with PropertyTagKey, the properties are set to obj, the child as
+// member "child", and the children as member "children". A child
+// with properties is encoded as object with the properties and the
+// member "child". Otherwise, the members of the JSON encoding of v
+// are set to obj.
+func (e *JSONEncoder) EncodeElement(v interface{}, obj *JSONObject) error {
+	if marshaler, ok := v.(JSONMarshaler); ok {
+		return marshaler.MarshalBTJSON(e, obj)
+	}
+
+	if marshaler, err := propertyMarshalerOf(v); err != nil {
+		return err
+	} else if marshaler != nil {
+		w := &jsonPropertyWriter{e: e, obj: obj}
+		if err := marshaler.MarshalBTProperties(w); err != nil {
+			return err
+		}
+		return w.err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var members JSONObject
+	if err := json.Unmarshal(data, &members); err != nil {
+		return errors.WithMessagef(err, "%T not encoded as object", v)
+	}
+
+	for _, key := range members.keys {
+		obj.SetRaw(key, members.values[key])
+	}
+
+	return nil
+}
+
+// EncodeNode encodes the behavior tree node to a new object. The
+// node type, ID and comment of node are set to the object first.
+func (e *JSONEncoder) EncodeNode(n Node) (*JSONObject, error) {
+	if e.framework.getNodeMeta(n.NodeType()) == nil {
+		return nil, errors.Errorf("meta of node type \"%s\" not found", n.NodeType().String())
+	}
+
+	obj := NewJSONObject()
+	obj.Set(JSONStringNodeType, n.NodeType())
+
+	if id := e.ids[n]; id.Valid() {
+		obj.Set(JSONStringID, id)
+	}
+
+	if n.Comment() != "" {
+		obj.Set(JSONStringComment, n.Comment())
+	}
+
+	if err := e.EncodeElement(n, obj); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// The PropertyWriter of JSONEncoder.
+type jsonPropertyWriter struct {
+	e   *JSONEncoder
+	obj *JSONObject
+	err error
+}
+
+func (w *jsonPropertyWriter) set(name string, v interface{}) {
+	if w.err == nil {
+		w.err = w.obj.Set(name, v)
+	}
+}
+
+func (w *jsonPropertyWriter) WriteInt(name string, v int64)     { w.set(name, v) }
+func (w *jsonPropertyWriter) WriteFloat(name string, v float64) { w.set(name, jsonFloat(v)) }
+func (w *jsonPropertyWriter) WriteBool(name string, v bool)     { w.set(name, v) }
+func (w *jsonPropertyWriter) WriteString(name string, v string) { w.set(name, v) }
+
+func (w *jsonPropertyWriter) WriteChild(child Node) {
+	if w.err != nil || child == nil {
+		return
+	}
+
+	obj, err := w.e.EncodeNode(child)
+	if err != nil {
+		w.err = errors.WithMessage(err, "Marshal child")
+		return
+	}
+
+	w.set(JSONStringChild, obj)
+}
+
+func (w *jsonPropertyWriter) WriteChildren(count int, child func(int, PropertyWriter) Node) {
+	if w.err != nil || count == 0 {
+		return
+	}
+
+	children := make([]*JSONObject, count)
+	for i := range children {
+		var props propertyList
+		node := child(i, &props)
+		if props.err != nil {
+			w.err = errors.WithMessagef(props.err, "Marshal No.%d child", i)
+			return
+		}
+
+		obj, err := w.e.EncodeNode(node)
+		if err != nil {
+			w.err = errors.WithMessagef(err, "Marshal No.%d child", i)
+			return
+		}
+
+		if len(props.names) == 0 {
+			children[i] = obj
+			continue
+		}
+
+		children[i] = NewJSONObject()
+		for j, name := range props.names {
+			if v, ok := props.values[j].(float64); ok {
+				children[i].Set(name, jsonFloat(v))
+			} else {
+				children[i].Set(name, props.values[j])
+			}
+		}
+		children[i].Set(JSONStringChild, obj)
+	}
+
+	w.set(JSONStringChildren, children)
+}
+
+// A JSONDecoder decodes the objects of behavior tree from bevtree
+// JSON objects.
+type JSONDecoder struct {
+	framework *Framework
+
+	// The path of the file decoding, empty if not decoding a file.
+	path string
+
+	// The IDs of nodes of the tree decoding.
+	ids nodeIDs
+}
+
+func newJSONDecoder(framework *Framework) *JSONDecoder {
+	assert.Assert(framework != nil, "framework nil")
+	return &JSONDecoder{framework: framework}
+}
+
+func (d *JSONDecoder) Framework() *Framework { return d.framework }
+
+// log logs a message raised while decoding, with the path.
+func (d *JSONDecoder) log(level LogLevel, msg string, fields ...LogField) {
+	if !d.framework.logEnabled(level) {
+		return
+	}
+
+	if d.path != "" {
+		fields = append(fields, LogField{Key: LogKeyPath, Value: d.path})
+	}
+
+	d.framework.log(level, msg, fields...)
+}
+
+// DecodeElement decodes obj into v. If v does not implement
+// JSONUnmarshaler but PropertyUnmarshaler, or has fields tagged with
+// PropertyTagKey, obj is read as properties, see
+// JSONEncoder.EncodeElement. Otherwise, obj is decoded by
+// encoding/json.
+func (d *JSONDecoder) DecodeElement(v interface{}, obj *JSONObject) error {
+	if unmarshaler, ok := v.(JSONUnmarshaler); ok {
+		return unmarshaler.UnmarshalBTJSON(d, obj)
+	}
+
+	if unmarshaler, err := propertyUnmarshalerOf(v); err != nil {
+		return err
+	} else if unmarshaler != nil {
+		r, err := d.readProperties(obj)
+		if err != nil {
+			return err
+		}
+		return unmarshaler.UnmarshalBTProperties(r)
+	}
+
+	data, _ := obj.MarshalJSON()
+	return json.Unmarshal(data, v)
+}
+
+// DecodeNode decodes the node type from obj, then creates the node
+// with the type to decode obj into it.
+func (d *JSONDecoder) DecodeNode(obj *JSONObject) (Node, error) {
+	var nodeType NodeType
+	if err := obj.Get(JSONStringNodeType, &nodeType); err != nil {
+		return nil, err
+	}
+
+	meta := d.framework.getNodeMeta(nodeType)
+	if meta == nil {
+		return nil, errors.Errorf("meta of node type %s not found", nodeType)
+	}
+
+	var id NodeID
+	if obj.Has(JSONStringID) {
+		if err := obj.Get(JSONStringID, &id); err != nil {
+			return nil, err
+		}
+	}
+
+	var comment string
+	if obj.Has(JSONStringComment) {
+		if err := obj.Get(JSONStringComment, &comment); err != nil {
+			return nil, err
+		}
+	}
+
+	node := meta.createNode()
+	d.ids.set(node, id)
+	node.SetComment(comment)
+
+	if err := d.DecodeElement(node, obj); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// DecodeNodeAt decodes the member key of obj as node. It returns
+// nil if the member not found or null.
+func (d *JSONDecoder) DecodeNodeAt(obj *JSONObject, key string) (Node, error) {
+	nodeObj, err := obj.Object(key)
+	if err != nil || nodeObj == nil {
+		return nil, err
+	}
+
+	return d.DecodeNode(nodeObj)
+}
+
+// Read the members of obj as properties.
+func (d *JSONDecoder) readProperties(obj *JSONObject) (*propertyReader, error) {
+	r := newPropertyReader(d.framework)
+	for _, key := range obj.Keys() {
+		switch key {
+		case JSONStringChild:
+			child, err := d.DecodeNodeAt(obj, key)
+			if err != nil {
+				return nil, errors.WithMessage(err, "Unmarshal child")
+			}
+			r.child = child
+
+		case JSONStringChildren:
+			if err := d.readChildProperties(r, obj); err != nil {
+				return nil, err
+			}
+
+		default:
+			r.values[key] = obj.Raw(key)
+		}
+	}
+
+	return r, nil
+}
+
+// Read the member "children" of obj to r.
+func (d *JSONDecoder) readChildProperties(r *propertyReader, obj *JSONObject) error {
+	var childObjs []*JSONObject
+	if err := obj.Get(JSONStringChildren, &childObjs); err != nil {
+		return err
+	}
+
+	for i, childObj := range childObjs {
+		if childObj == nil {
+			return errors.Errorf("Unmarshal No.%d child: null", i)
+		}
+
+		props := newPropertyReader(d.framework)
+		var node Node
+		var err error
+		if childObj.Has(JSONStringNodeType) {
+			node, err = d.DecodeNode(childObj)
+		} else {
+			for _, key := range childObj.Keys() {
+				if key != JSONStringChild {
+					props.values[key] = childObj.Raw(key)
+				}
+			}
+
+			node, err = d.DecodeNodeAt(childObj, JSONStringChild)
+			if err == nil && node == nil {
+				err = errors.Errorf("member \"%s\" not found", JSONStringChild)
+			}
+		}
+
+		if err != nil {
+			return errors.WithMessagef(err, "Unmarshal No.%d child", i)
+		}
+
+		r.addChild(node, props)
+	}
+
+	return nil
+}
+
+func MarshalJSONTree(framework *Framework, t *tree) ([]byte, error) {
+	if framework == nil {
+		return nil, errors.New("marshal json tree: framework nil")
+	}
+
+	if t == nil {
+		return nil, nil
+	}
+
+	obj := NewJSONObject()
+	if err := newJSONEncoder(framework).EncodeElement(t, obj); err != nil {
+		return nil, errors.WithMessage(err, "marshal json tree")
+	}
+
+	data, err := json.MarshalIndent(obj, "", indent)
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshal json tree")
+	}
+
+	return data, nil
+}
+
+func UnmarshalJSONTree(framework *Framework, data []byte, t *tree) error {
+	if framework == nil {
+		return errors.New("unmarshal json tree: framework nil")
+	}
+
+	if data == nil || t == nil {
+		return nil
+	}
+
+	if err := unmarshalJSONTree(newJSONDecoder(framework), data, t); err != nil {
+		return errors.WithMessage(err, "unmarshal json tree")
+	}
+
+	return nil
+}
+
+func unmarshalJSONTree(d *JSONDecoder, data []byte, t *tree) error {
+	obj := NewJSONObject()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return err
+	}
+
+	return d.DecodeElement(t, obj)
+}
+
+func EncodeJSONTreeFile(framework *Framework, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("encode json tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := MarshalJSONTree(framework, t)
+	if err != nil {
+		return errors.WithMessagef(err, "encode json tree file: \"%s\"", path)
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// DecodeJSONTreeFS works like DecodeJSONTreeFile but read the file
+// from fsys.
+func DecodeJSONTreeFS(framework *Framework, fsys fs.FS, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("decode json tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	dec := newJSONDecoder(framework)
+	dec.path = path
+
+	if err := unmarshalJSONTree(dec, data, t); err != nil {
+		return errors.WithMessagef(err, "decode json tree file: \"%s\"", path)
+	}
+
+	return nil
+}
+
+func DecodeJSONTreeFile(framework *Framework, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("decode json tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := newJSONDecoder(framework)
+	dec.path = path
+
+	if err := unmarshalJSONTree(dec, data, t); err != nil {
+		return errors.WithMessagef(err, "decode json tree file: \"%s\"", path)
+	}
+
+	return nil
+}
+
+// MarshalJSONTree return an bevtree JSON encoding of t.
+func (f *Framework) MarshalJSONTree(t *tree) ([]byte, error) {
+	if data, err := MarshalJSONTree(f, t); err != nil {
+		return nil, errors.WithMessage(err, "framework")
+	} else {
+		return data, nil
+	}
+}
+
+// UnmarshalJSONTree parses the bevtree JSON-encoded Tree
+// data and stores the result in the Tree pointed to by t.
+func (f *Framework) UnmarshalJSONTree(data []byte, t *tree) error {
+	if err := UnmarshalJSONTree(f, data, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// EncodeJSONTreeFile works like MarshalJSONTree but write
+// encoded data to file.
+func (f *Framework) EncodeJSONTreeFile(path string, t *tree) error {
+	if err := EncodeJSONTreeFile(f, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// DecodeJSONTreeFile works like UnmarshalJSONTree but read
+// encoded data from file.
+func (f *Framework) DecodeJSONTreeFile(path string, t *tree) error {
+	if err := DecodeJSONTreeFile(f, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+// DecodeJSONTreeFS works like DecodeJSONTreeFile but read
+// the file from fsys.
+func (f *Framework) DecodeJSONTreeFS(fsys fs.FS, path string, t *tree) error {
+	if err := DecodeJSONTreeFS(f, fsys, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
+	}
+}
+
+func (t *tree) MarshalBTJSON(e *JSONEncoder, obj *JSONObject) error {
+	if t.name == "" {
+		return errors.New("Tree has no name")
+	}
+
+	obj.Set(JSONStringName, t.name)
+
+	if t.comment != "" {
+		obj.Set(JSONStringComment, t.comment)
+	}
+
+	// The missing IDs are assigned in the encoding only.
+	ids, _, err := t.completeNodeIDs()
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Marshal", t.name)
+	}
+	e.ids = ids
+
+	rootObj := NewJSONObject()
+	rootObj.Set(JSONStringID, ids[t._root])
+	if err := e.EncodeElement(t._root, rootObj); err != nil {
+		return errors.WithMessagef(err, "Tree %s Marshal root", t.name)
+	}
+
+	obj.Set(JSONStringRoot, rootObj)
+	return nil
+}
+
+func (t *tree) UnmarshalBTJSON(d *JSONDecoder, obj *JSONObject) error {
+	if err := obj.Get(JSONStringName, &t.name); err != nil || t.name == "" {
+		return errors.New("tree has no name")
+	}
+
+	if obj.Has(JSONStringComment) {
+		if err := obj.Get(JSONStringComment, &t.comment); err != nil {
+			return errors.WithMessagef(err, "Tree %s Unmarshal", t.name)
+		}
+	}
+
+	if t._root == nil {
+		t._root = newRootNode()
+	}
+
+	d.ids = nodeIDs{}
+	rootObj, err := obj.Object(JSONStringRoot)
+	if err == nil && rootObj == nil {
+		err = errors.Errorf("member \"%s\" not found", JSONStringRoot)
+	}
+	if err == nil && rootObj.Has(JSONStringID) {
+		var id NodeID
+		if err = rootObj.Get(JSONStringID, &id); err == nil {
+			d.ids.set(t._root, id)
+		}
+	}
+	if err == nil {
+		err = d.DecodeElement(t._root, rootObj)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal root", t.name)
+	}
+
+	t.ids = d.ids
+	missing, err := t.assignMissingNodeIDs()
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal", t.name)
+	}
+
+	if missing > 0 {
+		d.log(LogInfo, "node IDs generated", LogField{Key: LogKeyTree, Value: t.name}, LogField{Key: "count", Value: missing})
+	}
+
+	return nil
+}
+
+// The bev is encoded to the member "bev", by JSONMarshaler or by
+// encoding/json.
+func (b *BevNode) MarshalBTJSON(e *JSONEncoder, obj *JSONObject) error {
+	bevType := b.bev.BevType()
+	if e.framework.getBevMeta(bevType) == nil {
+		return errors.Errorf("BevNode Marshal: meta of bev type \"%s\" not found", bevType.String())
+	}
+
+	obj.Set(JSONStringBevType, bevType)
+
+	bevObj := NewJSONObject()
+	if err := e.EncodeElement(b.bev, bevObj); err != nil {
+		return errors.WithMessagef(err, "BevNode %s Marshal", bevType)
+	}
+
+	return obj.Set(JSONStringBev, bevObj)
+}
+
+func (b *BevNode) UnmarshalBTJSON(d *JSONDecoder, obj *JSONObject) error {
+	var bevType BevType
+	if err := obj.Get(JSONStringBevType, &bevType); err != nil {
+		return errors.WithMessage(err, "BevNode Unmarshal")
+	}
+
+	meta := d.framework.getBevMeta(bevType)
+	if meta == nil {
+		return errors.Errorf("BevNode Unmarshal: meta of bev type %s not found", bevType)
+	}
+
+	bevObj, err := obj.Object(JSONStringBev)
+	if err != nil {
+		return errors.WithMessagef(err, "BevNode %s Unmarshal", bevType)
+	} else if bevObj == nil {
+		bevObj = NewJSONObject()
+	}
+
+	bev := meta.createBev()
+	if err := d.DecodeElement(bev, bevObj); err != nil {
+		return errors.WithMessagef(err, "BevNode %s Unmarshal", bevType)
+	}
+
+	b.bev = bev
+	return nil
+}
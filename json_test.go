@@ -0,0 +1,174 @@
+package bevtree
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Build a tree with every type of node.
+func buildCodecTestTree(framework *Framework, name, subtreeName, key string) *tree {
+	sub := NewTree(subtreeName)
+	framework.addTree(sub)
+	sub.Root().SetChild(NewSucceederNode())
+	sub.Root().Child().(*SucceederNode).SetChild(NewBevNode(newBevBBIncr(key, 1)))
+
+	main := NewTree(name)
+	main.SetComment("codec test")
+	framework.addTree(main)
+
+	parallel := NewParallelNode()
+	parallel.SetComment("all nodes")
+	main.Root().SetChild(parallel)
+
+	repeater := NewRepeaterNode(3)
+	repeater.SetChild(NewBevNode(newBevBBIncr(key, 1)))
+	parallel.AddChild(repeater)
+
+	ruf := NewRepeatUntilFailNode(true)
+	inverter := NewInverterNode()
+	inverter.SetChild(NewBevNode(newBevBBIncr(key, 1)))
+	ruf.SetChild(inverter)
+	parallel.AddChild(ruf)
+
+	seq := NewSequenceNode()
+	seq.AddChild(NewBevNode(newBevBBIncr(key, 1)))
+	seq.AddChild(NewBevNode(newBevBBIncr(key, 1)))
+	parallel.AddChild(seq)
+
+	sel := NewSelectorNode()
+	sel.AddChild(NewBevNode(newBevBBIncr(key, 1)))
+	parallel.AddChild(sel)
+
+	randSeq := NewRandSequenceNode()
+	randSeq.AddChild(NewBevNode(newBevBBIncr(key, 1)))
+	parallel.AddChild(randSeq)
+
+	randSel := NewRandSelectorNode()
+	randSel.AddChild(NewBevNode(newBevBBIncr(key, 1)))
+	parallel.AddChild(randSel)
+
+	weightSel := NewWeightSelectorNode()
+	weightSel.AddChild(NewBevNode(newBevBBIncr(key, 1)), 0.25)
+	weightSel.AddChild(NewBevNode(newBevBBIncr(key, 1)), 0.75)
+	parallel.AddChild(weightSel)
+
+	parallel.AddChild(NewSubtreeNode(sub, false))
+
+	return main
+}
+
+// Run the tree for updates, returns the value of key.
+func runCodecTestTree(t *testing.T, framework *Framework, name, key string, updates int) int {
+	entity, err := framework.CreateEntity(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entity.Release()
+
+	entity.Context().DataSet().Set(key, 0)
+	for i := 0; i < updates; i++ {
+		entity.Update()
+	}
+
+	v, _ := entity.Context().DataSet().GetInt(key)
+	return v
+}
+
+func TestTreeMarshalJSON(t *testing.T) {
+	framework := newTestFramework()
+	key := "key"
+	oldTree := buildCodecTestTree(framework, "JSON测试", "JSON subtree", key)
+	sum := runCodecTestTree(t, framework, "JSON测试", key, 3)
+
+	data, err := framework.MarshalJSONTree(oldTree)
+	if err != nil {
+		t.Fatal("marshal Tree:", err)
+	}
+	t.Log("marshal Tree:", string(data))
+
+	newTree := new(tree)
+	if err := framework.UnmarshalJSONTree(data, newTree); err != nil {
+		t.Fatal("unmarshal Tree:", err)
+	}
+
+	if newTree.Comment() != "codec test" {
+		t.Fatalf("comment %q not kept", newTree.Comment())
+	}
+
+	ids, _, _ := oldTree.completeNodeIDs()
+	walkNode(oldTree.Root(), func(n Node) bool {
+		if oldTree.NodeID(n).Valid() {
+			t.Fatalf("node %s assigned id on marshaling", NodePath(n))
+		}
+		if found := newTree.NodeByID(ids[n]); found == nil || NodePath(found) != NodePath(n) || found.Comment() != n.Comment() {
+			t.Fatalf("node %s id %s not kept after unmarshal", NodePath(n), ids[n])
+		}
+		return true
+	})
+
+	again, err := framework.MarshalJSONTree(newTree)
+	if err != nil {
+		t.Fatal("marshal unmarshaled Tree:", err)
+	} else if !bytes.Equal(data, again) {
+		t.Fatalf("marshal unmarshaled Tree:\n%s\nbut before:\n%s", again, data)
+	}
+
+	newTree.SetName("JSON测试2")
+	framework.addTree(newTree)
+
+	if v := runCodecTestTree(t, framework, "JSON测试2", key, 3); v != sum {
+		t.Fatalf("test Tree after unmarshal: sum(%d) != %d", v, sum)
+	}
+
+	for _, bad := range []string{
+		`{"root":{}}`,
+		`{"name":"bad","root":{"child":{"nodetype":"unknown"}}}`,
+		`{"name":"bad","root":{"child":{"nodetype":"repeater"}}}`,
+		`{"name":"bad","root":{"child":{"nodetype":"behavior","bevtype":"unknown"}}}`,
+		`{"name":"bad","root":{"child":{"nodetype":"subtree","subtree":"unknown"}}}`,
+	} {
+		if err := framework.UnmarshalJSONTree([]byte(bad), new(tree)); err == nil {
+			t.Fatalf("unmarshal %s should fail", bad)
+		}
+	}
+}
+
+func TestInitTreeFormats(t *testing.T) {
+	key := "key"
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.xml")
+
+	{
+		framework := newTestFramework()
+		mainTree := buildCodecTestTree(framework, "main", "sub", key)
+		sub, _ := framework.getOrLoadTree("sub")
+
+		exporter := NewExporter(framework)
+		exporter.SetLoadAll(true)
+		exporter.AddTreeWithFormat(sub, "trees/sub.bt", TreeFormatJSON)
+		exporter.AddTree(mainTree, "trees/main.json")
+
+		if err := exporter.Export(configPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	framework := NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	if err := framework.Init(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := runCodecTestTree(t, framework, "main", key, 1); v == 0 {
+		t.Fatalf("tree loaded not run")
+	}
+
+	// The extension doesn't decide the format.
+	if err := framework.DecodeXMLTreeFile(filepath.Join(dir, "trees/sub.bt"), new(tree)); err == nil {
+		t.Fatal("sub.bt should be JSON")
+	} else if !strings.Contains(err.Error(), "sub.bt") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package bevtree
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel is the level of logging.
+type LogLevel int32
+
+const (
+	// Debug logs, e.g. agent transitions.
+	LogDebug = LogLevel(iota)
+
+	// Informational logs, e.g. tree loading.
+	LogInfo
+
+	// Warning logs, e.g. decode warnings.
+	LogWarn
+
+	// Error logs.
+	LogError
+
+	// Disable logging.
+	LogOff
+)
+
+var logLevelStrings = [...]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+	LogOff:   "off",
+}
+
+func (l LogLevel) String() string { return logLevelStrings[l] }
+
+// LogField is a key-value pair attached to log message.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// The keys of log fields.
+const (
+	LogKeyTree     = "tree"
+	LogKeyNode     = "node"
+	LogKeyNodeType = "nodetype"
+	LogKeyEntity   = "entity"
+	LogKeyPath     = "path"
+	LogKeyError    = "error"
+)
+
+// Logger is the structured logger used by Framework. Log is
+// only called with the levels enabled.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// The default logger, writes to the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	var sb strings.Builder
+	sb.WriteString("bevtree ")
+	sb.WriteString(level.String())
+	sb.WriteString(": ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		sb.WriteString(fmt.Sprintf(" %s=%v", f.Key, f.Value))
+	}
+	log.Println(sb.String())
+}
+
+// The default log level, all logs are enabled in debug mode.
+func defaultLogLevel() LogLevel {
+	if debug {
+		return LogDebug
+	}
+	return LogWarn
+}
+
+// SetLogger sets the logger. nil disables logging.
+func (s *Framework) SetLogger(logger Logger) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.logger = logger
+}
+
+// SetLogLevel sets the minimum level of logs. It is safe to
+// call at any time.
+func (s *Framework) SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&s.logLevel, int32(level))
+}
+
+// Get the minimum level of logs.
+func (s *Framework) LogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&s.logLevel))
+}
+
+func (s *Framework) logEnabled(level LogLevel) bool {
+	return s.logger != nil && level >= s.LogLevel() && level < LogOff
+}
+
+// Log if level enabled. Check logEnabled first on hot paths,
+// building fields costs.
+func (s *Framework) log(level LogLevel, msg string, fields ...LogField) {
+	if s.logEnabled(level) {
+		s.logger.Log(level, msg, fields...)
+	}
+}
+
+func (e *entity) logEnabled(level LogLevel) bool {
+	return e.ctx.framework().logEnabled(level)
+}
+
+// Log with the fields of entity.
+func (e *entity) log(level LogLevel, msg string, fields ...LogField) {
+	fields = append(fields,
+		LogField{Key: LogKeyTree, Value: e.ctx.Tree().Name()},
+		LogField{Key: LogKeyEntity, Value: e.id},
+	)
+	e.ctx.framework().log(level, msg, fields...)
+}
+
+// Log with the fields of entity and agent.
+func (e *entity) logAgent(level LogLevel, msg string, a *agent, fields ...LogField) {
+	fields = append(fields,
+		LogField{Key: LogKeyNode, Value: NodePath(a.node)},
+		LogField{Key: LogKeyNodeType, Value: a.node.NodeType()},
+	)
+	e.log(level, msg, fields...)
+}
@@ -0,0 +1,32 @@
+package bevtree
+
+import "go.uber.org/zap"
+
+// The adapter of zap.Logger.
+type zapLogger struct {
+	l *zap.Logger
+}
+
+// NewZapLogger returns a Logger writes to l. The level of l
+// filters logs too.
+func NewZapLogger(l *zap.Logger) Logger {
+	return &zapLogger{l: l}
+}
+
+func (z *zapLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	zfields := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zfields[i] = zap.Any(f.Key, f.Value)
+	}
+
+	switch level {
+	case LogDebug:
+		z.l.Debug(msg, zfields...)
+	case LogInfo:
+		z.l.Info(msg, zfields...)
+	case LogWarn:
+		z.l.Warn(msg, zfields...)
+	default:
+		z.l.Error(msg, zfields...)
+	}
+}
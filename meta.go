@@ -31,6 +31,11 @@ type nodeMeta struct {
 
 	// task pool, used to cache unused tasks of node type type.
 	taskPool *taskPool
+
+	// The descriptor registered and the schema described, nil if not
+	// registered with descriptor.
+	desc   *NodeDescriptor
+	schema *typeSchema
 }
 
 // Use creator to create node.
@@ -58,6 +63,11 @@ type bevMeta struct {
 
 	// The creator of behavior.
 	creator func() Bev
+
+	// The descriptor registered and the schema described, nil if not
+	// registered with descriptor.
+	desc   *BevDescriptor
+	schema *typeSchema
 }
 
 // Use creator to create behavior.
@@ -81,25 +91,88 @@ func newMeta() *meta {
 		bevMetas:  map[BevType]*bevMeta{},
 	}
 
-	m.RegisterNodeType(root, func() Node { return newRootNode() }, func() Task { return &rootTask{} })
-	m.RegisterNodeType(inverter, func() Node { return NewInverterNode() }, func() Task { return &inverterTask{} })
-	m.RegisterNodeType(succeeder, func() Node { return NewSucceederNode() }, func() Task { return &succeederTask{} })
-	m.RegisterNodeType(repeater, func() Node { return NewRepeaterNode(1) }, func() Task { return &repeaterTask{} })
-	m.RegisterNodeType(repeatUntilFail, func() Node { return NewRepeatUntilFailNode(false) }, func() Task { return &repeatUntilFailTask{} })
-	m.RegisterNodeType(sequence, func() Node { return NewSequenceNode() }, func() Task { return &sequenceTask{} })
-	m.RegisterNodeType(selector, func() Node { return NewSelectorNode() }, func() Task { return &selectorTask{} })
-	m.RegisterNodeType(randSequence, func() Node { return NewRandSequenceNode() }, func() Task { return &randSequenceTask{} })
-	m.RegisterNodeType(randSelector, func() Node { return NewRandSelectorNode() }, func() Task { return &randSelectorTask{} })
-	m.RegisterNodeType(parallel, func() Node { return NewParallelNode() }, func() Task { return &parallelTask{} })
-	m.RegisterNodeType(behavior, func() Node { return new(BevNode) }, func() Task { return &bevTask{} })
-	m.RegisterNodeType(subtree, func() Node { return new(SubtreeNode) }, func() Task { return &subtreeTask{} })
-	m.RegisterNodeType(weightSelector, func() Node { return new(WeightSelectorNode) }, func() Task { return &weightSelectorTask{} })
+	m.RegisterNodeType(root, func() Node { return newRootNode() }, func() Task { return &rootTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryDecorator,
+		MinChildren: 1,
+		Description: "The root node of behavior tree.",
+	})
+	m.RegisterNodeType(inverter, func() Node { return NewInverterNode() }, func() Task { return &inverterTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryDecorator,
+		MinChildren: 1,
+		Description: "Runs child and returns the reverse value of the result of child.",
+	})
+	m.RegisterNodeType(succeeder, func() Node { return NewSucceederNode() }, func() Task { return &succeederTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryDecorator,
+		MinChildren: 1,
+		Description: "Runs child and always returns success.",
+	})
+	m.RegisterNodeType(repeater, func() Node { return NewRepeaterNode(1) }, func() Task { return &repeaterTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryDecorator,
+		MinChildren: 1,
+		Params: []*ParamDescriptor{
+			{Name: PropertyLimited, Type: ParamInt, Required: true, Min: "1", Description: "The times to run child."},
+		},
+		Description: "Runs child in limited times until child returns failure.",
+	})
+	m.RegisterNodeType(repeatUntilFail, func() Node { return NewRepeatUntilFailNode(false) }, func() Task { return &repeatUntilFailTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryDecorator,
+		MinChildren: 1,
+		Params: []*ParamDescriptor{
+			{Name: PropertySuccessOnFail, Type: ParamBool, Required: true, Description: "Whether to return success when child returns failure."},
+		},
+		Description: "Runs child until child returns failure.",
+	})
+	m.RegisterNodeType(sequence, func() Node { return NewSequenceNode() }, func() Task { return &sequenceTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		Description: "Runs children one by one until a child returns failure, and returns the result of the last running child.",
+	})
+	m.RegisterNodeType(selector, func() Node { return NewSelectorNode() }, func() Task { return &selectorTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		Description: "Runs children one by one until a child returns success, and returns the result of the last running child.",
+	})
+	m.RegisterNodeType(randSequence, func() Node { return NewRandSequenceNode() }, func() Task { return &randSequenceTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		Description: "Runs children one by one in random order until a child returns failure.",
+	})
+	m.RegisterNodeType(randSelector, func() Node { return NewRandSelectorNode() }, func() Task { return &randSelectorTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		Description: "Runs children one by one in random order until a child returns success.",
+	})
+	m.RegisterNodeType(parallel, func() Node { return NewParallelNode() }, func() Task { return &parallelTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		Description: "Runs children together until a child returns failure, returns success if all children return success.",
+	})
+	m.RegisterNodeType(behavior, func() Node { return new(BevNode) }, func() Task { return &bevTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryLeaf,
+		Description: "Runs the bev of bevtype.",
+	})
+	m.RegisterNodeType(subtree, func() Node { return new(SubtreeNode) }, func() Task { return &subtreeTask{} }, &NodeDescriptor{
+		Category: NodeCategoryLeaf,
+		Params: []*ParamDescriptor{
+			{Name: PropertySubtree, Type: ParamString, Required: true, Description: "The name of the tree to run."},
+		},
+		Description: "Runs a subtree.",
+	})
+	m.RegisterNodeType(weightSelector, func() Node { return new(WeightSelectorNode) }, func() Task { return &weightSelectorTask{} }, &NodeDescriptor{
+		Category:    NodeCategoryComposite,
+		MinChildren: 1,
+		ChildParams: []*ParamDescriptor{
+			{Name: PropertyWeight, Type: ParamFloat, Required: true, Max: "1", Description: "The weight of child to be selected, greater than 0, the sum of weights is at most 1."},
+		},
+		Description: "Selects a child by weight and runs it.",
+	})
 
 	return m
 }
 
 // Register a type of node. It create metadata of the type of node.
-func (m *meta) RegisterNodeType(nodeType NodeType, nodeCreator func() Node, taskCreator func() Task) {
+// The optional descriptor describes the node type for editors.
+func (m *meta) RegisterNodeType(nodeType NodeType, nodeCreator func() Node, taskCreator func() Task, desc ...*NodeDescriptor) {
 	assert.AssertF(nodeType.Valid(), "invalid node type %s", nodeType.String())
 	assert.AssertF(m.nodeMetas[nodeType] == nil, "node type \"%s\" registered", nodeType.String())
 	assert.AssertF(nodeCreator != nil, "nodeCreator of node type \"%s\" nil", nodeType.String())
@@ -120,14 +193,22 @@ func (m *meta) RegisterNodeType(nodeType NodeType, nodeCreator func() Node, task
 		taskPool: newTaskPool(taskCreator),
 	}
 
+	assert.AssertF(len(desc) <= 1, "node type \"%s\" has %d descriptors", nodeType.String(), len(desc))
+	if len(desc) > 0 && desc[0] != nil {
+		meta.desc = desc[0].copy()
+		schema, err := meta.desc.normalize(nodeType)
+		assert.AssertF(err == nil, "descriptor of node type \"%s\": %v", nodeType.String(), err)
+		meta.schema = schema
+	}
+
 	m.nodeMetas[nodeType] = meta
 }
 
 func (m *meta) getNodeMeta(nodeType NodeType) *nodeMeta { return m.nodeMetas[nodeType] }
 
 // Register a type of behavior. It create the metadata of the
-// behavior.
-func (m *meta) RegisterBevType(bevType BevType, creator func() Bev) {
+// behavior. The optional descriptor describes the bev type for editors.
+func (m *meta) RegisterBevType(bevType BevType, creator func() Bev, desc ...*BevDescriptor) {
 	assert.AssertF(bevType.Valid(), "invalid bev type %s", bevType.String())
 	assert.AssertF(m.bevMetas[bevType] == nil, "bev type \"%s\" already registered", bevType.String())
 	assert.AssertF(creator != nil, "creator of bev type \"%s\" nil", bevType.String())
@@ -141,6 +222,14 @@ func (m *meta) RegisterBevType(bevType BevType, creator func() Bev) {
 		creator: creator,
 	}
 
+	assert.AssertF(len(desc) <= 1, "bev type \"%s\" has %d descriptors", bevType.String(), len(desc))
+	if len(desc) > 0 && desc[0] != nil {
+		meta.desc = desc[0].copy()
+		schema, err := meta.desc.normalize(bevType)
+		assert.AssertF(err == nil, "descriptor of bev type \"%s\": %v", bevType.String(), err)
+		meta.schema = schema
+	}
+
 	m.bevMetas[bevType] = meta
 }
 
@@ -0,0 +1,388 @@
+package bevtree
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The upper bounds of latency histogram buckets, in seconds.
+var latencyBuckets = [...]float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// A latency histogram, safe for concurrent use.
+type histogram struct {
+	// The counts of buckets, the last is +Inf.
+	counts [len(latencyBuckets) + 1]uint64
+
+	// Sum of observations in nanoseconds.
+	sum int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets[:], s)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	var s Histogram
+	s.Buckets = latencyBuckets[:]
+	s.Counts = make([]uint64, len(h.counts))
+
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		s.Counts[i] = cumulative
+	}
+
+	s.Count = cumulative
+	s.Sum = time.Duration(atomic.LoadInt64(&h.sum))
+	return s
+}
+
+// Histogram is a snapshot of latency histogram.
+type Histogram struct {
+	// The upper bounds of buckets in seconds, except +Inf.
+	Buckets []float64
+
+	// The cumulative counts of buckets, the last is +Inf.
+	Counts []uint64
+
+	// Total count.
+	Count uint64
+
+	// Total time.
+	Sum time.Duration
+}
+
+// The metrics of node.
+type nodeMetrics struct {
+	inits     uint64
+	successes uint64
+	failures  uint64
+	aborts    uint64
+	update    histogram
+
+	tree     string
+	id       NodeID
+	nodeType NodeType
+	path     string
+}
+
+// NodeMetrics is a snapshot of the metrics of node.
+type NodeMetrics struct {
+	Tree     string
+	ID       NodeID
+	NodeType NodeType
+	Path     string
+
+	// The times the node initialized.
+	Inits uint64
+
+	// The times the node completed with success or failure.
+	Successes uint64
+	Failures  uint64
+
+	// The times the node aborted or stopped.
+	Aborts uint64
+
+	// The time spent in OnUpdate of task.
+	Update Histogram
+}
+
+// PoolMetrics is a snapshot of the metrics of object pool.
+type PoolMetrics struct {
+	Name string
+
+	// The times getting object.
+	Gets uint64
+
+	// The times object created on getting.
+	News uint64
+
+	// The times putting object.
+	Puts uint64
+}
+
+// Get the hit rate of getting object.
+func (p *PoolMetrics) HitRate() float64 {
+	if p.Gets == 0 {
+		return 0
+	}
+	return float64(p.Gets-p.News) / float64(p.Gets)
+}
+
+// Metrics is the registry of runtime metrics of Framework, set
+// by Framework.SetMetrics. It collects the counters and latencies
+// of nodes, and the duration of entity updates. The metrics of
+// object pools are collected since a registry set to any
+// framework. The nodes are identified by tree name and node ID,
+// or node path if the node has no ID.
+type Metrics struct {
+	framework *Framework
+	nodes     sync.Map
+	update    histogram
+}
+
+// NewMetrics creates a Metrics registry.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// SetMetrics sets the metrics registry of the framework. A
+// registry can only be set to one framework.
+func (s *Framework) SetMetrics(m *Metrics) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	if m != nil {
+		if m.framework != nil && m.framework != s {
+			panic("bevtree metrics set to another framework")
+		}
+		m.framework = s
+		enablePoolCounting()
+	}
+
+	s.metrics = m
+}
+
+// Get the metrics registry of the framework.
+func (s *Framework) Metrics() *Metrics { return s.metrics }
+
+// The key of node metrics, the path is set if the node has no ID.
+type nodeMetricsKey struct {
+	tree string
+	id   NodeID
+	path string
+}
+
+func (m *Metrics) node(tree Tree, node Node) *nodeMetrics {
+	key := nodeMetricsKey{tree: tree.Name(), id: tree.NodeID(node)}
+	if !key.id.Valid() {
+		key.path = NodePath(node)
+	}
+
+	if v, ok := m.nodes.Load(key); ok {
+		return v.(*nodeMetrics)
+	}
+
+	v, _ := m.nodes.LoadOrStore(key, &nodeMetrics{
+		tree:     key.tree,
+		id:       key.id,
+		nodeType: node.NodeType(),
+		path:     NodePath(node),
+	})
+	return v.(*nodeMetrics)
+}
+
+func (m *Metrics) nodeInit(tree Tree, node Node) {
+	atomic.AddUint64(&m.node(tree, node).inits, 1)
+}
+
+func (m *Metrics) nodeUpdate(tree Tree, node Node, d time.Duration) {
+	m.node(tree, node).update.observe(d)
+}
+
+func (m *Metrics) nodeTerminate(tree Tree, node Node, reason TerminateReason, result Result) {
+	nm := m.node(tree, node)
+	switch {
+	case reason != TerminateCompleted:
+		atomic.AddUint64(&nm.aborts, 1)
+	case result == Success:
+		atomic.AddUint64(&nm.successes, 1)
+	default:
+		atomic.AddUint64(&nm.failures, 1)
+	}
+}
+
+func (m *Metrics) entityUpdate(d time.Duration) {
+	m.update.observe(d)
+}
+
+// Get the metrics of nodes, sorted by tree and node ID.
+func (m *Metrics) Nodes() []NodeMetrics {
+	var nodes []NodeMetrics
+	m.nodes.Range(func(_, v interface{}) bool {
+		nm := v.(*nodeMetrics)
+		nodes = append(nodes, NodeMetrics{
+			Tree:      nm.tree,
+			ID:        nm.id,
+			NodeType:  nm.nodeType,
+			Path:      nm.path,
+			Inits:     atomic.LoadUint64(&nm.inits),
+			Successes: atomic.LoadUint64(&nm.successes),
+			Failures:  atomic.LoadUint64(&nm.failures),
+			Aborts:    atomic.LoadUint64(&nm.aborts),
+			Update:    nm.update.snapshot(),
+		})
+		return true
+	})
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Tree != nodes[j].Tree {
+			return nodes[i].Tree < nodes[j].Tree
+		}
+		if nodes[i].ID != nodes[j].ID {
+			return nodes[i].ID < nodes[j].ID
+		}
+		return nodes[i].Path < nodes[j].Path
+	})
+
+	return nodes
+}
+
+// Get the duration of entity updates.
+func (m *Metrics) EntityUpdate() Histogram { return m.update.snapshot() }
+
+// Get the metrics of object pools, the agent pool, the list
+// element pool and the task pools of node types.
+func (m *Metrics) Pools() []PoolMetrics {
+	pools := []PoolMetrics{
+		agentPool.metrics("agent"),
+		_elemPool.p.metrics("element"),
+	}
+
+	if m.framework != nil {
+		var tasks []PoolMetrics
+		for nodeType, meta := range m.framework.nodeMetas {
+			tasks = append(tasks, meta.taskPool.metrics("task:"+nodeType.String()))
+		}
+		sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+		pools = append(pools, tasks...)
+	}
+
+	return pools
+}
+
+// WritePrometheus writes the metrics in Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	nodes := m.Nodes()
+	nodeLabels := make([]string, len(nodes))
+	for i, n := range nodes {
+		nodeLabels[i] = promLabels("tree", n.Tree, "node_id", n.ID.String(), "node_type", n.NodeType.String(), "path", n.Path)
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(*NodeMetrics) uint64
+	}{
+		{"bevtree_node_inits_total", "Times the node initialized.", func(n *NodeMetrics) uint64 { return n.Inits }},
+		{"bevtree_node_successes_total", "Times the node completed with success.", func(n *NodeMetrics) uint64 { return n.Successes }},
+		{"bevtree_node_failures_total", "Times the node completed with failure.", func(n *NodeMetrics) uint64 { return n.Failures }},
+		{"bevtree_node_aborts_total", "Times the node aborted or stopped.", func(n *NodeMetrics) uint64 { return n.Aborts }},
+	}
+
+	for _, c := range counters {
+		promHeader(bw, c.name, c.help, "counter")
+		for i := range nodes {
+			fmt.Fprintf(bw, "%s{%s} %d\n", c.name, nodeLabels[i], c.value(&nodes[i]))
+		}
+	}
+
+	promHeader(bw, "bevtree_node_update_seconds", "Time spent in OnUpdate of node.", "histogram")
+	for i := range nodes {
+		promHistogram(bw, "bevtree_node_update_seconds", nodeLabels[i], nodes[i].Update)
+	}
+
+	promHeader(bw, "bevtree_entity_update_seconds", "Duration of entity updates.", "histogram")
+	promHistogram(bw, "bevtree_entity_update_seconds", "", m.EntityUpdate())
+
+	pools := m.Pools()
+	poolCounters := []struct {
+		name, help string
+		value      func(*PoolMetrics) uint64
+	}{
+		{"bevtree_pool_gets_total", "Times getting object from pool.", func(p *PoolMetrics) uint64 { return p.Gets }},
+		{"bevtree_pool_news_total", "Times object created on getting from pool.", func(p *PoolMetrics) uint64 { return p.News }},
+		{"bevtree_pool_puts_total", "Times putting object to pool.", func(p *PoolMetrics) uint64 { return p.Puts }},
+	}
+
+	for _, c := range poolCounters {
+		promHeader(bw, c.name, c.help, "counter")
+		for i := range pools {
+			fmt.Fprintf(bw, "%s{%s} %d\n", c.name, promLabels("pool", pools[i].Name), c.value(&pools[i]))
+		}
+	}
+
+	promHeader(bw, "bevtree_pool_hit_ratio", "Hit rate of getting object from pool.", "gauge")
+	for i := range pools {
+		fmt.Fprintf(bw, "bevtree_pool_hit_ratio{%s} %s\n", promLabels("pool", pools[i].Name), promFloat(pools[i].HitRate()))
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler serves the metrics in
+// Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := m.WritePrometheus(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := w.Write(buf.Bytes()); err != nil && m.framework != nil {
+			m.framework.log(LogWarn, "metrics", LogField{Key: LogKeyError, Value: err})
+		}
+	})
+}
+
+func promHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func promHistogram(w io.Writer, name, labels string, h Histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	for i, le := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, promFloat(le), h.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.Count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, promFloat(h.Sum.Seconds()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// Format label pairs of key, value.
+func promLabels(kvs ...string) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(kvs[i])
+		sb.WriteString(`="`)
+		promLabelEscaper.WriteString(&sb, kvs[i+1])
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,342 @@
+package bevtree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The version of tree and config XML documents without migrations
+// registered. The documents without version attribute are this version.
+const XMLFormatVersion = 1
+
+// XMLElement is the generic form of XML element, which migrations
+// edit before decoding. The Text is written only if the element has
+// no children.
+type XMLElement struct {
+	Name     string
+	Attr     []xml.Attr
+	Text     string
+	Children []*XMLElement
+
+	// The offset in source.
+	offset int64
+}
+
+// AttrValue returns the value of attribute name.
+func (e *XMLElement) AttrValue(name string) (string, bool) {
+	for _, attr := range e.Attr {
+		if attr.Name.Local == name {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// SetAttr sets the value of attribute name, adds it if not exist.
+func (e *XMLElement) SetAttr(name, value string) {
+	for i := range e.Attr {
+		if e.Attr[i].Name.Local == name {
+			e.Attr[i].Value = value
+			return
+		}
+	}
+
+	e.Attr = append(e.Attr, xml.Attr{Name: XMLName(name), Value: value})
+}
+
+// RemoveAttr removes the attribute name.
+func (e *XMLElement) RemoveAttr(name string) {
+	for i := range e.Attr {
+		if e.Attr[i].Name.Local == name {
+			e.Attr = append(e.Attr[:i], e.Attr[i+1:]...)
+			return
+		}
+	}
+}
+
+// Walk calls f for e and its descendants in depth-first order, and
+// stops at the first error.
+func (e *XMLElement) Walk(f func(*XMLElement) error) error {
+	if err := f(e); err != nil {
+		return err
+	}
+
+	for _, child := range e.Children {
+		if err := child.Walk(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readXMLElement(d *xml.Decoder, start xml.StartElement, offset int64) (*XMLElement, error) {
+	e := &XMLElement{Name: start.Name.Local, Attr: start.Attr, offset: offset}
+	for {
+		offset := d.InputOffset()
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := readXMLElement(d, t, offset)
+			if err != nil {
+				return nil, err
+			}
+			e.Children = append(e.Children, child)
+
+		case xml.CharData:
+			e.Text += string(t)
+
+		case xml.EndElement:
+			return e, nil
+		}
+	}
+}
+
+// Encode the element to buf, and add the offsets of elements to s
+// if not nil.
+func (e *XMLElement) encode(enc *xml.Encoder, buf *bytes.Buffer, s *xmlSource) error {
+	if s != nil {
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		s.offsets = append(s.offsets, xmlOffset{to: int64(buf.Len()), from: e.offset})
+	}
+
+	start := xml.StartElement{Name: XMLName(e.Name), Attr: e.Attr}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	if len(e.Children) == 0 && e.Text != "" {
+		if err := enc.EncodeToken(xml.CharData(e.Text)); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range e.Children {
+		if err := child.encode(enc, buf, s); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// The offset of element in migrated data and source data.
+type xmlOffset struct {
+	to, from int64
+}
+
+// The source of migrated data.
+type xmlSource struct {
+	data []byte
+
+	// The offsets of elements, in the order of offset in migrated
+	// data.
+	offsets []xmlOffset
+}
+
+// Get the offset in source by the offset in migrated data, that is
+// the offset of the element containing it.
+func (s *xmlSource) offsetOf(offset int64) int64 {
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i].to > offset })
+	if i == 0 {
+		return 0
+	}
+
+	return s.offsets[i-1].from
+}
+
+// XMLMigration upgrades the root element of XML document by one
+// version.
+type XMLMigration func(root *XMLElement) error
+
+// RegisterTreeMigration registers the migration upgrading tree XML
+// documents from TreeXMLVersion to the next version. The trees are
+// written in the latest version.
+func (s *Framework) RegisterTreeMigration(m XMLMigration) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.treeMigrations = append(s.treeMigrations, m)
+}
+
+// RegisterConfigMigration registers the migration upgrading config
+// documents from ConfigXMLVersion to the next version.
+func (s *Framework) RegisterConfigMigration(m XMLMigration) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.configMigrations = append(s.configMigrations, m)
+}
+
+// TreeXMLVersion returns the version of tree XML documents.
+func (s *Framework) TreeXMLVersion() int { return XMLFormatVersion + len(s.treeMigrations) }
+
+// ConfigXMLVersion returns the version of config documents.
+func (s *Framework) ConfigXMLVersion() int { return XMLFormatVersion + len(s.configMigrations) }
+
+// Get the version in the attributes of element.
+func xmlVersionOf(attrs []xml.Attr) (int, error) {
+	for _, attr := range attrs {
+		if attr.Name == XMLName(XMLStringVersion) {
+			v, err := strconv.Atoi(attr.Value)
+			if err != nil || v < XMLFormatVersion {
+				return 0, errors.Errorf("invalid version \"%s\"", attr.Value)
+			}
+			return v, nil
+		}
+	}
+
+	return XMLFormatVersion, nil
+}
+
+// migrateXML upgrades the document data of the root element name by
+// migrations, the data is returned as is if in the latest version.
+// Otherwise, the source is returned for the positions in data.
+func (s *Framework) migrateXML(data []byte, name string, migrations []XMLMigration, path string) ([]byte, *xmlSource, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	// The syntax errors are at the current offset.
+	errorAt := func(err error) error {
+		de := &DecodeError{Path: path, Offset: d.InputOffset(), Err: err}
+		de.Line, de.Column = xmlPosition(data, de.Offset)
+		return DecodeErrors{de}
+	}
+
+	var start xml.StartElement
+	var offset int64
+	for {
+		offset = d.InputOffset()
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil, nil, errors.Errorf("element <%s> not found", name)
+		} else if err != nil {
+			return nil, nil, errorAt(err)
+		}
+
+		if se, ok := token.(xml.StartElement); ok && se.Name == XMLName(name) {
+			start = se
+			break
+		}
+	}
+
+	version, err := xmlVersionOf(start.Attr)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "<%s>", name)
+	}
+
+	latest := XMLFormatVersion + len(migrations)
+	if version > latest {
+		return nil, nil, errors.Errorf("<%s> version %d newer than %d", name, version, latest)
+	} else if version == latest {
+		return data, nil, nil
+	}
+
+	root, err := readXMLElement(d, start, offset)
+	if err != nil {
+		return nil, nil, errorAt(err)
+	}
+
+	for v := version; v < latest; v++ {
+		if err := migrations[v-XMLFormatVersion](root); err != nil {
+			return nil, nil, errors.WithMessagef(err, "<%s> migrate version %d to %d", name, v, v+1)
+		}
+	}
+
+	root.SetAttr(XMLStringVersion, strconv.Itoa(latest))
+
+	var buf bytes.Buffer
+	source := &xmlSource{data: data}
+	enc := xml.NewEncoder(&buf)
+	if err := root.encode(enc, &buf, source); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	if s.logEnabled(LogInfo) {
+		fields := []LogField{{Key: "element", Value: name}, {Key: "from", Value: version}, {Key: "to", Value: latest}}
+		if path != "" {
+			fields = append(fields, LogField{Key: LogKeyPath, Value: path})
+		}
+		s.log(LogInfo, "xml migrated", fields...)
+	}
+
+	return buf.Bytes(), source, nil
+}
+
+// Rename the attribute and child elements of properties from to to,
+// in the elements with attribute typeAttr typ.
+func renamePropertyMigration(typeAttr, typ, from, to string) XMLMigration {
+	return func(root *XMLElement) error {
+		return root.Walk(func(e *XMLElement) error {
+			if v, ok := e.AttrValue(typeAttr); !ok || v != typ {
+				return nil
+			}
+
+			for i := range e.Attr {
+				if e.Attr[i].Name.Local == from {
+					e.Attr[i].Name = XMLName(to)
+				}
+			}
+
+			for _, child := range e.Children {
+				if child.Name == from {
+					child.Name = to
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+// Rename the values of attribute typeAttr from from to to.
+func renameTypeMigration(typeAttr, from, to string) XMLMigration {
+	return func(root *XMLElement) error {
+		return root.Walk(func(e *XMLElement) error {
+			if v, ok := e.AttrValue(typeAttr); ok && v == from {
+				e.SetAttr(typeAttr, to)
+			}
+			return nil
+		})
+	}
+}
+
+// RenameNodeTypeMigration returns the migration renaming node type
+// from to to.
+func RenameNodeTypeMigration(from, to NodeType) XMLMigration {
+	return renameTypeMigration(XMLStringNodeType, from.String(), to.String())
+}
+
+// RenameBevTypeMigration returns the migration renaming bev type from
+// to to.
+func RenameBevTypeMigration(from, to BevType) XMLMigration {
+	return renameTypeMigration(XMLStringBevType, from.String(), to.String())
+}
+
+// RenameNodePropertyMigration returns the migration renaming the
+// property from to to of nodes in node type.
+func RenameNodePropertyMigration(nodeType NodeType, from, to string) XMLMigration {
+	return renamePropertyMigration(XMLStringNodeType, nodeType.String(), from, to)
+}
+
+// RenameBevPropertyMigration returns the migration renaming the
+// property from to to of bevs in bev type.
+func RenameBevPropertyMigration(bevType BevType, from, to string) XMLMigration {
+	return renamePropertyMigration(XMLStringBevType, bevType.String(), from, to)
+}
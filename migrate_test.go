@@ -0,0 +1,133 @@
+package bevtree
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTreeMigration(t *testing.T) {
+	framework := NewFramework()
+	framework.RegisterBevType(blackboardIncr, func() Bev { return &bevBBIncr{} })
+	framework.RegisterTreeMigration(RenameBevTypeMigration("oldIncr", blackboardIncr))
+	framework.RegisterTreeMigration(RenameBevPropertyMigration(blackboardIncr, "Count", "Limited"))
+	framework.RegisterTreeMigration(RenameNodeTypeMigration("seq", sequence))
+	framework.initialized = true
+
+	if v := framework.TreeXMLVersion(); v != 4 {
+		t.Fatalf("version %d != 4", v)
+	}
+
+	check := func(data string) {
+		t.Helper()
+
+		newTree := new(tree)
+		if err := framework.UnmarshalXMLTree([]byte(data), newTree); err != nil {
+			t.Fatal(err)
+		}
+
+		seq := newTree.Root().Child().(*SequenceNode)
+		b := seq.Child(0).(*BevNode).Bev().(*bevBBIncr)
+		if b.Key != "k" || b.Limited != 3 {
+			t.Fatalf("bev not migrated: %+v", *b)
+		}
+
+		out, err := framework.MarshalXMLTree(newTree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(out), `version="4"`) {
+			t.Fatalf("latest version not written:\n%s", out)
+		}
+
+		if err := framework.UnmarshalXMLTree(out, new(tree)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Version 1, the version attribute omitted.
+	check(`<bevtree name="old">
+    <root>
+        <child nodetype="seq">
+            <childs count="1">
+                <child nodetype="behavior" bevtype="oldIncr"><Key>k</Key><Count>3</Count></child>
+            </childs>
+        </child>
+    </root>
+</bevtree>`)
+
+	// Version 2, the bev type renamed.
+	check(`<bevtree name="old" version="2"><root><child nodetype="seq"><childs count="1">
+<child nodetype="behavior" bevtype="blackboardIncr"><Key>k</Key><Count>3</Count></child>
+</childs></child></root></bevtree>`)
+
+	for data, msg := range map[string]string{
+		`<bevtree name="new" version="5"><root></root></bevtree>`:        "version 5 newer than 4",
+		`<bevtree name="bad" version="x"><root></root></bevtree>`:        `invalid version "x"`,
+		`<bevtree name="bad" version="0"><root></root></bevtree>`:        `invalid version "0"`,
+		`<bevtree name="bad" version="2"><root><child></root></bevtree>`: "syntax error",
+	} {
+		if err := framework.UnmarshalXMLTree([]byte(data), new(tree)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("unmarshal %s: error %v, want %s", data, err, msg)
+		}
+	}
+
+	// The tree of newer version is rejected by the framework without
+	// migrations.
+	out, _ := framework.MarshalXMLTree(NewTree("latest"))
+	if err := NewFramework().UnmarshalXMLTree(out, new(tree)); err == nil || !strings.Contains(err.Error(), "version 4 newer than 1") {
+		t.Fatalf("unmarshal newer version: %v", err)
+	}
+}
+
+func TestConfigMigration(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.xml": &fstest.MapFile{Data: []byte(`<config>
+    <loadall>true</loadall>
+    <trees>
+        <tree name="main" file="main.xml"></tree>
+    </trees>
+</config>`)},
+		"main.xml": &fstest.MapFile{Data: []byte(`<bevtree name="main"><root></root></bevtree>`)},
+	}
+
+	framework := NewFramework()
+	framework.RegisterConfigMigration(func(root *XMLElement) error {
+		return root.Walk(func(e *XMLElement) error {
+			switch e.Name {
+			case "trees":
+				e.Name = "bevtrees"
+			case "tree":
+				e.Name = "bevtree"
+				v, _ := e.AttrValue("file")
+				e.RemoveAttr("file")
+				e.SetAttr("path", v)
+			}
+			return nil
+		})
+	})
+
+	if err := framework.InitFS(fsys, "config.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := framework.GetOrLoadTree("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config is exported in the latest version.
+	exporter := NewExporter(framework)
+	exported := fstest.MapFS{}
+	if err := exporter.ExportFS(mapWriteFS(exported), "config.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := string(exported["config.xml"].Data); !strings.Contains(data, `<config version="2">`) {
+		t.Fatalf("latest version not written:\n%s", data)
+	}
+
+	if err := NewFramework().InitFS(exported, "config.xml"); err == nil || !strings.Contains(err.Error(), "version 2 newer than 1") {
+		t.Fatalf("init with newer config: %v", err)
+	}
+}
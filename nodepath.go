@@ -0,0 +1,175 @@
+package bevtree
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The separator of node path segments.
+const nodePathSep = "/"
+
+// Get the child nodes of n in order. Subtree is not expanded,
+// it is another tree.
+func childNodes(n Node) []Node {
+	switch o := n.(type) {
+	case *rootNode:
+		if o.child != nil {
+			return []Node{o.child}
+		}
+
+	case *WeightSelectorNode:
+		children := make([]Node, len(o.children))
+		for i, c := range o.children {
+			children[i] = c.node
+		}
+		return children
+
+	case CompositeNode:
+		children := make([]Node, o.ChildCount())
+		for i := range children {
+			children[i] = o.Child(i)
+		}
+		return children
+
+	case DecoratorNode:
+		if child := o.Child(); child != nil {
+			return []Node{child}
+		}
+	}
+
+	return nil
+}
+
+// walkNode traverses n and its descendants in depth-first order
+// until f returns false. walkNode returns false if the traversal
+// was stopped by f.
+func walkNode(n Node, f func(Node) bool) bool {
+	if !f(n) {
+		return false
+	}
+
+	for _, child := range childNodes(n) {
+		if !walkNode(child, f) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The name of node in node path. It is the bev type for
+// behavior node, the node type for others.
+func nodePathName(n Node) string {
+	if b, ok := n.(*BevNode); ok && b.bev != nil {
+		return b.bev.BevType().String()
+	}
+
+	return n.NodeType().String()
+}
+
+// Get the path segment of n. The index distinguishes n from the
+// siblings with the same name, it is omitted when zero.
+func nodePathSegment(n Node) string {
+	name := nodePathName(n)
+
+	idx := 0
+	if parent := n.Parent(); parent != nil {
+		for _, sibling := range childNodes(parent) {
+			if sibling == n {
+				break
+			}
+
+			if nodePathName(sibling) == name {
+				idx++
+			}
+		}
+	}
+
+	if idx == 0 {
+		return name
+	}
+
+	return name + "[" + strconv.Itoa(idx) + "]"
+}
+
+// NodePath returns the path of n from the root node, like
+// "root/selector[2]/attack". Each segment is the node type,
+// or bev type for behavior node, followed by the zero-based
+// index among the siblings with the same name if non-zero.
+func NodePath(n Node) string {
+	if n == nil {
+		return ""
+	}
+
+	var segments []string
+	for ; n != nil; n = n.Parent() {
+		segments = append(segments, nodePathSegment(n))
+	}
+
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+
+	return strings.Join(segments, nodePathSep)
+}
+
+// Parse a path segment into name and index.
+func parseNodePathSegment(segment string) (string, int, error) {
+	if !strings.HasSuffix(segment, "]") {
+		return segment, 0, nil
+	}
+
+	lb := strings.LastIndex(segment, "[")
+	if lb <= 0 {
+		return "", 0, errors.Errorf("invalid segment \"%s\"", segment)
+	}
+
+	idx, err := strconv.Atoi(segment[lb+1 : len(segment)-1])
+	if err != nil || idx < 0 {
+		return "", 0, errors.Errorf("invalid segment \"%s\"", segment)
+	}
+
+	return segment[:lb], idx, nil
+}
+
+// Find the node at path from root.
+func findNodeByPath(root Node, path string) (Node, error) {
+	segments := strings.Split(path, nodePathSep)
+
+	name, idx, err := parseNodePathSegment(segments[0])
+	if err != nil {
+		return nil, errors.WithMessagef(err, "node path \"%s\"", path)
+	}
+
+	if name != nodePathName(root) || idx != 0 {
+		return nil, nil
+	}
+
+	n := root
+	for _, segment := range segments[1:] {
+		if name, idx, err = parseNodePathSegment(segment); err != nil {
+			return nil, errors.WithMessagef(err, "node path \"%s\"", path)
+		}
+
+		var next Node
+		for _, child := range childNodes(n) {
+			if nodePathName(child) == name {
+				if idx == 0 {
+					next = child
+					break
+				}
+				idx--
+			}
+		}
+
+		if next == nil {
+			return nil, nil
+		}
+
+		n = next
+	}
+
+	return n, nil
+}
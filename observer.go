@@ -0,0 +1,139 @@
+package bevtree
+
+// EventType indicates the type of Event.
+type EventType int8
+
+const (
+	// The entity was created.
+	EventEntityCreate = EventType(iota)
+
+	// The entity was updated, Event.Result is the result of
+	// Entity.Update.
+	EventEntityUpdate
+
+	// The entity is stopping by Entity.Stop.
+	EventEntityStop
+
+	// The entity is releasing by Entity.Release.
+	EventEntityRelease
+
+	// The agent of node was created.
+	EventAgentCreate
+
+	// The agent was initialized, Event.Result is Running if
+	// the initialization succeeded, or Failure.
+	EventAgentInit
+
+	// The agent was updated, Event.Result is the result of
+	// the update.
+	EventAgentUpdate
+
+	// The agent made decision on a child terminated, Event.Result
+	// is the decision.
+	EventAgentChildTerminated
+
+	// The agent is lazy-stopping by parent.
+	EventAgentLazyStop
+
+	// The agent is stopping by Entity.Stop or Entity.Release.
+	EventAgentStop
+
+	// The agent is destroying.
+	EventAgentDestroy
+)
+
+var eventTypeStrings = [...]string{
+	EventEntityCreate:         "entity-create",
+	EventEntityUpdate:         "entity-update",
+	EventEntityStop:           "entity-stop",
+	EventEntityRelease:        "entity-release",
+	EventAgentCreate:          "agent-create",
+	EventAgentInit:            "agent-init",
+	EventAgentUpdate:          "agent-update",
+	EventAgentChildTerminated: "agent-child-terminated",
+	EventAgentLazyStop:        "agent-lazy-stop",
+	EventAgentStop:            "agent-stop",
+	EventAgentDestroy:         "agent-destroy",
+}
+
+func (t EventType) String() string { return eventTypeStrings[t] }
+
+// Event describes what happened in an entity. The Entity and
+// Node must not be retained after the observer returns.
+type Event struct {
+	// Event type.
+	Type EventType
+
+	// The entity the event happened in. The entity runs subtree
+	// is created internally.
+	Entity Entity
+
+	// The node of agent, nil for entity events.
+	Node Node
+
+	// The result related to the event.
+	Result Result
+
+	// The update serial number of entity.
+	UpdateSeri uint32
+}
+
+// Get the node type, empty for entity events.
+func (e *Event) NodeType() NodeType {
+	if e.Node == nil {
+		return NodeType("")
+	}
+	return e.Node.NodeType()
+}
+
+// Get the node path, empty for entity events.
+func (e *Event) NodePath() string { return NodePath(e.Node) }
+
+// Observer observes the lifecycle of entities and the execution
+// of nodes. OnEvent is called synchronously on the goroutine
+// updating the entity.
+type Observer interface {
+	OnEvent(*Event)
+}
+
+// ObserverFunc is an adapter to use ordinary function as Observer.
+type ObserverFunc func(*Event)
+
+func (f ObserverFunc) OnEvent(e *Event) { f(e) }
+
+// SetObserver sets the observer of all entities created. Entity
+// can replace it with Entity.SetObserver.
+func (s *Framework) SetObserver(o Observer) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.observer = o
+}
+
+func (e *entity) SetObserver(o Observer) {
+	e.observer = o
+	e.ctx.setObserver(o)
+}
+
+// Notify observer. It's cheap if there is no observer.
+func (e *entity) notify(typ EventType, a *agent, result Result) {
+	if e.observer != nil {
+		e.notifyEvent(typ, a, result)
+	}
+}
+
+func (e *entity) notifyEvent(typ EventType, a *agent, result Result) {
+	ev := Event{
+		Type:       typ,
+		Entity:     e,
+		Result:     result,
+		UpdateSeri: e.ctx.UpdateSeri(),
+	}
+
+	if a != nil {
+		ev.Node = a.node
+	}
+
+	e.observer.OnEvent(&ev)
+}
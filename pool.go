@@ -2,26 +2,62 @@ package bevtree
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // A packaging of go built-in sync.Pool.
 type pool struct {
+	// The counters of getting, creating and putting object.
+	// They are the first fields for 64-bit alignment of atomic
+	// operations.
+	gets uint64
+	news uint64
+	puts uint64
+
 	p sync.Pool
 }
 
+// Whether to count the operations of pools, set once a metrics
+// registry set, accessed atomically.
+var poolCounting int32
+
+func enablePoolCounting() { atomic.StoreInt32(&poolCounting, 1) }
+
+// Increase the counter c if counting.
+func poolCount(c *uint64) {
+	if atomic.LoadInt32(&poolCounting) != 0 {
+		atomic.AddUint64(c, 1)
+	}
+}
+
 func newPool(new func() interface{}) *pool {
 	p := &pool{}
-	p.p.New = new
+	p.p.New = func() interface{} {
+		poolCount(&p.news)
+		return new()
+	}
 	return p
 }
 
+func (p *pool) metrics(name string) PoolMetrics {
+	return PoolMetrics{
+		Name: name,
+		Gets: atomic.LoadUint64(&p.gets),
+		News: atomic.LoadUint64(&p.news),
+		Puts: atomic.LoadUint64(&p.puts),
+	}
+}
+
 type taskPool struct {
 	pool
 }
 
 func newTaskPool(new func() Task) *taskPool {
 	p := &taskPool{}
-	p.p.New = func() interface{} { return new() }
+	p.p.New = func() interface{} {
+		poolCount(&p.news)
+		return new()
+	}
 	return p
 }
 
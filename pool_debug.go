@@ -29,10 +29,12 @@ var _poolDebug poolDebug
 
 func (p *pool) get() interface{} {
 	_poolDebug.get()
+	poolCount(&p.gets)
 	return p.p.Get()
 }
 
 func (p *pool) put(i interface{}) {
 	_poolDebug.put()
+	poolCount(&p.puts)
 	p.p.Put(i)
 }
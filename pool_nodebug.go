@@ -3,9 +3,11 @@
 package bevtree
 
 func (p *pool) get() interface{} {
+	poolCount(&p.gets)
 	return p.p.Get()
 }
 
 func (p *pool) put(i interface{}) {
+	poolCount(&p.puts)
 	p.p.Put(i)
 }
@@ -0,0 +1,100 @@
+package bevtree
+
+import (
+	gocontext "context"
+	"runtime/pprof"
+)
+
+// The pprof label keys.
+const (
+	ProfileLabelTree     = "bevtree.tree"
+	ProfileLabelNodeType = "bevtree.nodetype"
+	ProfileLabelBevType  = "bevtree.bevtype"
+)
+
+// SetProfileLabels sets whether to run the callbacks of tasks
+// with pprof labels carrying the tree name, the node type and
+// the behavior type, so CPU profiles attribute time to nodes.
+// The goroutine labels are restored after each callback, to the
+// labels of the subtree node running the subtree, or to the labels
+// of the context given by WithProfileContext.
+func (s *Framework) SetProfileLabels(enable bool) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.profile = enable
+}
+
+// WithProfileContext sets the context carrying the pprof labels of
+// the caller of Entity.Update, the labels of task callbacks are added
+// to them. By default, the caller has no labels.
+func WithProfileContext(ctx gocontext.Context) EntityOption {
+	return func(o *entityOptions) {
+		o.profileCtx = ctx
+	}
+}
+
+// The pprof labels of node.
+type profileLabels struct {
+	labels pprof.LabelSet
+
+	// The context with the labels only.
+	ctx gocontext.Context
+}
+
+// Get the pprof labels of node, create them if not yet.
+func (s *Framework) getProfileLabels(tree Tree, node Node) *profileLabels {
+	if v, ok := s.profileLabels.Load(node); ok {
+		return v.(*profileLabels)
+	}
+
+	labels := []string{ProfileLabelTree, tree.Name(), ProfileLabelNodeType, node.NodeType().String()}
+	if b, ok := node.(*BevNode); ok && b.bev != nil {
+		labels = append(labels, ProfileLabelBevType, b.bev.BevType().String())
+	}
+
+	l := &profileLabels{labels: pprof.Labels(labels...)}
+	l.ctx = pprof.WithLabels(gocontext.Background(), l.labels)
+	v, _ := s.profileLabels.LoadOrStore(node, l)
+	return v.(*profileLabels)
+}
+
+// Set the pprof labels of node to current goroutine, on top of the
+// labels of the caller.
+func (ctx *context) setProfileLabels(node Node) {
+	l := ctx._framework.getProfileLabels(ctx.tree, node)
+	if base := ctx.profileBase(); base != nil {
+		ctx.profileCur = pprof.WithLabels(base, l.labels)
+	} else {
+		ctx.profileCur = l.ctx
+	}
+
+	pprof.SetGoroutineLabels(ctx.profileCur)
+}
+
+// Restore the pprof labels of current goroutine to the labels of the
+// caller.
+func (ctx *context) clearProfileLabels() {
+	ctx.profileCur = nil
+
+	base := ctx.profileBase()
+	if base == nil {
+		base = gocontext.Background()
+	}
+
+	pprof.SetGoroutineLabels(base)
+}
+
+// Get the context carrying the labels of the caller, nil if none. The
+// caller of subtree is the callback of subtree node.
+func (ctx *context) profileBase() gocontext.Context {
+	if ctx.profileParent != nil {
+		if ctx.profileParent.profileCur != nil {
+			return ctx.profileParent.profileCur
+		}
+		return ctx.profileParent.profileBase()
+	}
+
+	return ctx.profileCtx
+}
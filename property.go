@@ -0,0 +1,288 @@
+package bevtree
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Property names of built-in nodes.
+const (
+	// property name for limited.
+	PropertyLimited = "limited"
+
+	// property name for success on fail.
+	PropertySuccessOnFail = "successonfail"
+
+	// property name for subtree.
+	PropertySubtree = "subtree"
+
+	// property name for the weight of child.
+	PropertyWeight = "weight"
+)
+
+// PropertyWriter writes the properties of a node or bev. How the
+// properties are encoded is decided by the codec driving it, the
+// XML, JSON or binary codec.
+//
+// The errors occurred on writing are returned by the codec after
+// MarshalBTProperties.
+type PropertyWriter interface {
+	WriteInt(name string, v int64)
+	WriteFloat(name string, v float64)
+	WriteBool(name string, v bool)
+	WriteString(name string, v string)
+
+	// WriteChild writes the only child node, nothing written if
+	// child is nil.
+	WriteChild(child Node)
+
+	// WriteChildren writes count child nodes. child returns the
+	// No.i child node, and writes the properties belong to the
+	// child, such as weight, to w. Only scalar properties can be
+	// written to w.
+	WriteChildren(count int, child func(i int, w PropertyWriter) Node)
+}
+
+// PropertyReader reads the properties written by PropertyWriter.
+// The properties can be read in any order.
+type PropertyReader interface {
+	Framework() *Framework
+
+	// Has reports whether the property name exists.
+	Has(name string) bool
+
+	ReadInt(name string) (int64, error)
+	ReadFloat(name string) (float64, error)
+	ReadBool(name string) (bool, error)
+	ReadString(name string) (string, error)
+
+	// Child returns the only child node, nil if not found.
+	Child() Node
+
+	// ChildCount returns the count of child nodes written by
+	// WriteChildren.
+	ChildCount() int
+
+	// ChildAt returns the No.i child node, and the reader of the
+	// properties belong to it.
+	ChildAt(i int) (Node, PropertyReader)
+}
+
+// PropertyMarshaler is the interface implemented by objects that
+// describe their parameters as properties. Such objects can be
+// encoded in every bevtree format without a format specific
+// marshaler, which takes precedence if implemented.
+type PropertyMarshaler interface {
+	MarshalBTProperties(PropertyWriter) error
+}
+
+// PropertyUnmarshaler is the interface implemented by objects that
+// can unmarshal the properties written by MarshalBTProperties.
+type PropertyUnmarshaler interface {
+	UnmarshalBTProperties(PropertyReader) error
+}
+
+// propertyList collects the scalar properties of a child node.
+type propertyList struct {
+	names  []string
+	values []interface{}
+	err    error
+}
+
+func (l *propertyList) add(name string, v interface{}) {
+	l.names = append(l.names, name)
+	l.values = append(l.values, v)
+}
+
+func (l *propertyList) WriteInt(name string, v int64)     { l.add(name, v) }
+func (l *propertyList) WriteFloat(name string, v float64) { l.add(name, v) }
+func (l *propertyList) WriteBool(name string, v bool)     { l.add(name, v) }
+func (l *propertyList) WriteString(name string, v string) { l.add(name, v) }
+
+func (l *propertyList) WriteChild(Node) {
+	if l.err == nil {
+		l.err = errors.New("write child in properties of child")
+	}
+}
+
+func (l *propertyList) WriteChildren(int, func(int, PropertyWriter) Node) {
+	if l.err == nil {
+		l.err = errors.New("write children in properties of child")
+	}
+}
+
+// Format the scalar property value v as string.
+func formatProperty(v interface{}) string {
+	switch o := v.(type) {
+	case int64:
+		return strconv.FormatInt(o, 10)
+	case float64:
+		return formatFloat(o)
+	case bool:
+		return strconv.FormatBool(o)
+	default:
+		return o.(string)
+	}
+}
+
+// Format v in the shortest way, as float32 if v is converted from
+// float32 without loss.
+func formatFloat(v float64) string {
+	if float64(float32(v)) == v {
+		return strconv.FormatFloat(v, 'g', -1, 32)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// The JSON value of float v, see formatFloat.
+func jsonFloat(v float64) interface{} {
+	if float64(float32(v)) == v {
+		return float32(v)
+	}
+	return v
+}
+
+// propertyReader holds the properties read by codecs. The values
+// are string from XML, json.RawMessage from JSON, or the written
+// type from binary.
+type propertyReader struct {
+	framework  *Framework
+	values     map[string]interface{}
+	child      Node
+	children   []Node
+	childProps []*propertyReader
+}
+
+func newPropertyReader(framework *Framework) *propertyReader {
+	return &propertyReader{framework: framework, values: map[string]interface{}{}}
+}
+
+func (r *propertyReader) addChild(child Node, props *propertyReader) {
+	r.children = append(r.children, child)
+	r.childProps = append(r.childProps, props)
+}
+
+func (r *propertyReader) Framework() *Framework { return r.framework }
+
+func (r *propertyReader) Has(name string) bool {
+	_, ok := r.values[name]
+	return ok
+}
+
+func (r *propertyReader) value(name string) (interface{}, error) {
+	if v, ok := r.values[name]; ok {
+		return v, nil
+	}
+	return nil, errors.Errorf("property \"%s\" not found", name)
+}
+
+func (r *propertyReader) ReadInt(name string) (int64, error) {
+	v, err := r.value(name)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	switch o := v.(type) {
+	case int64:
+		return o, nil
+	case string:
+		n, err = strconv.ParseInt(strings.TrimSpace(o), 10, 64)
+	case json.RawMessage:
+		err = json.Unmarshal(o, &n)
+	default:
+		err = errors.Errorf("%T is not int", v)
+	}
+
+	if err != nil {
+		return 0, errors.WithMessagef(err, "read property \"%s\"", name)
+	}
+
+	return n, nil
+}
+
+func (r *propertyReader) ReadFloat(name string) (float64, error) {
+	v, err := r.value(name)
+	if err != nil {
+		return 0, err
+	}
+
+	var f float64
+	switch o := v.(type) {
+	case float64:
+		return o, nil
+	case int64:
+		return float64(o), nil
+	case string:
+		f, err = strconv.ParseFloat(strings.TrimSpace(o), 64)
+	case json.RawMessage:
+		err = json.Unmarshal(o, &f)
+	default:
+		err = errors.Errorf("%T is not float", v)
+	}
+
+	if err != nil {
+		return 0, errors.WithMessagef(err, "read property \"%s\"", name)
+	}
+
+	return f, nil
+}
+
+func (r *propertyReader) ReadBool(name string) (bool, error) {
+	v, err := r.value(name)
+	if err != nil {
+		return false, err
+	}
+
+	var b bool
+	switch o := v.(type) {
+	case bool:
+		return o, nil
+	case string:
+		b, err = strconv.ParseBool(strings.TrimSpace(o))
+	case json.RawMessage:
+		err = json.Unmarshal(o, &b)
+	default:
+		err = errors.Errorf("%T is not bool", v)
+	}
+
+	if err != nil {
+		return false, errors.WithMessagef(err, "read property \"%s\"", name)
+	}
+
+	return b, nil
+}
+
+func (r *propertyReader) ReadString(name string) (string, error) {
+	v, err := r.value(name)
+	if err != nil {
+		return "", err
+	}
+
+	var s string
+	switch o := v.(type) {
+	case string:
+		return o, nil
+	case json.RawMessage:
+		err = json.Unmarshal(o, &s)
+	default:
+		err = errors.Errorf("%T is not string", v)
+	}
+
+	if err != nil {
+		return "", errors.WithMessagef(err, "read property \"%s\"", name)
+	}
+
+	return s, nil
+}
+
+func (r *propertyReader) Child() Node { return r.child }
+
+func (r *propertyReader) ChildCount() int { return len(r.children) }
+
+func (r *propertyReader) ChildAt(i int) (Node, PropertyReader) {
+	return r.children[i], r.childProps[i]
+}
@@ -0,0 +1,184 @@
+package bevtree
+
+import (
+	"strings"
+	"testing"
+)
+
+const propIncr = BevType("propIncr")
+
+// The bev describes itself as properties only.
+type bevPropIncr struct {
+	bevBBIncr
+}
+
+func (bevPropIncr) BevType() BevType { return propIncr }
+
+func (b *bevPropIncr) MarshalBTProperties(w PropertyWriter) error {
+	w.WriteString("key", b.Key)
+	w.WriteInt("limited", int64(b.Limited))
+	return nil
+}
+
+func (b *bevPropIncr) UnmarshalBTProperties(r PropertyReader) error {
+	key, err := r.ReadString("key")
+	if err != nil {
+		return err
+	}
+
+	limited, err := r.ReadInt("limited")
+	if err != nil {
+		return err
+	}
+
+	b.Key, b.Limited = key, int(limited)
+	return nil
+}
+
+func TestTreeMarshalProperties(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(propIncr, func() Bev { return new(bevPropIncr) })
+
+	key := "key"
+	oldTree := buildCodecTestTree(framework, "属性测试", "properties subtree", key)
+	oldTree.Root().Child().(*ParallelNode).AddChild(NewBevNode(&bevPropIncr{bevBBIncr{Key: key, Limited: 2}}))
+	sum := runCodecTestTree(t, framework, "属性测试", key, 3)
+
+	formats := []struct {
+		name      string
+		marshal   func(*tree) ([]byte, error)
+		unmarshal func([]byte, *tree) error
+	}{
+		{"xml", framework.MarshalXMLTree, framework.UnmarshalXMLTree},
+		{"json", framework.MarshalJSONTree, framework.UnmarshalJSONTree},
+		{"binary", framework.MarshalBinaryTree, framework.UnmarshalBinaryTree},
+	}
+
+	for _, f := range formats {
+		data, err := f.marshal(oldTree)
+		if err != nil {
+			t.Fatalf("%s marshal Tree: %v", f.name, err)
+		}
+
+		newTree := new(tree)
+		if err := f.unmarshal(data, newTree); err != nil {
+			t.Fatalf("%s unmarshal Tree: %v", f.name, err)
+		}
+
+		if again, err := f.marshal(newTree); err != nil {
+			t.Fatalf("%s marshal unmarshaled Tree: %v", f.name, err)
+		} else if string(again) != string(data) {
+			t.Fatalf("%s marshal unmarshaled Tree: data changed", f.name)
+		}
+
+		newTree.SetName("属性测试-" + f.name)
+		framework.addTree(newTree)
+
+		if v := runCodecTestTree(t, framework, newTree.Name(), key, 3); v != sum {
+			t.Fatalf("%s test Tree after unmarshal: sum(%d) != %d", f.name, v, sum)
+		}
+	}
+}
+
+func TestUnmarshalXMLProperties(t *testing.T) {
+	framework := newTestFramework()
+	sub := NewTree("sub")
+	framework.addTree(sub)
+
+	// The properties can be attributes too.
+	data := `<bevtree name="attrs">
+    <root id="1">
+        <child nodetype="parallel">
+            <childs count="2">
+                <child nodetype="subtree" subtree="sub"></child>
+                <child nodetype="repeater" limited="3"></child>
+            </childs>
+        </child>
+    </root>
+</bevtree>`
+
+	newTree := new(tree)
+	if err := framework.UnmarshalXMLTree([]byte(data), newTree); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTree.Root().Child().(*ParallelNode)
+	if s := p.Child(0).(*SubtreeNode); s.Subtree() != sub {
+		t.Fatal("subtree not unmarshaled")
+	}
+
+	if r := p.Child(1).(*RepeaterNode); r.Limited() != 3 {
+		t.Fatalf("limited %d != 3", r.Limited())
+	}
+
+	for _, bad := range []string{
+		`<bevtree name="bad"><root><child nodetype="repeater"></child></root></bevtree>`,
+		`<bevtree name="bad"><root><child nodetype="repeater"><limited>x</limited></child></root></bevtree>`,
+		`<bevtree name="bad"><root><child nodetype="sequence"><childs count="2"><child nodetype="sequence"></child></childs></child></root></bevtree>`,
+		`<bevtree name="bad"><root><child nodetype="weightselector"><childs><child nodetype="sequence"></child></childs></child></root></bevtree>`,
+	} {
+		if err := framework.UnmarshalXMLTree([]byte(bad), new(tree)); err == nil {
+			t.Fatalf("unmarshal %s should fail", bad)
+		}
+	}
+}
+
+func TestInvalidProperties(t *testing.T) {
+	framework := newTestFramework()
+
+	validTree := NewTree("valid")
+	paral := NewParallelNode()
+	validTree.Root().SetChild(paral)
+	weightSel := NewWeightSelectorNode()
+	weightSel.AddChild(NewSucceederNode(), 0.25)
+	weightSel.AddChild(NewSucceederNode(), 0.75)
+	paral.AddChild(weightSel)
+	paral.AddChild(NewRepeaterNode(3))
+
+	formats := []struct {
+		name      string
+		marshal   func(*tree) ([]byte, error)
+		unmarshal func([]byte, *tree) error
+
+		// The replacements of valid properties to invalid ones.
+		invalids [][2]string
+	}{
+		{"xml", framework.MarshalXMLTree, framework.UnmarshalXMLTree, [][2]string{
+			{`weight="0.25"`, `weight="0"`},
+			{`weight="0.25"`, `weight="-0.25"`},
+			{`weight="0.25"`, `weight="0.5"`},
+			{`<limited>3</limited>`, `<limited>0</limited>`},
+		}},
+		{"json", framework.MarshalJSONTree, framework.UnmarshalJSONTree, [][2]string{
+			{`"weight": 0.25`, `"weight": 0`},
+			{`"weight": 0.25`, `"weight": -0.25`},
+			{`"weight": 0.25`, `"weight": 0.5`},
+			{`"limited": 3`, `"limited": 0`},
+		}},
+	}
+
+	for _, f := range formats {
+		data, err := f.marshal(validTree)
+		if err != nil {
+			t.Fatalf("%s marshal Tree: %v", f.name, err)
+		}
+
+		for _, r := range f.invalids {
+			if !strings.Contains(string(data), r[0]) {
+				t.Fatalf("%s: %s not found in:\n%s", f.name, r[0], data)
+			}
+
+			invalid := strings.Replace(string(data), r[0], r[1], 1)
+			if err := f.unmarshal([]byte(invalid), new(tree)); err == nil {
+				t.Fatalf("%s: unmarshal %s should fail", f.name, r[1])
+			}
+		}
+
+		// The subtree is required.
+		invalidTree := NewTree("invalid")
+		invalidTree.Root().SetChild(&SubtreeNode{})
+		if _, err := f.marshal(invalidTree); err == nil || !strings.Contains(err.Error(), "subtree nil") {
+			t.Fatalf("%s: marshal subtree nil should fail, %v", f.name, err)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package bevtree
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The struct tag key of properties. The fields of Bev or custom node
+// tagged with it are encoded as properties, if the struct does not
+// implement PropertyMarshaler or the marshaler of the format. The
+// tag is the property name, followed by options separated by comma:
+//
+//	required       the property must exist on unmarshaling.
+//	default=value  the value if the property does not exist.
+//	min=value      the minimum value of number or duration.
+//	max=value      the maximum value of number or duration.
+//	enum=a|b|c     the values allowed.
+//
+// The field name in lower case is used if the name is empty, and
+// the field is skipped if the tag is "-". The fields of embedded
+// struct without tag are included. Supported field types are bool,
+// string, integers, floats and time.Duration, which is encoded as
+// string like "1.5s".
+//
+// For example:
+//
+//	type Wait struct {
+//	    Duration time.Duration `bevtree:"duration,required,min=0s"`
+//	    Mode     string        `bevtree:"mode,enum=once|loop,default=once"`
+//	}
+const PropertyTagKey = "bevtree"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// A field tagged with PropertyTagKey.
+type tagField struct {
+	index      []int
+	name       string
+	typ        reflect.Type
+	required   bool
+	def        reflect.Value
+	min, max   *float64
+	minS, maxS string
+	enum       []string
+}
+
+// The tagged fields of a struct type.
+type tagStruct struct {
+	fields []*tagField
+}
+
+// The tagStruct cache by struct type, nil if not tagged.
+var tagStructs sync.Map
+
+func tagStructOf(t reflect.Type) (*tagStruct, error) {
+	if cached, ok := tagStructs.Load(t); ok {
+		if err, ok := cached.(error); ok {
+			return nil, err
+		}
+		return cached.(*tagStruct), nil
+	}
+
+	s := &tagStruct{}
+	if err := s.parse(t, nil, map[string]bool{}); err != nil {
+		tagStructs.Store(t, err)
+		return nil, err
+	}
+
+	if len(s.fields) == 0 {
+		s = nil
+	}
+
+	tagStructs.Store(t, s)
+	return s, nil
+}
+
+func (s *tagStruct) parse(t reflect.Type, index []int, names map[string]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		tag, ok := sf.Tag.Lookup(PropertyTagKey)
+		if !ok {
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				if err := s.parse(sf.Type, fieldIndex, names); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		f, err := parseTagField(sf, tag)
+		if err != nil {
+			return errors.WithMessagef(err, "%s tag of %s.%s", PropertyTagKey, t, sf.Name)
+		}
+
+		if names[f.name] {
+			return errors.Errorf("%s tag of %s.%s: property \"%s\" duplicated", PropertyTagKey, t, sf.Name, f.name)
+		}
+
+		names[f.name] = true
+		f.index = fieldIndex
+		s.fields = append(s.fields, f)
+	}
+
+	return nil
+}
+
+func parseTagField(sf reflect.StructField, tag string) (*tagField, error) {
+	if sf.PkgPath != "" {
+		return nil, errors.New("field unexported")
+	}
+
+	if !tagTypeSupported(sf.Type) {
+		return nil, errors.Errorf("type %s not supported", sf.Type)
+	}
+
+	options := strings.Split(tag, ",")
+	f := &tagField{name: options[0], typ: sf.Type}
+	if f.name == "" {
+		f.name = strings.ToLower(sf.Name)
+	}
+
+	for _, option := range options[1:] {
+		key, value := option, ""
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+
+		if err := f.setOption(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Set the option key with value.
+func (f *tagField) setOption(key, value string) error {
+	var err error
+	switch key {
+	case "required":
+		f.required = true
+
+	case "default":
+		f.def, err = parseTagValue(f.typ, value)
+
+	case "min":
+		f.min, err = f.parseBound(value)
+		f.minS = value
+
+	case "max":
+		f.max, err = f.parseBound(value)
+		f.maxS = value
+
+	case "enum":
+		f.enum = strings.Split(value, "|")
+
+	default:
+		err = errors.Errorf("unknown option \"%s\"", key)
+	}
+
+	if err != nil {
+		return errors.WithMessagef(err, "option %s", key)
+	}
+
+	return nil
+}
+
+// Check the options set.
+func (f *tagField) check() error {
+	if f.required && f.def.IsValid() {
+		return errors.New("required with default")
+	}
+
+	if f.def.IsValid() {
+		if err := f.validate(f.def); err != nil {
+			return errors.WithMessage(err, "option default")
+		}
+	}
+
+	return nil
+}
+
+func tagTypeSupported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// Parse s as the value of type t.
+func parseTagValue(t reflect.Type, s string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	var err error
+	switch {
+	case t == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			v.SetInt(int64(d))
+		}
+
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(s); err == nil {
+				v.SetBool(b)
+			}
+
+		case reflect.String:
+			v.SetString(s)
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			if n, err = strconv.ParseInt(s, 10, t.Bits()); err == nil {
+				v.SetInt(n)
+			}
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n uint64
+			if n, err = strconv.ParseUint(s, 10, t.Bits()); err == nil {
+				v.SetUint(n)
+			}
+
+		case reflect.Float32, reflect.Float64:
+			var n float64
+			if n, err = strconv.ParseFloat(s, t.Bits()); err == nil {
+				v.SetFloat(n)
+			}
+		}
+	}
+
+	if err != nil {
+		return reflect.Value{}, errors.Errorf("invalid %s \"%s\"", t, s)
+	}
+
+	return v, nil
+}
+
+func (f *tagField) parseBound(s string) (*float64, error) {
+	if f.typ.Kind() == reflect.Bool || f.typ.Kind() == reflect.String {
+		return nil, errors.Errorf("%s has no bound", f.typ)
+	}
+
+	v, err := parseTagValue(f.typ, s)
+	if err != nil {
+		return nil, err
+	}
+
+	bound := tagNumber(v)
+	return &bound, nil
+}
+
+// The number of v to compare with bounds.
+func tagNumber(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+// Format v as string, as it's in tag.
+func formatTagValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	default:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	}
+}
+
+// Check v by the bounds and enum.
+func (f *tagField) validate(v reflect.Value) error {
+	if f.min != nil && tagNumber(v) < *f.min {
+		return errors.Errorf("property \"%s\": %s less than min %s", f.name, formatTagValue(v), f.minS)
+	}
+
+	if f.max != nil && tagNumber(v) > *f.max {
+		return errors.Errorf("property \"%s\": %s greater than max %s", f.name, formatTagValue(v), f.maxS)
+	}
+
+	if len(f.enum) > 0 {
+		s := formatTagValue(v)
+		for _, e := range f.enum {
+			if s == e {
+				return nil
+			}
+		}
+
+		return errors.Errorf("property \"%s\": \"%s\" not in %v", f.name, s, f.enum)
+	}
+
+	return nil
+}
+
+func (f *tagField) write(w PropertyWriter, v reflect.Value) error {
+	if err := f.validate(v); err != nil {
+		return err
+	}
+
+	if f.typ == durationType {
+		w.WriteString(f.name, time.Duration(v.Int()).String())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		w.WriteBool(f.name, v.Bool())
+
+	case reflect.String:
+		w.WriteString(f.name, v.String())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.WriteInt(f.name, v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := v.Uint()
+		if n > 1<<63-1 {
+			return errors.Errorf("property \"%s\": %d overflows", f.name, n)
+		}
+		w.WriteInt(f.name, int64(n))
+
+	default:
+		w.WriteFloat(f.name, v.Float())
+	}
+
+	return nil
+}
+
+func (f *tagField) read(r PropertyReader, v reflect.Value) error {
+	if !r.Has(f.name) {
+		if f.required {
+			return errors.Errorf("property \"%s\" required", f.name)
+		}
+
+		if f.def.IsValid() {
+			v.Set(f.def)
+		}
+
+		return nil
+	}
+
+	overflow := false
+	if f.typ == durationType {
+		s, err := r.ReadString(f.name)
+		if err != nil {
+			return err
+		}
+
+		d, err := parseTagValue(f.typ, s)
+		if err != nil {
+			return errors.WithMessagef(err, "property \"%s\"", f.name)
+		}
+
+		v.Set(d)
+	} else {
+		switch v.Kind() {
+		case reflect.Bool:
+			b, err := r.ReadBool(f.name)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+
+		case reflect.String:
+			s, err := r.ReadString(f.name)
+			if err != nil {
+				return err
+			}
+			v.SetString(s)
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := r.ReadInt(f.name)
+			if err != nil {
+				return err
+			}
+			if overflow = v.OverflowInt(n); !overflow {
+				v.SetInt(n)
+			}
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := r.ReadInt(f.name)
+			if err != nil {
+				return err
+			}
+			if overflow = n < 0 || v.OverflowUint(uint64(n)); !overflow {
+				v.SetUint(uint64(n))
+			}
+
+		default:
+			n, err := r.ReadFloat(f.name)
+			if err != nil {
+				return err
+			}
+			if overflow = v.OverflowFloat(n); !overflow {
+				v.SetFloat(n)
+			}
+		}
+	}
+
+	if overflow {
+		return errors.Errorf("property \"%s\": overflows %s", f.name, f.typ)
+	}
+
+	return f.validate(v)
+}
+
+// The properties of struct v described by tags.
+type tagProperties struct {
+	s *tagStruct
+	v reflect.Value
+}
+
+func (p tagProperties) MarshalBTProperties(w PropertyWriter) error {
+	for _, f := range p.s.fields {
+		if err := f.write(w, p.v.FieldByIndex(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p tagProperties) UnmarshalBTProperties(r PropertyReader) error {
+	for _, f := range p.s.fields {
+		if err := f.read(r, p.v.FieldByIndex(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns v if it implements PropertyMarshaler, or the tagged
+// properties of v, or nil.
+func propertyMarshalerOf(v interface{}) (PropertyMarshaler, error) {
+	if marshaler, ok := v.(PropertyMarshaler); ok {
+		return marshaler, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	s, err := tagStructOf(rv.Type())
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	return tagProperties{s: s, v: rv}, nil
+}
+
+// Returns v if it implements PropertyUnmarshaler, or the tagged
+// properties of v, or nil.
+func propertyUnmarshalerOf(v interface{}) (PropertyUnmarshaler, error) {
+	if unmarshaler, ok := v.(PropertyUnmarshaler); ok {
+		return unmarshaler, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	s, err := tagStructOf(rv.Elem().Type())
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	return tagProperties{s: s, v: rv.Elem()}, nil
+}
@@ -0,0 +1,155 @@
+package bevtree
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const tagIncr = BevType("tagIncr")
+
+// The bev declared by tags only.
+type bevTagIncr struct {
+	Key     string        `bevtree:"key,required"`
+	Limited int           `bevtree:"limited,min=1,max=10,default=1"`
+	Mode    string        `bevtree:",enum=add|sub,default=add"`
+	Delay   time.Duration `bevtree:"delay,min=0s"`
+	Ignored int           `bevtree:"-"`
+}
+
+func (bevTagIncr) BevType() BevType { return tagIncr }
+
+func (b *bevTagIncr) CreateInstance() BevInstance {
+	return &bevBBIncrEntity{bevBBIncr: newBevBBIncr(b.Key, b.Limited)}
+}
+
+func (b *bevTagIncr) DestroyInstance(BevInstance) {}
+
+func TestTreeMarshalTags(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	key := "key"
+	oldTree := buildCodecTestTree(framework, "标签测试", "tags subtree", key)
+	oldTree.Root().Child().(*ParallelNode).AddChild(NewBevNode(&bevTagIncr{Key: key, Limited: 2, Mode: "sub", Delay: time.Second}))
+	sum := runCodecTestTree(t, framework, "标签测试", key, 3)
+
+	formats := []struct {
+		name      string
+		marshal   func(*tree) ([]byte, error)
+		unmarshal func([]byte, *tree) error
+	}{
+		{"xml", framework.MarshalXMLTree, framework.UnmarshalXMLTree},
+		{"json", framework.MarshalJSONTree, framework.UnmarshalJSONTree},
+		{"binary", framework.MarshalBinaryTree, framework.UnmarshalBinaryTree},
+	}
+
+	for _, f := range formats {
+		data, err := f.marshal(oldTree)
+		if err != nil {
+			t.Fatalf("%s marshal Tree: %v", f.name, err)
+		}
+
+		newTree := new(tree)
+		if err := f.unmarshal(data, newTree); err != nil {
+			t.Fatalf("%s unmarshal Tree: %v", f.name, err)
+		}
+
+		if again, err := f.marshal(newTree); err != nil {
+			t.Fatalf("%s marshal unmarshaled Tree: %v", f.name, err)
+		} else if string(again) != string(data) {
+			t.Fatalf("%s marshal unmarshaled Tree: data changed", f.name)
+		}
+
+		newTree.SetName("标签测试-" + f.name)
+		framework.addTree(newTree)
+
+		if v := runCodecTestTree(t, framework, newTree.Name(), key, 3); v != sum {
+			t.Fatalf("%s test Tree after unmarshal: sum(%d) != %d", f.name, v, sum)
+		}
+	}
+
+	// Invalid value is not marshaled.
+	invalidTree := NewTree("invalid")
+	invalidTree.Root().SetChild(NewBevNode(&bevTagIncr{Key: key, Limited: 0}))
+	if _, err := framework.MarshalJSONTree(invalidTree); err == nil || !strings.Contains(err.Error(), "less than min 1") {
+		t.Fatalf("marshal invalid bev: %v", err)
+	}
+}
+
+func TestUnmarshalTags(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	unmarshal := func(bev string) (*bevTagIncr, error) {
+		data := `<bevtree name="tags"><root><child nodetype="behavior" bevtype="tagIncr">` + bev + `</child></root></bevtree>`
+		newTree := new(tree)
+		if err := framework.UnmarshalXMLTree([]byte(data), newTree); err != nil {
+			return nil, err
+		}
+		return newTree.Root().Child().(*BevNode).Bev().(*bevTagIncr), nil
+	}
+
+	b, err := unmarshal(`<key>k</key><ignored>3</ignored>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *b != (bevTagIncr{Key: "k", Limited: 1, Mode: "add"}) {
+		t.Fatalf("defaults not set: %+v", *b)
+	}
+
+	for bev, msg := range map[string]string{
+		``:                                     `property "key" required`,
+		`<key>k</key><limited>0</limited>`:     `property "limited": 0 less than min 1`,
+		`<key>k</key><limited>11</limited>`:    `property "limited": 11 greater than max 10`,
+		`<key>k</key><limited>x</limited>`:     `read property "limited"`,
+		`<key>k</key><mode>mul</mode>`:         `property "mode": "mul" not in [add sub]`,
+		`<key>k</key><delay>-1s</delay>`:       `property "delay": -1s less than min 0s`,
+		`<key>k</key><delay>forever</delay>`:   `invalid time.Duration "forever"`,
+		`<key>k</key><limited>1e100</limited>`: `read property "limited"`,
+		`<key>k</key><limited>-1</limited>`:    `less than min 1`,
+	} {
+		if _, err := unmarshal(bev); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("unmarshal %s: error %v, want %s", bev, err, msg)
+		}
+	}
+}
+
+func TestTagDefinitions(t *testing.T) {
+	for _, v := range []interface{}{
+		&struct {
+			A int `bevtree:"a,unknown"`
+		}{},
+		&struct {
+			A []int `bevtree:"a"`
+		}{},
+		&struct {
+			a int `bevtree:"a"`
+		}{},
+		&struct {
+			A int `bevtree:"a,required,default=1"`
+		}{},
+		&struct {
+			A int `bevtree:"a,default=x"`
+		}{},
+		&struct {
+			A int `bevtree:"a,min=2,default=1"`
+		}{},
+		&struct {
+			A string `bevtree:"a,min=1"`
+		}{},
+		&struct {
+			A int `bevtree:"a"`
+			B int `bevtree:"a"`
+		}{},
+	} {
+		if _, err := propertyMarshalerOf(v); err == nil {
+			t.Fatalf("tags of %T should be invalid", v)
+		}
+	}
+
+	if m, err := propertyMarshalerOf(&struct{ A int }{}); err != nil || m != nil {
+		t.Fatalf("struct without tags: %v %v", m, err)
+	}
+}
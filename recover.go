@@ -0,0 +1,169 @@
+package bevtree
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// PanicError is sent to the error handler of Framework when
+// a panic raised by task was recovered in recovery mode.
+type PanicError struct {
+	// The name of the tree running.
+	Tree string
+
+	// The ID of the node whose task panicked.
+	NodeID NodeID
+
+	// The path of the node whose task panicked.
+	NodePath string
+
+	// The value passed to panic.
+	Value interface{}
+
+	// The stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("bevtree: tree \"%s\" node %s(%s) panic: %v\n%s", e.Tree, e.NodePath, e.NodeID, e.Value, e.Stack)
+}
+
+// The max size of stack trace in PanicError.
+const maxPanicStackSize = 8 << 10
+
+func newPanicError(entity *entity, a *agent, v interface{}) *PanicError {
+	stack := make([]byte, maxPanicStackSize)
+	stack = stack[:runtime.Stack(stack, false)]
+
+	return &PanicError{
+		Tree:     entity.Context().Tree().Name(),
+		NodeID:   entity.ctx.Tree().NodeID(a.node),
+		NodePath: NodePath(a.node),
+		Value:    v,
+		Stack:    stack,
+	}
+}
+
+// SetRecover sets whether to recover the panics raised by tasks
+// while running entities. In recovery mode, the panicking node
+// fails, its running children are stopped, and a *PanicError is
+// sent to the error handler.
+func (s *Framework) SetRecover(recover bool) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.recover = recover
+}
+
+// SetErrorHandler sets the handler to receive errors raised while
+// running entities. The errors are logged if no handler set.
+func (s *Framework) SetErrorHandler(handler func(error)) {
+	if s.initialized {
+		panic("bevtree framework initialized")
+	}
+
+	s.errorHandler = handler
+}
+
+func (s *Framework) handleError(err error) {
+	if s.errorHandler != nil {
+		s.errorHandler(err)
+	} else {
+		s.log(LogError, "entity error", LogField{Key: LogKeyError, Value: err})
+	}
+}
+
+// Update agent, recover the panic in recovery mode.
+func (e *entity) updateAgent(a *agent) (result Result) {
+	if !e.ctx.framework().recover {
+		return a.update(e)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			result = e.failAgent(a, v)
+		}
+	}()
+
+	return a.update(e)
+}
+
+// Notify parent that child terminated, recover the panic in
+// recovery mode.
+func (e *entity) onChildTerminated(parent, child *agent, r Result) (result Result) {
+	if !e.ctx.framework().recover {
+		return parent.onChildTerminated(child, r, e)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			result = e.failAgent(parent, v)
+		}
+	}()
+
+	return parent.onChildTerminated(child, r, e)
+}
+
+// Stop agent, recover the panic in recovery mode.
+func (e *entity) stopAgent(a *agent, reason TerminateReason) {
+	if !e.ctx.framework().recover {
+		a.stop(e, reason)
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			if e.profile {
+				e.ctx.clearProfileLabels()
+			}
+
+			e.ctx.framework().handleError(newPanicError(e, a, v))
+			a.setStatus(AgentStopped)
+			a.setLZStop(LazyStopNone)
+		}
+	}()
+
+	a.stop(e, reason)
+}
+
+// Make the panicking agent fail. The running children are
+// lazy-stopped, and the task is notified to terminate if it
+// initialized and has not terminated.
+func (e *entity) failAgent(a *agent, v interface{}) Result {
+	if e.profile {
+		e.ctx.clearProfileLabels()
+	}
+
+	e.ctx.framework().handleError(newPanicError(e, a, v))
+
+	// Discard the child nodes pushed before panic.
+	e.childNodeList.clear()
+
+	a.lazyStopChildren(e)
+
+	// A lazy-stopping agent has been detached from parent,
+	// it is stopped as usual.
+	st, reason := AgentTerminated, TerminateCompleted
+	if a.getLZStop() != LazyStopNone {
+		st, reason = AgentStopped, TerminateAborted
+	}
+
+	// Not to terminate the task panicked in OnInit.
+	if cur := a.getStatus(); (cur == AgentNone && a.initialized) || cur == AgentRunning {
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					e.ctx.framework().handleError(newPanicError(e, a, v))
+				}
+			}()
+
+			a.terminate(e.ctx, st, reason, Failure)
+		}()
+	}
+
+	a.setStatus(st)
+	a.setLZStop(LazyStopNone)
+
+	return Failure
+}
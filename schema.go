@@ -0,0 +1,633 @@
+package bevtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// The properties and children of a node or bev type, for schema.
+type typeSchema struct {
+	// The properties, described as tagged fields.
+	props []*tagField
+
+	// Whether it has a child.
+	child bool
+
+	// Whether it has children.
+	children bool
+
+	// The properties of each child.
+	childProps []*tagField
+
+	// The properties are unknown, any content is allowed.
+	open bool
+}
+
+// Get the schema of v by the tags. The schema is open if v has its
+// own codec.
+func tagSchemaOf(v interface{}) *typeSchema {
+	switch v.(type) {
+	case PropertyMarshaler, XMLMarshaler, JSONMarshaler, BinaryMarshaler:
+		return &typeSchema{open: true}
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return &typeSchema{open: true}
+	}
+
+	s, err := tagStructOf(rv.Type())
+	if err != nil || s == nil {
+		return &typeSchema{open: true}
+	}
+
+	return &typeSchema{props: s.fields}
+}
+
+// Get the schema of node type, by the descriptor if registered with
+// one, or by the tags.
+func (s *Framework) nodeSchema(nodeType NodeType) *typeSchema {
+	meta := s.getNodeMeta(nodeType)
+	if meta.schema != nil {
+		return meta.schema
+	}
+
+	return tagSchemaOf(meta.createNode())
+}
+
+// Get the schema of bev type, by the descriptor if registered with
+// one, or by the tags.
+func (s *Framework) bevSchema(bevType BevType) *typeSchema {
+	meta := s.getBevMeta(bevType)
+	if meta.schema != nil {
+		return meta.schema
+	}
+
+	return tagSchemaOf(meta.createBev())
+}
+
+// The registered node types in order, except root and behavior.
+func (s *Framework) schemaNodeTypes() []NodeType {
+	nodeTypes := make([]NodeType, 0, len(s.nodeMetas))
+	for nodeType := range s.nodeMetas {
+		if nodeType != root && nodeType != behavior {
+			nodeTypes = append(nodeTypes, nodeType)
+		}
+	}
+
+	sort.Slice(nodeTypes, func(i, j int) bool { return nodeTypes[i] < nodeTypes[j] })
+	return nodeTypes
+}
+
+// The registered bev types in order.
+func (s *Framework) schemaBevTypes() []BevType {
+	bevTypes := make([]BevType, 0, len(s.bevMetas))
+	for bevType := range s.bevMetas {
+		bevTypes = append(bevTypes, bevType)
+	}
+
+	sort.Slice(bevTypes, func(i, j int) bool { return bevTypes[i] < bevTypes[j] })
+	return bevTypes
+}
+
+// SchemaOption configures ExportSchema.
+type SchemaOption func(*schemaOptions)
+
+type schemaOptions struct {
+	xsd10 bool
+}
+
+// WithXMLSchema10 exports the schema of XML format in XML Schema 1.0,
+// for the validators without 1.1 support, e.g. libxml2. The children
+// are checked by the generic node type only, the types of nodes and
+// bevs are still defined for xsi:type.
+func WithXMLSchema10() SchemaOption {
+	return func(o *schemaOptions) { o.xsd10 = true }
+}
+
+// ExportSchema writes the schema of tree documents in format to w,
+// which describes the registered node types and bev types, with the
+// properties of built-in nodes and the tagged fields, see
+// PropertyTagKey. The types with their own codecs allow any content.
+//
+// The schema of XML format is XML Schema 1.1, the node types are
+// assigned by the nodetype and bevtype attributes. Because properties
+// can be attributes or elements, the required properties are not
+// checked in it. See WithXMLSchema10 for 1.0 validators. The schema
+// of JSON format is JSON Schema 2020-12.
+func (s *Framework) ExportSchema(w io.Writer, format TreeFormat, opts ...SchemaOption) error {
+	var o schemaOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var data []byte
+	var err error
+
+	switch format {
+	case TreeFormatXML:
+		data, err = s.marshalXMLSchema(o.xsd10)
+	case TreeFormatJSON:
+		if data, err = json.MarshalIndent(s.jsonSchema(), "", indent); err == nil {
+			data = append(data, '\n')
+		}
+	default:
+		err = errors.Errorf("schema of format \"%s\" not supported", format)
+	}
+
+	if err != nil {
+		return errors.WithMessage(err, "bevtree framework ExportSchema")
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+const (
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema"
+	vcNamespace  = "http://www.w3.org/2007/XMLSchema-versioning"
+)
+
+// Create the element with attributes in name and value pairs.
+func xsdElement(name string, attrs ...string) *XMLElement {
+	e := &XMLElement{Name: name}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		e.Attr = append(e.Attr, xml.Attr{Name: XMLName(attrs[i]), Value: attrs[i+1]})
+	}
+	return e
+}
+
+func (e *XMLElement) add(children ...*XMLElement) *XMLElement {
+	e.Children = append(e.Children, children...)
+	return e
+}
+
+// The names of types in schema.
+type schemaNames struct {
+	names map[string]bool
+}
+
+func newSchemaNames(reserved ...string) *schemaNames {
+	n := &schemaNames{names: map[string]bool{}}
+	for _, name := range reserved {
+		n.names[name] = true
+	}
+	return n
+}
+
+// Get the unique type name of prefix and name, the invalid characters
+// are replaced by '_'.
+func (n *schemaNames) get(prefix, name string) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-' && r != '_' {
+			runes[i] = '_'
+		}
+	}
+
+	s := prefix + "." + string(runes)
+	for i := 2; n.names[s]; i++ {
+		s = prefix + "." + string(runes) + "_" + strconv.Itoa(i)
+	}
+
+	n.names[s] = true
+	return s
+}
+
+// The XML Schema type of property, the simple type is not nil if
+// the property has bounds or enumeration values.
+func xsdPropertyType(f *tagField) (string, *XMLElement) {
+	var base string
+	if f.typ == durationType {
+		base = "xs:string"
+	} else {
+		switch f.typ.Kind() {
+		case reflect.Bool:
+			base = "xs:boolean"
+		case reflect.String:
+			base = "xs:string"
+		case reflect.Int8:
+			base = "xs:byte"
+		case reflect.Int16:
+			base = "xs:short"
+		case reflect.Int32:
+			base = "xs:int"
+		case reflect.Int, reflect.Int64:
+			base = "xs:long"
+		case reflect.Uint8:
+			base = "xs:unsignedByte"
+		case reflect.Uint16:
+			base = "xs:unsignedShort"
+		case reflect.Uint32:
+			base = "xs:unsignedInt"
+		case reflect.Uint, reflect.Uint64:
+			base = "xs:unsignedLong"
+		case reflect.Float32:
+			base = "xs:float"
+		default:
+			base = "xs:double"
+		}
+	}
+
+	hasBound := f.typ != durationType && (f.min != nil || f.max != nil)
+	if !hasBound && len(f.enum) == 0 {
+		return base, nil
+	}
+
+	restriction := xsdElement("xs:restriction", "base", base)
+	if hasBound && f.min != nil {
+		restriction.add(xsdElement("xs:minInclusive", "value", f.minS))
+	}
+	if hasBound && f.max != nil {
+		restriction.add(xsdElement("xs:maxInclusive", "value", f.maxS))
+	}
+	for _, e := range f.enum {
+		restriction.add(xsdElement("xs:enumeration", "value", e))
+	}
+
+	return "", xsdElement("xs:simpleType").add(restriction)
+}
+
+// Create the declaration of property, as element or attribute.
+func xsdProperty(declaration string, f *tagField, attrs ...string) *XMLElement {
+	e := xsdElement(declaration, append([]string{"name", f.name}, attrs...)...)
+
+	if typ, simpleType := xsdPropertyType(f); simpleType != nil {
+		e.add(simpleType)
+	} else {
+		e.Attr = append(e.Attr, xml.Attr{Name: XMLName("type"), Value: typ})
+	}
+
+	return e
+}
+
+// Create the complex type of node.
+func xsdNodeType(name string, schema *typeSchema, attrs ...*XMLElement) *XMLElement {
+	t := xsdElement("xs:complexType", "name", name)
+
+	if schema.open {
+		t.add(xsdElement("xs:sequence").add(
+			xsdElement("xs:any", "processContents", "lax", "minOccurs", "0", "maxOccurs", "unbounded"),
+		))
+	} else {
+		all := xsdElement("xs:all")
+		for _, f := range schema.props {
+			all.add(xsdProperty("xs:element", f, "minOccurs", "0"))
+		}
+
+		if schema.child {
+			all.add(xsdElement("xs:element", "ref", XMLStringChild, "minOccurs", "0"))
+		}
+
+		if schema.children {
+			all.add(xsdElement("xs:element", "name", XMLStringChilds, "minOccurs", "0").add(
+				xsdElement("xs:complexType").add(
+					xsdElement("xs:sequence").add(
+						xsdElement("xs:element", "ref", XMLStringChild, "minOccurs", "0", "maxOccurs", "unbounded"),
+					),
+					xsdElement("xs:attribute", "name", "count", "type", "xs:nonNegativeInteger"),
+				),
+			))
+		}
+
+		t.add(all)
+	}
+
+	t.add(attrs...)
+	t.add(
+		xsdElement("xs:attribute", "name", XMLStringID, "type", "xs:unsignedInt"),
+		xsdElement("xs:attribute", "name", XMLStringComment, "type", "xs:string"),
+	)
+
+	if !schema.open {
+		for _, f := range schema.props {
+			t.add(xsdProperty("xs:attribute", f))
+		}
+	}
+
+	// The properties of children are attributes of them.
+	t.add(xsdElement("xs:anyAttribute", "processContents", "lax"))
+
+	return t
+}
+
+// Quote s as XPath string literal.
+func xpathString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Create the attribute with enumeration values.
+func xsdEnumAttribute(name string, values []string, attrs ...string) *XMLElement {
+	restriction := xsdElement("xs:restriction", "base", "xs:string")
+	for _, v := range values {
+		restriction.add(xsdElement("xs:enumeration", "value", v))
+	}
+
+	return xsdElement("xs:attribute", append([]string{"name", name}, attrs...)...).add(
+		xsdElement("xs:simpleType").add(restriction),
+	)
+}
+
+// Create the XML Schema of tree documents, in 1.0 without the
+// alternatives if xsd10.
+func (s *Framework) xmlSchema(xsd10 bool) *XMLElement {
+	names := newSchemaNames("tree", "node")
+	nodeTypes := s.schemaNodeTypes()
+	bevTypes := s.schemaBevTypes()
+
+	schema := xsdElement("xs:schema", "xmlns:xs", xsdNamespace)
+	if !xsd10 {
+		schema.Attr = append(schema.Attr,
+			xml.Attr{Name: XMLName("xmlns:vc"), Value: vcNamespace},
+			xml.Attr{Name: XMLName("vc:minVersion"), Value: "1.1"},
+		)
+	}
+
+	rootType := names.get("node", root.String())
+	schema.add(
+		xsdElement("xs:element", "name", XMLStringTree, "type", "tree"),
+		xsdElement("xs:complexType", "name", "tree").add(
+			xsdElement("xs:sequence").add(
+				xsdElement("xs:element", "name", XMLStringRoot, "type", rootType),
+			),
+			xsdElement("xs:attribute", "name", XMLStringName, "type", "xs:string", "use", "required"),
+			xsdElement("xs:attribute", "name", XMLStringComment, "type", "xs:string"),
+			xsdElement("xs:attribute", "name", XMLStringVersion, "type", "xs:positiveInteger"),
+		),
+		xsdNodeType(rootType, s.nodeSchema(root)),
+	)
+
+	// The children are assigned the types by alternatives in 1.1, or
+	// checked by the generic node type in 1.0.
+	child := xsdElement("xs:element", "name", XMLStringChild)
+	alternative := func(test, typ string) {
+		if !xsd10 {
+			child.add(xsdElement("xs:alternative", "test", test, "type", typ))
+		}
+	}
+	var types []*XMLElement
+
+	for _, bevType := range bevTypes {
+		name := names.get("bev", bevType.String())
+		alternative("@"+XMLStringNodeType+"="+xpathString(behavior.String())+" and @"+XMLStringBevType+"="+xpathString(bevType.String()), name)
+		types = append(types, xsdNodeType(name, s.bevSchema(bevType),
+			xsdElement("xs:attribute", "name", XMLStringNodeType, "type", "xs:string", "fixed", behavior.String(), "use", "required"),
+			xsdElement("xs:attribute", "name", XMLStringBevType, "type", "xs:string", "fixed", bevType.String(), "use", "required"),
+		))
+	}
+
+	for _, nodeType := range nodeTypes {
+		name := names.get("node", nodeType.String())
+		alternative("@"+XMLStringNodeType+"="+xpathString(nodeType.String()), name)
+		types = append(types, xsdNodeType(name, s.nodeSchema(nodeType),
+			xsdElement("xs:attribute", "name", XMLStringNodeType, "type", "xs:string", "fixed", nodeType.String(), "use", "required"),
+		))
+	}
+
+	// The other elements are checked by the generic node type.
+	if xsd10 {
+		child.Attr = append(child.Attr, xml.Attr{Name: XMLName("type"), Value: "node"})
+	} else {
+		child.add(xsdElement("xs:alternative", "type", "node"))
+	}
+
+	nodeTypeNames := []string{behavior.String()}
+	for _, nodeType := range nodeTypes {
+		nodeTypeNames = append(nodeTypeNames, nodeType.String())
+	}
+	sort.Strings(nodeTypeNames)
+
+	bevTypeNames := make([]string, len(bevTypes))
+	for i, bevType := range bevTypes {
+		bevTypeNames[i] = bevType.String()
+	}
+
+	schema.add(child, xsdNodeType("node", &typeSchema{open: true},
+		xsdEnumAttribute(XMLStringNodeType, nodeTypeNames, "use", "required"),
+		xsdEnumAttribute(XMLStringBevType, bevTypeNames),
+	))
+	schema.add(types...)
+
+	return schema
+}
+
+func (s *Framework) marshalXMLSchema(xsd10 bool) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", indent)
+	if err := s.xmlSchema(xsd10).encode(enc, &buf, nil); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Create the JSON object with members in key and value pairs. The
+// values of schema are always valid for json.Marshal, so the errors
+// of JSONObject.Set are ignored.
+func jsonObjectOf(members ...interface{}) *JSONObject {
+	o := NewJSONObject()
+	for i := 0; i+1 < len(members); i += 2 {
+		o.Set(members[i].(string), members[i+1])
+	}
+	return o
+}
+
+func jsonSchemaRef(name string) *JSONObject {
+	return jsonObjectOf("$ref", "#/$defs/"+name)
+}
+
+// The value of property in JSON.
+func jsonPropertyValue(f *tagField, v reflect.Value) interface{} {
+	if f.typ == durationType {
+		return formatTagValue(v)
+	}
+	return v.Interface()
+}
+
+// The JSON Schema of property.
+func jsonPropertySchema(f *tagField) *JSONObject {
+	o := NewJSONObject()
+
+	if f.typ == durationType {
+		o.Set("type", "string")
+	} else {
+		switch f.typ.Kind() {
+		case reflect.Bool:
+			o.Set("type", "boolean")
+		case reflect.String:
+			o.Set("type", "string")
+		case reflect.Float32, reflect.Float64:
+			o.Set("type", "number")
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			o.Set("type", "integer")
+			if f.min == nil {
+				o.Set("minimum", 0)
+			}
+		default:
+			o.Set("type", "integer")
+		}
+
+		if f.min != nil {
+			o.Set("minimum", *f.min)
+		}
+		if f.max != nil {
+			o.Set("maximum", *f.max)
+		}
+	}
+
+	if len(f.enum) > 0 {
+		values := make([]interface{}, 0, len(f.enum))
+		for _, e := range f.enum {
+			if v, err := parseTagValue(f.typ, e); err == nil {
+				values = append(values, jsonPropertyValue(f, v))
+			}
+		}
+		o.Set("enum", values)
+	}
+
+	if f.def.IsValid() {
+		o.Set("default", jsonPropertyValue(f, f.def))
+	}
+
+	return o
+}
+
+// The JSON Schema of object with properties, and extra members in
+// key and value pairs.
+func jsonObjectSchema(schema *typeSchema, required []string, members ...interface{}) *JSONObject {
+	props := jsonObjectOf(members...)
+	if !schema.open {
+		for _, f := range schema.props {
+			props.Set(f.name, jsonPropertySchema(f))
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+
+		if schema.child {
+			props.Set(JSONStringChild, jsonSchemaRef("node"))
+		}
+
+		if schema.children {
+			items := jsonSchemaRef("node")
+			if len(schema.childProps) > 0 {
+				items = jsonObjectSchema(&typeSchema{props: schema.childProps}, []string{JSONStringChild}, JSONStringChild, jsonSchemaRef("node"))
+			}
+			props.Set(JSONStringChildren, jsonObjectOf("type", "array", "items", items))
+		}
+	}
+
+	o := jsonObjectOf("type", "object", "properties", props)
+	if len(required) > 0 {
+		o.Set("required", required)
+	}
+	o.Set("additionalProperties", schema.open)
+	return o
+}
+
+// The JSON Schema members of node type.
+func jsonNodeMembers(nodeType NodeType) []interface{} {
+	return []interface{}{
+		JSONStringNodeType, jsonObjectOf("const", nodeType),
+		JSONStringID, jsonObjectOf("type", "integer", "minimum", 0),
+		JSONStringComment, jsonObjectOf("type", "string"),
+	}
+}
+
+// The if-then schema applied if the member key is value.
+func jsonSchemaIf(key string, value interface{}, then *JSONObject) *JSONObject {
+	return jsonObjectOf(
+		"if", jsonObjectOf("properties", jsonObjectOf(key, jsonObjectOf("const", value)), "required", []string{key}),
+		"then", then,
+	)
+}
+
+func (s *Framework) jsonSchema() *JSONObject {
+	names := newSchemaNames("node")
+	defs := NewJSONObject()
+
+	rootName := names.get("node", root.String())
+	defs.Set(rootName, jsonObjectSchema(s.nodeSchema(root), nil,
+		JSONStringID, jsonObjectOf("type", "integer", "minimum", 0),
+	))
+
+	nodeTypes := s.schemaNodeTypes()
+	nodeTypeNames := []NodeType{behavior}
+	var nodeIfs []*JSONObject
+
+	for _, nodeType := range nodeTypes {
+		name := names.get("node", nodeType.String())
+		defs.Set(name, jsonObjectSchema(s.nodeSchema(nodeType), []string{JSONStringNodeType}, jsonNodeMembers(nodeType)...))
+		nodeTypeNames = append(nodeTypeNames, nodeType)
+		nodeIfs = append(nodeIfs, jsonSchemaIf(JSONStringNodeType, nodeType, jsonSchemaRef(name)))
+	}
+
+	bevTypes := s.schemaBevTypes()
+	var bevIfs []*JSONObject
+	for _, bevType := range bevTypes {
+		name := names.get("bev", bevType.String())
+		defs.Set(name, jsonObjectSchema(s.bevSchema(bevType), nil))
+		bevIfs = append(bevIfs, jsonSchemaIf(JSONStringBevType, bevType,
+			jsonObjectOf("properties", jsonObjectOf(JSONStringBev, jsonSchemaRef(name))),
+		))
+	}
+
+	bevTypeSchema := jsonObjectOf("type", "string")
+	if len(bevTypes) > 0 {
+		bevTypeSchema.Set("enum", bevTypes)
+	}
+
+	behaviorName := names.get("node", behavior.String())
+	behaviorSchema := jsonObjectSchema(&typeSchema{}, []string{JSONStringNodeType, JSONStringBevType, JSONStringBev},
+		append(jsonNodeMembers(behavior),
+			JSONStringBevType, bevTypeSchema,
+			JSONStringBev, jsonObjectOf("type", "object"),
+		)...,
+	)
+	if len(bevIfs) > 0 {
+		behaviorSchema.Set("allOf", bevIfs)
+	}
+	defs.Set(behaviorName, behaviorSchema)
+	nodeIfs = append(nodeIfs, jsonSchemaIf(JSONStringNodeType, behavior, jsonSchemaRef(behaviorName)))
+
+	sort.Slice(nodeTypeNames, func(i, j int) bool { return nodeTypeNames[i] < nodeTypeNames[j] })
+	defs.Set("node", jsonObjectOf(
+		"type", "object",
+		"properties", jsonObjectOf(JSONStringNodeType, jsonObjectOf("enum", nodeTypeNames)),
+		"required", []string{JSONStringNodeType},
+		"allOf", nodeIfs,
+	))
+
+	return jsonObjectOf(
+		"$schema", "https://json-schema.org/draft/2020-12/schema",
+		"title", XMLStringTree,
+		"type", "object",
+		"properties", jsonObjectOf(
+			JSONStringName, jsonObjectOf("type", "string"),
+			JSONStringComment, jsonObjectOf("type", "string"),
+			JSONStringRoot, jsonSchemaRef(rootName),
+		),
+		"required", []string{JSONStringName, JSONStringRoot},
+		"$defs", defs,
+	)
+}
@@ -0,0 +1,183 @@
+package bevtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportXMLSchema(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	var buf bytes.Buffer
+	if err := framework.ExportSchema(&buf, TreeFormatXML); err != nil {
+		t.Fatal(err)
+	}
+
+	d := xml.NewDecoder(&buf)
+	var schema *XMLElement
+	for schema == nil {
+		token, err := d.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			if schema, err = readXMLElement(d, start, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	types := map[string]*XMLElement{}
+	alternatives := map[string]string{}
+	for _, e := range schema.Children {
+		name, _ := e.AttrValue("name")
+		if e.Name == "complexType" {
+			types[name] = e
+		} else if e.Name == "element" && name == XMLStringChild {
+			for _, alt := range e.Children {
+				test, _ := alt.AttrValue("test")
+				alternatives[test], _ = alt.AttrValue("type")
+			}
+		}
+	}
+
+	for test, typ := range map[string]string{
+		`@nodetype='behavior' and @bevtype='tagIncr'`: "bev.tagIncr",
+		`@nodetype='weightselector'`:                  "node.weightselector",
+		``:                                            "node",
+	} {
+		if alternatives[test] != typ {
+			t.Fatalf("alternative %q: type %q, want %q", test, alternatives[test], typ)
+		}
+		if types[typ] == nil {
+			t.Fatalf("type %s not found", typ)
+		}
+	}
+
+	// The tagged properties are elements and attributes.
+	var elements, attributes []string
+	for _, e := range types["bev.tagIncr"].Children {
+		if e.Name == "all" {
+			for _, prop := range e.Children {
+				name, _ := prop.AttrValue("name")
+				elements = append(elements, name)
+			}
+		} else if e.Name == "attribute" {
+			name, _ := e.AttrValue("name")
+			attributes = append(attributes, name)
+		}
+	}
+
+	if want := []string{"key", "limited", "mode", "delay"}; !reflect.DeepEqual(elements, want) {
+		t.Fatalf("property elements %v, want %v", elements, want)
+	}
+
+	if want := []string{"nodetype", "bevtype", "id", "comment", "key", "limited", "mode", "delay"}; !reflect.DeepEqual(attributes, want) {
+		t.Fatalf("attributes %v, want %v", attributes, want)
+	}
+
+	// The bev without tags allows any content.
+	if e := types["bev.blackboardIncr"].Children[0]; e.Name != "sequence" || e.Children[0].Name != "any" {
+		t.Fatalf("bev without tags: %+v", e)
+	}
+}
+
+func TestExportXMLSchema10(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	var buf bytes.Buffer
+	if err := framework.ExportSchema(&buf, TreeFormatXML, WithXMLSchema10()); err != nil {
+		t.Fatal(err)
+	}
+	xsd := buf.String()
+
+	if strings.Contains(xsd, "alternative") || strings.Contains(xsd, "minVersion") {
+		t.Fatalf("XML Schema 1.1 components in 1.0:\n%s", xsd)
+	}
+	if !strings.Contains(xsd, `<xs:element name="child" type="node">`) {
+		t.Fatalf("child not of the generic node type:\n%s", xsd)
+	}
+
+	// Validate trees with libxml2, which supports XML Schema 1.0 only.
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint not found")
+	}
+
+	dir := t.TempDir()
+	schemaPath := filepath.Join(dir, "bevtree.xsd")
+	if err := os.WriteFile(schemaPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalXMLTree(framework, buildCodecTestTree(framework, "main", "sub", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		data  []byte
+		valid bool
+	}{
+		"valid":            {data, true},
+		"unknown nodetype": {bytes.Replace(data, []byte(`nodetype="sequence"`), []byte(`nodetype="unknown"`), 1), false},
+		"no root":          {bytes.Replace(data, []byte("root"), []byte("rot"), -1), false},
+	} {
+		path := filepath.Join(dir, "tree.xml")
+		if err := os.WriteFile(path, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command(xmllint, "--noout", "--schema", schemaPath, path).CombinedOutput()
+		if (err == nil) != c.valid {
+			t.Fatalf("%s: validated %v, want %v:\n%s", name, err == nil, c.valid, out)
+		}
+	}
+}
+
+func TestExportJSONSchema(t *testing.T) {
+	framework := newTestFramework()
+	framework.meta.RegisterBevType(tagIncr, func() Bev { return new(bevTagIncr) })
+
+	var buf bytes.Buffer
+	if err := framework.ExportSchema(&buf, TreeFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"bev.tagIncr":        `{"type":"object","properties":{"key":{"type":"string"},"limited":{"type":"integer","minimum":1,"maximum":10,"default":1},"mode":{"type":"string","enum":["add","sub"],"default":"add"},"delay":{"type":"string"}},"required":["key"],"additionalProperties":false}`,
+		"bev.blackboardIncr": `{"type":"object","properties":{},"additionalProperties":true}`,
+		"node.repeater":      `{"type":"object","properties":{"nodetype":{"const":"repeater"},"id":{"type":"integer","minimum":0},"comment":{"type":"string"},"limited":{"type":"integer","minimum":1},"child":{"$ref":"#/$defs/node"}},"required":["nodetype","limited"],"additionalProperties":false}`,
+	} {
+		var got bytes.Buffer
+		if err := json.Compact(&got, schema.Defs[name]); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if got.String() != want {
+			t.Fatalf("%s:\n%s\nwant:\n%s", name, got.String(), want)
+		}
+	}
+
+	if err := framework.ExportSchema(io.Discard, TreeFormatBinary); err == nil {
+		t.Fatal("schema of binary format should not be supported")
+	}
+}
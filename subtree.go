@@ -2,6 +2,7 @@ package bevtree
 
 import (
 	"github.com/GodYY/gutils/assert"
+	"github.com/pkg/errors"
 )
 
 // Subtree node is a kind of leaf node, used to run a subtree
@@ -32,9 +33,35 @@ func (s *SubtreeNode) Subtree() Tree { return s.subtree }
 
 func (s *SubtreeNode) IndependentDataSet() bool { return s.independentDataSet }
 
+func (s *SubtreeNode) MarshalBTProperties(w PropertyWriter) error {
+	if s.subtree == nil {
+		return errors.New("subtree nil")
+	}
+
+	w.WriteString(PropertySubtree, s.subtree.Name())
+	return nil
+}
+
+func (s *SubtreeNode) UnmarshalBTProperties(p PropertyReader) error {
+	name, err := p.ReadString(PropertySubtree)
+	if err != nil {
+		return err
+	}
+
+	subtree, err := p.Framework().getOrLoadTree(name)
+	if err != nil {
+		return err
+	} else if subtree == nil {
+		return errors.Errorf("subtree \"%s\" not exsit", name)
+	}
+
+	s.subtree = subtree
+	return nil
+}
+
 type subtreeTask struct {
 	node   *SubtreeNode
-	entity Entity
+	entity *entity
 }
 
 // Get the TaskType.
@@ -55,12 +82,20 @@ func (s *subtreeTask) OnInit(_ NodeList, ctx Context) bool {
 
 // OnUpdate is called until the Task is terminated.
 func (s *subtreeTask) OnUpdate(ctx Context) Result {
-	return s.entity.Update()
+	// The subtree shares time with the entity.
+	return s.entity.UpdateDelta(ctx.DeltaTime())
 }
 
 // OnTerminate is called after ths last update of the Task.
 func (s *subtreeTask) OnTerminate(ctx Context) {
+	s.OnTerminateWithReason(ctx, TerminateCompleted, Failure)
+}
+
+// OnTerminateWithReason passes the reason to the running nodes
+// of subtree.
+func (s *subtreeTask) OnTerminateWithReason(ctx Context, reason TerminateReason, result Result) {
 	if s.entity != nil {
+		s.entity.clearAgent(reason)
 		s.entity.Release()
 		s.entity = nil
 	}
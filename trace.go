@@ -0,0 +1,719 @@
+package bevtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The magic bytes at the beginning of trace file.
+const traceMagic = "bevtrace\x01"
+
+// The kinds of trace record.
+const (
+	// A recorder session started, the tables of trees and
+	// nodes are reset.
+	traceRecSession = byte(iota + 1)
+
+	// Define a tree: index, name.
+	traceRecTree
+
+	// Define a node: index, tree index, node ID, nodetype, path.
+	traceRecNode
+
+	// A frame of entity.
+	traceRecFrame
+)
+
+// The flags of frame.
+const (
+	// The frame is ended by Entity.Release.
+	traceFrameReleased = byte(1 << iota)
+)
+
+// The kinds of DataSet change.
+const (
+	traceDataSet = byte(iota)
+	traceDataRemove
+)
+
+// TraceOption configures TraceRecorder.
+type TraceOption func(*traceOptions)
+
+type traceOptions struct {
+	dataSet bool
+}
+
+// WithTraceDataSet records the changes of DataSet in each frame.
+// The values are recorded in the format of fmt.Sprint.
+func WithTraceDataSet() TraceOption {
+	return func(o *traceOptions) { o.dataSet = true }
+}
+
+// The recording state of entity.
+type traceEntity struct {
+	events  []TraceEvent
+	dataSet map[string]string
+}
+
+// The key of node defined, the path is set if the node has no ID.
+type traceNodeKey struct {
+	tree string
+	id   NodeID
+	path string
+}
+
+// TraceRecorder is an Observer records the execution of entities
+// to an append-only trace stream. One frame is recorded per
+// Entity.Update, containing the events happened since the last
+// frame. The stream can be read by TraceReader.
+type TraceRecorder struct {
+	mtx      sync.Mutex
+	w        *bufio.Writer
+	closer   io.Closer
+	opts     traceOptions
+	trees    map[string]uint32
+	nodes    map[traceNodeKey]uint32
+	entities map[uint64]*traceEntity
+	buf      []byte
+	err      error
+}
+
+// NewTraceRecorder creates a TraceRecorder writes a new trace
+// stream to w.
+func NewTraceRecorder(w io.Writer, opts ...TraceOption) *TraceRecorder {
+	return newTraceRecorder(w, true, opts)
+}
+
+func newTraceRecorder(w io.Writer, header bool, opts []TraceOption) *TraceRecorder {
+	r := &TraceRecorder{
+		w:        bufio.NewWriter(w),
+		trees:    map[string]uint32{},
+		nodes:    map[traceNodeKey]uint32{},
+		entities: map[uint64]*traceEntity{},
+	}
+
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+
+	if header {
+		r.buf = append(r.buf[:0], traceMagic...)
+		r.write()
+	}
+
+	r.buf = append(r.buf[:0], traceRecSession)
+	r.write()
+
+	return r
+}
+
+// CreateTraceFile opens the trace file at path for appending, or
+// creates it if not exist, and returns a TraceRecorder writes to it.
+func CreateTraceFile(path string, opts ...TraceOption) (*TraceRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.WithMessage(err, "create trace file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.WithMessage(err, "create trace file")
+	}
+
+	// Append a new session to the existing trace.
+	r := newTraceRecorder(file, info.Size() == 0, opts)
+	r.closer = file
+	return r, nil
+}
+
+// Flush writes the buffered data to the underlying writer.
+func (r *TraceRecorder) Flush() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+
+	return r.err
+}
+
+// Close flushes the buffered data, and closes the file if the
+// recorder was created by CreateTraceFile.
+func (r *TraceRecorder) Close() error {
+	err := r.Flush()
+
+	r.mtx.Lock()
+	closer := r.closer
+	r.closer = nil
+	r.mtx.Unlock()
+
+	if closer != nil {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Err returns the first error happened while recording. The
+// recording stops after error.
+func (r *TraceRecorder) Err() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.err
+}
+
+func (r *TraceRecorder) OnEvent(ev *Event) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	id := ev.Entity.ID()
+
+	// The recording stopped, forget the entity.
+	if r.err != nil {
+		delete(r.entities, id)
+		return
+	}
+
+	te := r.entities[id]
+	if te == nil {
+		te = &traceEntity{}
+		r.entities[id] = te
+	}
+
+	ctx := ev.Entity.Context()
+	tree := ctx.Tree()
+
+	te.events = append(te.events, TraceEvent{
+		Type:   ev.Type,
+		Node:   r.nodeIndex(tree, ev.Node),
+		Result: ev.Result,
+	})
+
+	switch ev.Type {
+	case EventEntityUpdate:
+		r.writeFrame(id, te, ctx, ev.Result, 0)
+
+	case EventEntityRelease:
+		r.writeFrame(id, te, ctx, ev.Result, traceFrameReleased)
+		delete(r.entities, id)
+	}
+}
+
+// Get the index of node, define it if not yet. Return 0 for
+// nil node, the indexes of nodes start from 1. The nodes are
+// identified by tree name and node ID, so the nodes of trees
+// reloaded are defined once.
+func (r *TraceRecorder) nodeIndex(tree Tree, node Node) uint32 {
+	if node == nil {
+		return 0
+	}
+
+	key := traceNodeKey{tree: tree.Name(), id: tree.NodeID(node)}
+	if !key.id.Valid() {
+		key.path = NodePath(node)
+	}
+
+	if idx, ok := r.nodes[key]; ok {
+		return idx
+	}
+
+	treeIdx, ok := r.trees[key.tree]
+	if !ok {
+		treeIdx = uint32(len(r.trees))
+		r.trees[key.tree] = treeIdx
+
+		r.buf = append(r.buf[:0], traceRecTree)
+		r.buf = appendUvarint(r.buf, uint64(treeIdx))
+		r.buf = appendTraceString(r.buf, key.tree)
+		r.write()
+	}
+
+	idx := uint32(len(r.nodes) + 1)
+	r.nodes[key] = idx
+
+	r.buf = append(r.buf[:0], traceRecNode)
+	r.buf = appendUvarint(r.buf, uint64(idx))
+	r.buf = appendUvarint(r.buf, uint64(treeIdx))
+	r.buf = appendUvarint(r.buf, uint64(key.id))
+	r.buf = appendTraceString(r.buf, string(node.NodeType()))
+	r.buf = appendTraceString(r.buf, NodePath(node))
+	r.write()
+
+	return idx
+}
+
+func (r *TraceRecorder) writeFrame(id uint64, te *traceEntity, ctx Context, result Result, flags byte) {
+	r.buf = append(r.buf[:0], traceRecFrame, flags)
+	r.buf = appendUvarint(r.buf, id)
+	r.buf = appendUvarint(r.buf, uint64(ctx.UpdateSeri()))
+	r.buf = appendVarint(r.buf, ctx.Now().UnixNano())
+	r.buf = append(r.buf, byte(result))
+
+	r.buf = appendUvarint(r.buf, uint64(len(te.events)))
+	for _, e := range te.events {
+		r.buf = append(r.buf, byte(e.Type))
+		r.buf = appendUvarint(r.buf, uint64(e.Node))
+		r.buf = append(r.buf, byte(e.Result))
+	}
+	te.events = te.events[:0]
+
+	if ds, ok := ctx.DataSet().(*dataSet); ok && r.opts.dataSet {
+		r.buf = te.appendDataSetDiff(r.buf, ds)
+	} else {
+		r.buf = appendUvarint(r.buf, 0)
+	}
+
+	r.write()
+}
+
+// Append the changes of DataSet since the last frame, in the
+// order of keys.
+func (te *traceEntity) appendDataSetDiff(buf []byte, ds *dataSet) []byte {
+	cur := make(map[string]string, len(ds.keyValues))
+	for k, v := range ds.keyValues {
+		cur[k] = fmt.Sprint(v)
+	}
+
+	var changes []TraceDataChange
+	for k, v := range cur {
+		if old, ok := te.dataSet[k]; !ok || old != v {
+			changes = append(changes, TraceDataChange{Key: k, Value: v})
+		}
+	}
+
+	for k := range te.dataSet {
+		if _, ok := cur[k]; !ok {
+			changes = append(changes, TraceDataChange{Key: k, Removed: true})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	buf = appendUvarint(buf, uint64(len(changes)))
+	for _, c := range changes {
+		if c.Removed {
+			buf = append(buf, traceDataRemove)
+			buf = appendTraceString(buf, c.Key)
+		} else {
+			buf = append(buf, traceDataSet)
+			buf = appendTraceString(buf, c.Key)
+			buf = appendTraceString(buf, c.Value)
+		}
+	}
+
+	te.dataSet = cur
+	return buf
+}
+
+func (r *TraceRecorder) write() {
+	if r.err == nil {
+		_, r.err = r.w.Write(r.buf)
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendTraceString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// TraceNode describes a node in trace.
+type TraceNode struct {
+	Tree     string
+	ID       NodeID
+	NodeType NodeType
+	Path     string
+}
+
+// TraceEvent is an Event in trace. Node is the index of node in
+// the session, 0 for entity events.
+type TraceEvent struct {
+	Type   EventType
+	Node   uint32
+	Result Result
+}
+
+// TraceDataChange is a change of DataSet.
+type TraceDataChange struct {
+	Key     string
+	Value   string
+	Removed bool
+}
+
+// TraceFrame is the record of an Entity.Update, or the releasing
+// of entity.
+type TraceFrame struct {
+	// The session index in trace file, starts from 0.
+	Session int
+
+	// The entity ID, unique in session.
+	Entity uint64
+
+	// The tree name of entity, empty if no node executed in the
+	// entity yet.
+	Tree string
+
+	// The update serial number.
+	UpdateSeri uint32
+
+	// The time of entity at the update.
+	Time time.Time
+
+	// The result of Entity.Update.
+	Result Result
+
+	// The frame is ended by Entity.Release.
+	Released bool
+
+	// The events happened since the last frame, in order.
+	Events []TraceEvent
+
+	// The changes of DataSet since the last frame.
+	DataSet []TraceDataChange
+
+	// The nodes running after the update, in the order of starting.
+	Running []*TraceNode
+
+	nodes []*TraceNode
+}
+
+// Get the node of event, nil for entity events.
+func (f *TraceFrame) Node(e TraceEvent) *TraceNode {
+	if e.Node == 0 || int(e.Node) > len(f.nodes) {
+		return nil
+	}
+	return f.nodes[e.Node-1]
+}
+
+// TraceReader reads the frames recorded by TraceRecorder.
+type TraceReader struct {
+	r        *bufio.Reader
+	session  int
+	trees    []string
+	nodes    []*TraceNode
+	entities map[uint64][]*TraceNode
+}
+
+// NewTraceReader creates a TraceReader reads from r. r must be
+// positioned at the beginning of trace file.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, errors.WithMessage(err, "read trace header")
+	}
+
+	if string(magic) != traceMagic {
+		return nil, errors.New("invalid trace header")
+	}
+
+	return &TraceReader{r: br, session: -1}, nil
+}
+
+// Next reads the next frame. It returns io.EOF if there are no
+// frames.
+func (r *TraceReader) Next() (*TraceFrame, error) {
+	for {
+		kind, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case traceRecSession:
+			r.session++
+			r.trees = r.trees[:0]
+			r.nodes = nil
+			r.entities = map[uint64][]*TraceNode{}
+
+		case traceRecTree:
+			if err := r.readTree(); err != nil {
+				return nil, errors.WithMessage(err, "read trace tree")
+			}
+
+		case traceRecNode:
+			if err := r.readNode(); err != nil {
+				return nil, errors.WithMessage(err, "read trace node")
+			}
+
+		case traceRecFrame:
+			if r.session < 0 {
+				return nil, errors.New("read trace frame: no session")
+			}
+
+			f, err := r.readFrame()
+			if err != nil {
+				return nil, errors.WithMessage(err, "read trace frame")
+			}
+			return f, nil
+
+		default:
+			return nil, errors.Errorf("invalid trace record kind %d", kind)
+		}
+	}
+}
+
+func (r *TraceReader) readTree() error {
+	idx, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if idx != uint64(len(r.trees)) {
+		return errors.Errorf("invalid tree index %d", idx)
+	}
+
+	name, err := r.readString()
+	if err != nil {
+		return err
+	}
+
+	r.trees = append(r.trees, name)
+	return nil
+}
+
+func (r *TraceReader) readNode() error {
+	var v [3]uint64
+	for i := range v {
+		var err error
+		if v[i], err = binary.ReadUvarint(r.r); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+
+	if v[0] != uint64(len(r.nodes)+1) {
+		return errors.Errorf("invalid node index %d", v[0])
+	}
+
+	if v[1] >= uint64(len(r.trees)) {
+		return errors.Errorf("invalid tree index %d", v[1])
+	}
+
+	nodeType, err := r.readString()
+	if err != nil {
+		return err
+	}
+
+	path, err := r.readString()
+	if err != nil {
+		return err
+	}
+
+	r.nodes = append(r.nodes, &TraceNode{
+		Tree:     r.trees[v[1]],
+		ID:       NodeID(v[2]),
+		NodeType: NodeType(nodeType),
+		Path:     path,
+	})
+
+	return nil
+}
+
+func (r *TraceReader) readFrame() (*TraceFrame, error) {
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	f := &TraceFrame{
+		Session:  r.session,
+		Released: flags&traceFrameReleased != 0,
+		nodes:    r.nodes,
+	}
+
+	if f.Entity, err = binary.ReadUvarint(r.r); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	seri, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	f.UpdateSeri = uint32(seri)
+
+	nanos, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	f.Time = time.Unix(0, nanos)
+
+	result, err := r.r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	f.Result = Result(result)
+
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	running := r.entities[f.Entity]
+	for i := uint64(0); i < n; i++ {
+		var e TraceEvent
+		var b [2]byte
+
+		if b[0], err = r.r.ReadByte(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		node, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if node > uint64(len(r.nodes)) {
+			return nil, errors.Errorf("invalid node index %d", node)
+		}
+
+		if b[1], err = r.r.ReadByte(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		e.Type, e.Node, e.Result = EventType(b[0]), uint32(node), Result(b[1])
+		f.Events = append(f.Events, e)
+
+		if tn := f.Node(e); tn != nil {
+			f.Tree = tn.Tree
+
+			// Replay the lifecycle of agents.
+			switch e.Type {
+			case EventAgentCreate:
+				running = append(running, tn)
+			case EventAgentDestroy:
+				for j, rn := range running {
+					if rn == tn {
+						running = append(running[:j:j], running[j+1:]...)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	if f.Released {
+		delete(r.entities, f.Entity)
+	} else {
+		r.entities[f.Entity] = running
+		f.Running = append([]*TraceNode(nil), running...)
+	}
+
+	if n, err = binary.ReadUvarint(r.r); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var c TraceDataChange
+
+		kind, err := r.r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if c.Key, err = r.readString(); err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case traceDataSet:
+			if c.Value, err = r.readString(); err != nil {
+				return nil, err
+			}
+		case traceDataRemove:
+			c.Removed = true
+		default:
+			return nil, errors.Errorf("invalid data change kind %d", kind)
+		}
+
+		f.DataSet = append(f.DataSet, c)
+	}
+
+	return f, nil
+}
+
+func (r *TraceReader) readString() (string, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	if n > math.MaxInt32 {
+		return "", errors.Errorf("invalid string length %d", n)
+	}
+
+	// Not to allocate by the length read, which may be corrupted.
+	var b strings.Builder
+	if _, err := io.CopyN(&b, r.r, int64(n)); err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	return b.String(), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadTraceFile reads all frames of the trace file at path.
+func ReadTraceFile(path string) ([]*TraceFrame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read trace file")
+	}
+	defer file.Close()
+
+	r, err := NewTraceReader(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read trace file \"%s\"", path)
+	}
+
+	var frames []*TraceFrame
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return frames, errors.WithMessagef(err, "read trace file \"%s\"", path)
+		}
+
+		frames = append(frames, f)
+	}
+}
+
+// FindTraceFrame returns the last frame of the entity at the update
+// serial number in frames, or nil if not found.
+func FindTraceFrame(frames []*TraceFrame, entity uint64, updateSeri uint32) *TraceFrame {
+	for i := len(frames) - 1; i >= 0; i-- {
+		if f := frames[i]; f.Entity == entity && f.UpdateSeri == updateSeri && !f.Released {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package bevtree
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// The static assets of viewer.
+//
+//go:embed viewer
+var viewerAssets embed.FS
+
+// The node of tree in viewer and debugger.
+type nodeView struct {
+	ID       NodeID      `json:"id"`
+	NodeType NodeType    `json:"nodetype"`
+	BevType  BevType     `json:"bevtype,omitempty"`
+	Subtree  string      `json:"subtree,omitempty"`
+	Comment  string      `json:"comment,omitempty"`
+	Path     string      `json:"path"`
+	Children []*nodeView `json:"children,omitempty"`
+}
+
+func newNodeView(t Tree, n Node) *nodeView {
+	vn := &nodeView{
+		ID:       t.NodeID(n),
+		NodeType: n.NodeType(),
+		Comment:  n.Comment(),
+		Path:     NodePath(n),
+	}
+
+	switch o := n.(type) {
+	case *BevNode:
+		if o.Bev() != nil {
+			vn.BevType = o.Bev().BevType()
+		}
+	case *SubtreeNode:
+		if o.Subtree() != nil {
+			vn.Subtree = o.Subtree().Name()
+		}
+	}
+
+	for _, c := range childNodes(n) {
+		vn.Children = append(vn.Children, newNodeView(t, c))
+	}
+
+	return vn
+}
+
+// NewViewerHandler returns an http.Handler serves a browser-based
+// viewer of the trees in framework. The live state of entities is
+// shown if the framework has a Debugger. Mount it with
+// http.StripPrefix to serve under a path with trailing slash.
+//
+//	/                     the viewer page
+//	/api/trees            the names of trees loaded
+//	/api/tree?name=       the nodes of tree, 404 if not loaded
+//	/api/entities         the entities, see Debugger.Entities
+//	/api/entity?id=       the latest snapshot of entity
+func NewViewerHandler(framework *Framework) http.Handler {
+	static, err := fs.Sub(viewerAssets, "viewer")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+
+	mux.HandleFunc("/api/trees", func(w http.ResponseWriter, r *http.Request) {
+		// The trees not loaded can't be shown by /api/tree.
+		names := make([]string, 0, len(framework.treeAssets))
+		for name := range framework.treeAssets {
+			if framework.loadedTree(name) != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		writeViewerJSON(w, names)
+	})
+
+	mux.HandleFunc("/api/tree", func(w http.ResponseWriter, r *http.Request) {
+		// Not to load trees on requests.
+		tree := framework.loadedTree(r.URL.Query().Get("name"))
+		if tree == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		writeViewerJSON(w, struct {
+			Name    string    `json:"name"`
+			Comment string    `json:"comment,omitempty"`
+			Root    *nodeView `json:"root"`
+		}{tree.Name(), tree.Comment(), newNodeView(tree, tree.Root())})
+	})
+
+	mux.HandleFunc("/api/entities", func(w http.ResponseWriter, r *http.Request) {
+		entities := []DebugEntity{}
+		if framework.debugger != nil {
+			entities = framework.debugger.Entities()
+		}
+		writeViewerJSON(w, entities)
+	})
+
+	mux.HandleFunc("/api/entity", func(w http.ResponseWriter, r *http.Request) {
+		if framework.debugger == nil {
+			http.Error(w, "no debugger", http.StatusNotFound)
+			return
+		}
+
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := framework.debugger.LatestSnapshot(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeViewerJSON(w, s)
+	})
+
+	return mux
+}
+
+func writeViewerJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+// bevtree viewer: draws the tree top-down and overlays the
+// latest snapshot of the chosen entity.
+(function () {
+  'use strict';
+
+  var NODE_W = 140, NODE_H = 38, GAP_X = 16, GAP_Y = 36;
+  var SVG = 'http://www.w3.org/2000/svg';
+
+  var treesEl = document.getElementById('trees');
+  var entitiesEl = document.getElementById('entities');
+  var statusEl = document.getElementById('status');
+  var graphEl = document.getElementById('graph');
+  var nodeEl = document.getElementById('node');
+  var datasetEl = document.getElementById('dataset');
+
+  var tree = null;
+  var shapes = {};
+
+  function get(url) {
+    return fetch(url).then(function (r) {
+      if (!r.ok) { throw new Error(r.status + ' ' + r.statusText); }
+      return r.json();
+    });
+  }
+
+  function el(name, attrs, parent) {
+    var e = document.createElementNS(SVG, name);
+    for (var k in attrs) { e.setAttribute(k, attrs[k]); }
+    if (parent) { parent.appendChild(e); }
+    return e;
+  }
+
+  // Assign x to leaves in order, parents are centered above children.
+  function layout(n, depth, next) {
+    n.depth = depth;
+    var children = n.children || [];
+    if (children.length === 0) {
+      n.x = next.x;
+      next.x += NODE_W + GAP_X;
+    } else {
+      children.forEach(function (c) { layout(c, depth + 1, next); });
+      n.x = (children[0].x + children[children.length - 1].x) / 2;
+    }
+    next.depth = Math.max(next.depth, depth);
+  }
+
+  function draw() {
+    graphEl.innerHTML = '';
+    shapes = {};
+    if (!tree) { return; }
+
+    var next = { x: GAP_X, depth: 0 };
+    layout(tree.root, 0, next);
+
+    var svg = el('svg', {
+      width: next.x,
+      height: (next.depth + 1) * (NODE_H + GAP_Y) + GAP_Y
+    }, graphEl);
+
+    (function drawNode(n) {
+      var y = GAP_Y + n.depth * (NODE_H + GAP_Y);
+      (n.children || []).forEach(function (c) {
+        var cy = GAP_Y + c.depth * (NODE_H + GAP_Y);
+        el('path', {
+          'class': 'edge',
+          d: 'M' + (n.x + NODE_W / 2) + ',' + (y + NODE_H) +
+             ' C' + (n.x + NODE_W / 2) + ',' + (y + NODE_H + GAP_Y / 2) +
+             ' ' + (c.x + NODE_W / 2) + ',' + (cy - GAP_Y / 2) +
+             ' ' + (c.x + NODE_W / 2) + ',' + cy
+        }, svg);
+        drawNode(c);
+      });
+
+      var g = el('g', { 'class': 'node', transform: 'translate(' + n.x + ',' + y + ')' }, svg);
+      el('rect', { width: NODE_W, height: NODE_H }, g);
+      var title = el('text', { x: 6, y: 15 }, g);
+      title.textContent = n.bevtype || n.nodetype;
+      var sub = el('text', { 'class': 'sub', x: 6, y: 30 }, g);
+      sub.textContent = '#' + n.id + (n.subtree ? ' → ' + n.subtree : '');
+      g.addEventListener('click', function () {
+        var info = Object.assign({}, n);
+        delete info.children; delete info.x; delete info.depth;
+        nodeEl.textContent = JSON.stringify(info, null, 2);
+      });
+      shapes[n.path] = g;
+    })(tree.root);
+  }
+
+  function collectRunning(nodes, out) {
+    (nodes || []).forEach(function (n) {
+      out[n.path] = true;
+      collectRunning(n.children, out);
+    });
+    return out;
+  }
+
+  function overlay(s) {
+    var running = s ? collectRunning(s.nodes, {}) : {};
+    var results = (s && s.results) || {};
+    Object.keys(shapes).forEach(function (path) {
+      var cls = 'node';
+      if (running[path]) {
+        cls += ' running';
+      } else if (results[path]) {
+        cls += ' ' + results[path];
+      }
+      if (s && s.paused && s.paused.path === path) { cls += ' paused'; }
+      shapes[path].setAttribute('class', cls);
+    });
+
+    datasetEl.textContent = s ? JSON.stringify(s.dataset, null, 2) : '';
+    statusEl.textContent = s ? 'update #' + s.updateseri + (s.paused ? ' (paused)' : '') : '';
+  }
+
+  function loadTree(name) {
+    if (!name) { return; }
+    get('api/tree?name=' + encodeURIComponent(name)).then(function (t) {
+      tree = t;
+      draw();
+      refreshEntities();
+    }).catch(function (e) { statusEl.textContent = e.message; });
+  }
+
+  function refreshEntities() {
+    get('api/entities').then(function (entities) {
+      var selected = entitiesEl.value;
+      entitiesEl.innerHTML = '<option value="">(none)</option>';
+      entities.forEach(function (e) {
+        if (tree && e.tree !== tree.name) { return; }
+        var o = document.createElement('option');
+        o.value = e.id;
+        o.textContent = '#' + e.id + (e.paused ? ' (paused)' : '');
+        entitiesEl.appendChild(o);
+      });
+      entitiesEl.value = selected;
+    }).catch(function () {});
+  }
+
+  function poll() {
+    var id = entitiesEl.value;
+    if (!id) {
+      overlay(null);
+      return;
+    }
+    get('api/entity?id=' + id).then(overlay).catch(function (e) {
+      statusEl.textContent = e.message;
+      refreshEntities();
+    });
+  }
+
+  treesEl.addEventListener('change', function () { loadTree(treesEl.value); });
+  entitiesEl.addEventListener('change', poll);
+
+  get('api/trees').then(function (names) {
+    names.forEach(function (name) {
+      var o = document.createElement('option');
+      o.value = o.textContent = name;
+      treesEl.appendChild(o);
+    });
+    loadTree(names[0]);
+  });
+
+  setInterval(poll, 500);
+  setInterval(refreshEntities, 3000);
+})();
@@ -5,8 +5,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"io/fs"
 	"os"
 	"strconv"
 	"strings"
@@ -34,6 +33,9 @@ const (
 	// xml name for comment.
 	XMLStringComment = "comment"
 
+	// xml name for node ID.
+	XMLStringID = "id"
+
 	// xml name for NodeType.
 	XMLStringNodeType = "nodetype"
 
@@ -59,6 +61,9 @@ const (
 	XMLStringSubtree = "subtree"
 
 	XMLStringConfig = "config"
+
+	// xml name for format version.
+	XMLStringVersion = "version"
 )
 
 func XMLNameToString(name xml.Name) string {
@@ -103,6 +108,53 @@ func XMLTokenErrorf(token xml.Token, f string, args ...interface{}) error {
 	return errors.Errorf(XMLTokenToString(token)+": "+f, args...)
 }
 
+// DecodeError is the error of decoding with the position in input.
+type DecodeError struct {
+	// The file path, empty if not decoding a file.
+	Path string
+
+	// The byte offset in input.
+	Offset int64
+
+	// The line and column, starting at 1. The column counts in
+	// characters.
+	Line, Column int
+
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s:%d:%d: %v", e.Path, e.Line, e.Column, e.Err)
+	}
+
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// DecodeErrors is the errors collected in decoding an input, in the
+// order of positions.
+type DecodeErrors []*DecodeError
+
+func (e DecodeErrors) Error() string {
+	var sb strings.Builder
+	for i, err := range e {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+// The placeholder of the node failed to decode.
+type badNode struct {
+	node
+}
+
+func (badNode) NodeType() NodeType { return NodeType("") }
+
 func XMLAttrNotFoundError(attrName xml.Name) error {
 	return errors.Errorf("attribute \"%s\" not found", XMLNameToString(attrName))
 }
@@ -123,6 +175,9 @@ type XMLUnmarshaler interface {
 type XMLEncoder struct {
 	*xml.Encoder
 	framework *Framework
+
+	// The IDs of nodes of the tree encoding.
+	ids nodeIDs
 }
 
 // newXMLEncoder returns a new encoder that writes to w.
@@ -136,8 +191,19 @@ func newXMLEncoder(framework *Framework, w io.Writer) *XMLEncoder {
 	}
 }
 
+// logStart logs the start element to marshal at debug level.
+func (e *XMLEncoder) logStart(name string, start xml.StartElement) {
+	if e.framework.logEnabled(LogDebug) {
+		e.framework.log(LogDebug, name+" marshal", LogField{Key: "element", Value: XMLTokenToString(start)})
+	}
+}
+
 // EncodeElement writes the bevtree XML encoding of v to the stream,
-// using start as the outermost tag in the encoding.
+// using start as the outermost tag in the encoding. If v does not
+// implement XMLMarshaler but PropertyMarshaler, or has fields tagged
+// with PropertyTagKey, the scalar properties are encoded as elements,
+// the child as element "child", and the children as elements "child"
+// in element "childs", with their properties as attributes.
 //
 // EncodeElement calls Flush before returning.
 func (e *XMLEncoder) EncodeElement(v interface{}, start xml.StartElement) error {
@@ -147,11 +213,108 @@ func (e *XMLEncoder) EncodeElement(v interface{}, start xml.StartElement) error
 		}
 
 		return e.Flush()
+	} else if marshaler, err := propertyMarshalerOf(v); err != nil {
+		return err
+	} else if marshaler != nil {
+		return e.encodeProperties(marshaler, start)
 	} else {
 		return e.Encoder.EncodeElement(v, start)
 	}
 }
 
+func (e *XMLEncoder) encodeProperties(v PropertyMarshaler, start xml.StartElement) error {
+	e.logStart("properties", start)
+
+	w := &xmlPropertyWriter{e: e}
+	err := e.EncodeToken(start)
+	if err == nil {
+		err = v.MarshalBTProperties(w)
+	}
+	if err == nil {
+		err = w.err
+	}
+	if err == nil {
+		err = e.EncodeToken(start.End())
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "%s Marshal", XMLTokenToString(start))
+	}
+
+	return e.Flush()
+}
+
+// The PropertyWriter of XMLEncoder.
+type xmlPropertyWriter struct {
+	e   *XMLEncoder
+	err error
+}
+
+func (w *xmlPropertyWriter) write(name string, value string) {
+	if w.err == nil {
+		w.err = w.e.Encoder.EncodeElement(value, xml.StartElement{Name: XMLName(name)})
+	}
+}
+
+func (w *xmlPropertyWriter) WriteInt(name string, v int64) {
+	w.write(name, strconv.FormatInt(v, 10))
+}
+
+func (w *xmlPropertyWriter) WriteFloat(name string, v float64) {
+	w.write(name, formatFloat(v))
+}
+
+func (w *xmlPropertyWriter) WriteBool(name string, v bool) {
+	w.write(name, strconv.FormatBool(v))
+}
+
+func (w *xmlPropertyWriter) WriteString(name string, v string) {
+	w.write(name, v)
+}
+
+func (w *xmlPropertyWriter) WriteChild(child Node) {
+	if w.err != nil || child == nil {
+		return
+	}
+
+	if err := w.e.EncodeNode(child, xml.StartElement{Name: XMLName(XMLStringChild)}); err != nil {
+		w.err = errors.WithMessage(err, "Marshal child")
+	}
+}
+
+func (w *xmlPropertyWriter) WriteChildren(count int, child func(int, PropertyWriter) Node) {
+	if w.err != nil || count == 0 {
+		return
+	}
+
+	childsStart := xml.StartElement{Name: XMLName(XMLStringChilds)}
+	childsStart.Attr = append(childsStart.Attr, xml.Attr{Name: XMLName("count"), Value: strconv.Itoa(count)})
+
+	if w.err = w.e.EncodeToken(childsStart); w.err != nil {
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		var props propertyList
+		node := child(i, &props)
+		if props.err != nil {
+			w.err = errors.WithMessagef(props.err, "Marshal No.%d child", i)
+			return
+		}
+
+		childStart := xml.StartElement{Name: XMLName(XMLStringChild)}
+		for j, name := range props.names {
+			childStart.Attr = append(childStart.Attr, xml.Attr{Name: XMLName(name), Value: formatProperty(props.values[j])})
+		}
+
+		if err := w.e.EncodeNode(node, childStart); err != nil {
+			w.err = errors.WithMessagef(err, "Marshal No.%d child", i)
+			return
+		}
+	}
+
+	w.err = w.e.EncodeToken(childsStart.End())
+}
+
 // EncodeSE writes the bevtree XML encoding of v to the stream
 // between start and start.End(), using start as the outermost tag
 // in the encoding.
@@ -213,6 +376,10 @@ func (e *XMLEncoder) EncodeNode(n Node, start xml.StartElement) error {
 		return err
 	}
 
+	if id := e.ids[n]; id.Valid() {
+		start.Attr = append(start.Attr, xml.Attr{Name: XMLName(XMLStringID), Value: id.String()})
+	}
+
 	if n.Comment() != "" {
 		start.Attr = append(start.Attr, xml.Attr{Name: XMLName(XMLStringComment), Value: n.Comment()})
 	}
@@ -239,7 +406,8 @@ func (e *XMLEncoder) marshalBevTypeAttr(bevType BevType, name xml.Name) (xml.Att
 }
 
 // A XMLDecoder represents an bevtree XML parser reading a particular
-// input stream.
+// input. It tracks the positions of elements for errors, so the tokens
+// should be read by the methods of XMLDecoder instead of xml.Decoder.
 type XMLDecoder struct {
 	*xml.Decoder
 
@@ -248,18 +416,34 @@ type XMLDecoder struct {
 
 	// behavior tree system.
 	framework *Framework
+
+	// The path of the file decoding, empty if not decoding a file.
+	path string
+
+	// The data decoding.
+	data []byte
+
+	// The source of data if migrated, see migrateXML.
+	source *xmlSource
+
+	// The offsets of the start elements not ended.
+	starts []int64
+
+	// The errors of nodes, the decoding continues after them.
+	errs DecodeErrors
+
+	// The IDs of nodes of the tree decoding.
+	ids nodeIDs
 }
 
-// newXMLDecoder creates a new bevtree XML parser reading from r.
-// If r does not implement io.ByteReader, newXMLDecoder will
-// do its own buffering.
-func newXMLDecoder(framework *Framework, r io.Reader) *XMLDecoder {
+// newXMLDecoder creates a new bevtree XML parser reading from data.
+func newXMLDecoder(framework *Framework, data []byte) *XMLDecoder {
 	assert.Assert(framework != nil, "framework nil")
-	assert.Assert(r != nil, "reader nil")
 
 	return &XMLDecoder{
-		Decoder:   xml.NewDecoder(r),
+		Decoder:   xml.NewDecoder(bytes.NewReader(data)),
 		framework: framework,
+		data:      data,
 	}
 }
 
@@ -269,8 +453,25 @@ func (d *XMLDecoder) Token() (xml.Token, error) {
 		d.tokenCached = nil
 		return token, nil
 	} else {
-		return d.Decoder.Token()
+		return d.token()
+	}
+}
+
+// Read the next token, and track the start elements.
+func (d *XMLDecoder) token() (xml.Token, error) {
+	offset := d.Decoder.InputOffset()
+	token, err := d.Decoder.Token()
+
+	switch token.(type) {
+	case xml.StartElement:
+		d.starts = append(d.starts, offset)
+	case xml.EndElement:
+		if len(d.starts) > 0 {
+			d.starts = d.starts[:len(d.starts)-1]
+		}
 	}
+
+	return token, err
 }
 
 func (d *XMLDecoder) Skip() error {
@@ -283,18 +484,231 @@ func (d *XMLDecoder) Skip() error {
 		d.tokenCached = nil
 	}
 
-	return d.Decoder.Skip()
+	return d.skipTo(len(d.starts))
+}
+
+// Read tokens until the element at depth ended.
+func (d *XMLDecoder) skipTo(depth int) error {
+	d.tokenCached = nil
+	for len(d.starts) >= depth {
+		if _, err := d.token(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decode the element with xml.Decoder.
+func (d *XMLDecoder) decodeStdElement(v interface{}, start xml.StartElement) error {
+	if err := d.Decoder.DecodeElement(v, &start); err != nil {
+		return err
+	}
+
+	if len(d.starts) > 0 {
+		d.starts = d.starts[:len(d.starts)-1]
+	}
+
+	return nil
+}
+
+// The offset of the element decoding.
+func (d *XMLDecoder) startOffset() int64 {
+	if n := len(d.starts); n > 0 {
+		return d.starts[n-1]
+	}
+
+	return d.Decoder.InputOffset()
+}
+
+// The offset, line and column in the source of offset.
+func (d *XMLDecoder) position(offset int64) (int64, int, int) {
+	data := d.data
+	if d.source != nil {
+		data, offset = d.source.data, d.source.offsetOf(offset)
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	line, column := xmlPosition(data, offset)
+	return offset, line, column
+}
+
+// Get the line and column of offset in data.
+func xmlPosition(data []byte, offset int64) (int, int) {
+	line, column := 1, 1
+	for _, r := range string(data[:offset]) {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	return line, column
+}
+
+// Return the error at offset. The error containing DecodeError is
+// returned as is, and the syntax error is at the current offset.
+func (d *XMLDecoder) errorAt(offset int64, err error) *DecodeError {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return de
+	}
+
+	var se *xml.SyntaxError
+	if errors.As(err, &se) {
+		offset = d.Decoder.InputOffset()
+	}
+
+	de = &DecodeError{Path: d.path, Err: err}
+	de.Offset, de.Line, de.Column = d.position(offset)
+	return de
+}
+
+// Return the errors collected with err.
+func (d *XMLDecoder) finish(err error) error {
+	if err != nil {
+		d.errs = append(d.errs, d.errorAt(d.Decoder.InputOffset(), err))
+	}
+
+	if len(d.errs) > 0 {
+		return d.errs
+	}
+
+	return nil
 }
 
 func (d *XMLDecoder) Framework() *Framework { return d.framework }
 
-// DecodeElement read element from start to parse into v.
+// logStart logs the start element to unmarshal at debug level.
+func (d *XMLDecoder) logStart(name string, start xml.StartElement) {
+	if d.framework.logEnabled(LogDebug) {
+		d.framework.log(LogDebug, name+" unmarshal", LogField{Key: "element", Value: XMLTokenToString(start)})
+	}
+}
+
+// log logs a message raised while decoding, with the path.
+func (d *XMLDecoder) log(level LogLevel, msg string, fields ...LogField) {
+	if !d.framework.logEnabled(level) {
+		return
+	}
+
+	if d.path != "" {
+		fields = append(fields, LogField{Key: LogKeyPath, Value: d.path})
+	}
+
+	d.framework.log(level, msg, fields...)
+}
+
+// DecodeElement read element from start to parse into v. If v does
+// not implement XMLUnmarshaler but PropertyUnmarshaler, or has fields
+// tagged with PropertyTagKey, the element is read as properties, see XMLEncoder.EncodeElement. The attributes
+// are read as properties too.
 func (d *XMLDecoder) DecodeElement(v interface{}, start xml.StartElement) error {
 	if unmarshal, ok := v.(XMLUnmarshaler); ok {
 		return unmarshal.UnmarshalBTXML(d, start)
+	} else if unmarshal, err := propertyUnmarshalerOf(v); err != nil {
+		return err
+	} else if unmarshal != nil {
+		return d.decodeProperties(unmarshal, start)
 	} else {
-		return d.Decoder.DecodeElement(v, &start)
+		return d.decodeStdElement(v, start)
+	}
+}
+
+func (d *XMLDecoder) decodeProperties(v PropertyUnmarshaler, start xml.StartElement) error {
+	d.logStart("properties", start)
+
+	r, err := d.readProperties(start)
+	if err == nil {
+		err = v.UnmarshalBTProperties(r)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "%s Unmarshal", XMLTokenToString(start))
+	}
+
+	return nil
+}
+
+// Read the properties in element start.
+func (d *XMLDecoder) readProperties(start xml.StartElement) (*propertyReader, error) {
+	r := newPropertyReader(d.framework)
+	for _, attr := range start.Attr {
+		r.values[attr.Name.Local] = attr.Value
+	}
+
+	if err := d.DecodeUntil(start.End(), func(d *XMLDecoder, s xml.StartElement) error {
+		switch s.Name {
+		case XMLName(XMLStringChild):
+			child, err := d.DecodeNode(s)
+			if err != nil {
+				return errors.WithMessage(err, "Unmarshal child")
+			}
+			r.child = child
+			return nil
+
+		case XMLName(XMLStringChilds):
+			return d.readChildProperties(r, s)
+
+		default:
+			var value string
+			if err := d.decodeStdElement(&value, s); err != nil {
+				return errors.WithMessagef(err, "Unmarshal %s", XMLNameToString(s.Name))
+			}
+			r.values[s.Name.Local] = value
+			return nil
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return r, d.Skip()
+}
+
+// Read the children in element start to r.
+func (d *XMLDecoder) readChildProperties(r *propertyReader, start xml.StartElement) error {
+	childCount := -1
+	for _, attr := range start.Attr {
+		if attr.Name == XMLName("count") {
+			var err error
+			if childCount, err = strconv.Atoi(attr.Value); err != nil {
+				return errors.WithMessage(err, "Unmarshal child count")
+			} else if childCount < 0 {
+				return fmt.Errorf("invalid child count: %d", childCount)
+			}
+		}
+	}
+
+	if err := d.DecodeAtUntil(XMLName(XMLStringChild), start.End(), func(d *XMLDecoder, s xml.StartElement) error {
+		if childCount >= 0 && len(r.children) >= childCount {
+			return errors.New("too many children")
+		}
+
+		props := newPropertyReader(d.framework)
+		for _, attr := range s.Attr {
+			props.values[attr.Name.Local] = attr.Value
+		}
+
+		node, err := d.DecodeNode(s)
+		if err != nil {
+			return err
+		}
+
+		r.addChild(node, props)
+		return nil
+	}); err != nil {
+		return errors.WithMessagef(err, "Unmarshal No.%d child", len(r.children))
+	}
+
+	if len(r.children) < childCount {
+		return errors.New("too few children")
 	}
+
+	return d.Skip()
 }
 
 // DecodeAt search the start element with name at. If the
@@ -423,11 +837,33 @@ func (d *XMLDecoder) DecodeElementUntil(v interface{}, name xml.Name, until xml.
 }
 
 // DecodeNode decode the node type from start, then create the node
-// with the type to decode into it.
+// with the type to decode into it. If the node failed to decode, the
+// error is collected and the element is skipped, then a placeholder
+// node is returned to continue decoding. The errors collected are
+// returned when the decoding finished.
 func (d *XMLDecoder) DecodeNode(start xml.StartElement) (Node, error) {
+	depth, offset := len(d.starts), d.startOffset()
+
+	node, err := d.decodeNode(start)
+	if err == nil {
+		return node, nil
+	}
+
+	de := d.errorAt(offset, err)
+	if d.skipTo(depth) != nil {
+		return nil, de
+	}
+
+	d.errs = append(d.errs, de)
+	return new(badNode), nil
+}
+
+func (d *XMLDecoder) decodeNode(start xml.StartElement) (Node, error) {
 	nodeTypeXMLName := XMLName(XMLStringNodeType)
+	idXMLName := XMLName(XMLStringID)
 	commentXMLName := XMLName(XMLStringComment)
 	var node Node
+	var id NodeID
 	var comment string
 	for _, attr := range start.Attr {
 		if attr.Name == nodeTypeXMLName {
@@ -436,6 +872,11 @@ func (d *XMLDecoder) DecodeNode(start xml.StartElement) (Node, error) {
 			} else {
 				return nil, XMLTokenError(start, err)
 			}
+		} else if attr.Name == idXMLName {
+			var err error
+			if id, err = unmarshalNodeIDAttr(attr); err != nil {
+				return nil, XMLTokenError(start, err)
+			}
 		} else if attr.Name == commentXMLName {
 			comment = attr.Value
 		}
@@ -445,6 +886,7 @@ func (d *XMLDecoder) DecodeNode(start xml.StartElement) (Node, error) {
 		return nil, XMLTokenError(start, XMLAttrNotFoundError(nodeTypeXMLName))
 	}
 
+	d.ids.set(node, id)
 	node.SetComment(comment)
 
 	if err := d.DecodeElement(node, start); err != nil {
@@ -497,6 +939,15 @@ func (d *XMLDecoder) unmarshalNodeTypeAttr(attr xml.Attr) (NodeType, error) {
 	}
 }
 
+// Unmarshal xml.Attr attr as NodeID.
+func unmarshalNodeIDAttr(attr xml.Attr) (NodeID, error) {
+	if id, err := strconv.ParseUint(attr.Value, 10, 32); err != nil {
+		return NodeID(0), errors.WithMessage(err, "Unmarshal node id")
+	} else {
+		return NodeID(id), nil
+	}
+}
+
 // Unmarshal xml.Attr attr as BevType.
 func (d *XMLDecoder) unmarshalBevTypeAttr(attr xml.Attr) (BevType, error) {
 	bt := BevType(attr.Value)
@@ -537,10 +988,7 @@ func UnmarshalXMLTree(framework *Framework, data []byte, t *tree) error {
 		return nil
 	}
 
-	var buf = bytes.NewReader(data)
-	d := newXMLDecoder(framework, buf)
-
-	if err := d.DecodeElementAt(t, XMLName(XMLStringTree)); err != nil {
+	if err := decodeXMLTree(framework, bytes.NewReader(data), "", t); err != nil {
 		return errors.WithMessagef(err, "unmarshal xml tree")
 	}
 
@@ -569,17 +1017,21 @@ func EncodeXMLTreeFile(framework *Framework, path string, t *tree) (err error) {
 		}
 	}()
 
-	enc := newXMLEncoder(framework, file)
-	enc.Indent("", indent)
-
-	start := xml.StartElement{Name: XMLName(XMLStringTree)}
-	if err := enc.EncodeElement(t, start); err != nil {
+	if err := encodeXMLTree(framework, file, t); err != nil {
 		return errors.WithMessagef(err, "encode xml tree file: \"%s\"", path)
 	}
 
 	return nil
 }
 
+func encodeXMLTree(framework *Framework, w io.Writer, t *tree) error {
+	enc := newXMLEncoder(framework, w)
+	enc.Indent("", indent)
+
+	start := xml.StartElement{Name: XMLName(XMLStringTree)}
+	return enc.EncodeElement(t, start)
+}
+
 func DecodeXMLTreeFile(framework *Framework, path string, t *tree) error {
 	if framework == nil {
 		return errors.New("decode xml tree file: framework nil")
@@ -595,15 +1047,57 @@ func DecodeXMLTreeFile(framework *Framework, path string, t *tree) error {
 	}
 	defer file.Close()
 
-	dec := newXMLDecoder(framework, file)
+	if err := decodeXMLTree(framework, file, path, t); err != nil {
+		return errors.WithMessagef(err, "decode xml tree file: \"%s\"", path)
+	}
+
+	return nil
+}
+
+// DecodeXMLTreeFS works like DecodeXMLTreeFile but read the file
+// from fsys.
+func DecodeXMLTreeFS(framework *Framework, fsys fs.FS, path string, t *tree) error {
+	if framework == nil {
+		return errors.New("decode xml tree file: framework nil")
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	if err := dec.DecodeElementAt(t, XMLName(XMLStringTree)); err != nil {
+	if err := decodeXMLTree(framework, file, path, t); err != nil {
 		return errors.WithMessagef(err, "decode xml tree file: \"%s\"", path)
 	}
 
 	return nil
 }
 
+// Decode the tree from r, the older versions are migrated before
+// decoding.
+func decodeXMLTree(framework *Framework, r io.Reader, path string, t *tree) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	migrated, source, err := framework.migrateXML(data, XMLStringTree, framework.treeMigrations, path)
+	if err != nil {
+		return err
+	}
+
+	dec := newXMLDecoder(framework, migrated)
+	dec.path = path
+	dec.source = source
+
+	return dec.finish(dec.DecodeElementAt(t, XMLName(XMLStringTree)))
+}
+
 // MarshalXMLTree return an bevtree XML encoding of t.
 func (f *Framework) MarshalXMLTree(t *tree) ([]byte, error) {
 	if data, err := MarshalXMLTree(f, t); err != nil {
@@ -643,10 +1137,18 @@ func (f *Framework) DecodeXMLTreeFile(path string, t *tree) error {
 	}
 }
 
-func (t *tree) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("Tree.MarshalBTXML start:%v", start)
+// DecodeXMLTreeFS works like DecodeXMLTreeFile but read
+// the file from fsys.
+func (f *Framework) DecodeXMLTreeFS(fsys fs.FS, path string, t *tree) error {
+	if err := DecodeXMLTreeFS(f, fsys, path, t); err != nil {
+		return errors.WithMessage(err, "framework")
+	} else {
+		return nil
 	}
+}
+
+func (t *tree) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
+	e.logStart("Tree", start)
 
 	if t.name == "" {
 		return errors.New("Tree has no name")
@@ -660,9 +1162,19 @@ func (t *tree) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
 		start.Attr = append(start.Attr, xml.Attr{Name: XMLName(XMLStringComment), Value: t.comment})
 	}
 
+	start.Attr = append(start.Attr, xml.Attr{Name: XMLName(XMLStringVersion), Value: strconv.Itoa(e.framework.TreeXMLVersion())})
+
+	// The missing IDs are assigned in the encoding only.
+	ids, _, err := t.completeNodeIDs()
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Marshal", XMLTokenToString(start))
+	}
+	e.ids = ids
+
 	if err := e.EncodeSE(start, func(x *XMLEncoder) error {
 		rootStart := xml.StartElement{Name: XMLName(XMLStringRoot)}
-		if err := e.EncodeElementSE(t._root, rootStart); err != nil {
+		rootStart.Attr = append(rootStart.Attr, xml.Attr{Name: XMLName(XMLStringID), Value: ids[t._root].String()})
+		if err := e.EncodeElement(t._root, rootStart); err != nil {
 			return errors.WithMessagef(err, "Marshal root")
 		}
 
@@ -675,9 +1187,7 @@ func (t *tree) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
 }
 
 func (t *tree) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("Tree.UnmarshalBTXML start:%v", start)
-	}
+	d.logStart("Tree", start)
 
 	for _, attr := range start.Attr {
 		if attr.Name == XMLName(XMLStringName) {
@@ -687,6 +1197,13 @@ func (t *tree) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
 		}
 	}
 
+	// The older versions are migrated before decoding.
+	if version, err := xmlVersionOf(start.Attr); err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal", XMLTokenToString(start))
+	} else if version != d.framework.TreeXMLVersion() {
+		return errors.Errorf("Tree %s Unmarshal: version %d, but %d required", XMLTokenToString(start), version, d.framework.TreeXMLVersion())
+	}
+
 	if t.name == "" {
 		return errors.New("tree has no name")
 	}
@@ -695,464 +1212,47 @@ func (t *tree) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
 		t._root = newRootNode()
 	}
 
-	if err := d.DecodeElementAt(t._root, XMLName(XMLStringRoot)); err != nil {
-		return errors.WithMessagef(err, "Tree %s Unmarshal root", XMLTokenToString(start))
-	}
-
-	return d.Skip()
-}
-
-func (r *rootNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("rootNode.MarshalBTXML start:%v", start)
-	}
-
-	if r.child == nil {
-		return nil
-	}
-
-	if err := e.EncodeNode(r.child, xml.StartElement{Name: XMLName(XMLStringChild)}); err != nil {
-		return errors.WithMessage(err, "rootNode Marshal child")
-	}
-
-	return nil
-}
-
-func (r *rootNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("rootNode.UnmarshalBTXML start:%v", start)
-	}
-
-	var child Node
-	if err := d.DecodeNodeUntil(&child, XMLName(XMLStringChild), start.End()); err != nil {
-		return errors.WithMessage(err, "rootNode Unmarshal child")
-	}
-
-	if child != nil {
-		r.SetChild(child)
-	}
-
-	return d.Skip()
-}
-
-func (d *decoratorNode) marshalXML(e *XMLEncoder) error {
-	if d.child == nil {
-		return nil
-	}
-
-	if err := e.EncodeNode(d.child, xml.StartElement{Name: XMLName(XMLStringChild)}); err != nil {
-		return errors.WithMessage(err, "Marshal child")
-	}
-
-	return nil
-}
-
-func (d *decoratorNode) unmarshalXML(dec *XMLDecoder, start xml.StartElement) error {
-	var child Node
-	if err := dec.DecodeNodeUntil(&child, XMLName(XMLStringChild), start.End()); err != nil {
-		return errors.WithMessage(err, "Unmarshal child")
-	}
-
-	if child != nil {
-		d.setChild(child)
-	}
-
-	return nil
-}
-
-func (i *InverterNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("InverterNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return i.decoratorNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "InverterNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (i *InverterNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("InverterNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := i.decoratorNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "InverterNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	if i.child != nil {
-		i.child.SetParent(i)
-	}
-
-	return d.Skip()
-}
-
-func (s *SucceederNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SucceederNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return s.decoratorNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "SucceederNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (s *SucceederNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SucceederNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := s.decoratorNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "SucceederNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	if s.child != nil {
-		s.child.SetParent(s)
-	}
-
-	return d.Skip()
-}
-
-func (r *RepeaterNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RepeaterNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-
-		if err := e.EncodeElement(r.limited, xml.StartElement{Name: XMLName(XMLStringLimited)}); err != nil {
-			return errors.WithMessage(err, "Marshal limited")
-		}
-
-		return r.decoratorNode.marshalXML(e)
-
-	}); err != nil {
-		return errors.WithMessagef(err, "RepeaterNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (r *RepeaterNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RepeaterNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := d.DecodeElementAt(&r.limited, XMLName(XMLStringLimited)); err != nil {
-		return errors.WithMessagef(err, "RepeaterNode %s Unmarshal limited", XMLTokenToString(start))
-	}
-
-	if err := r.decoratorNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "RepeaterNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	if r.child != nil {
-		r.child.SetParent(r)
-	}
-
-	return d.Skip()
-}
-
-func (r *RepeatUntilFailNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RepeatUntilFailNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-
-		if err := e.EncodeElement(r.successOnFail, xml.StartElement{Name: XMLName(XMLStringSuccessOnFail)}); err != nil {
-			return errors.WithMessage(err, "Marshal successOnFail")
-		}
-
-		return r.decoratorNode.marshalXML(e)
-
-	}); err != nil {
-		return errors.WithMessagef(err, "RepeatUntilFailNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (r *RepeatUntilFailNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RepeatUntilFailNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := d.DecodeElementAt(&r.successOnFail, XMLName(XMLStringSuccessOnFail)); err != nil {
-		return errors.WithMessagef(err, "RepeatUntilFailNode %s Unmarshal successOnFail", XMLTokenToString(start))
-	}
-
-	if err := r.decoratorNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "RepeatUntilFailNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	if r.child != nil {
-		r.child.SetParent(r)
-	}
-
-	return d.Skip()
-}
-
-func (c *compositeNode) marshalXML(e *XMLEncoder) error {
-	childCount := c.ChildCount()
-	if childCount > 0 {
-		var err error
-
-		childsStart := xml.StartElement{Name: XMLName(XMLStringChilds)}
-		childsStart.Attr = append(childsStart.Attr, xml.Attr{Name: XMLName("count"), Value: strconv.Itoa(childCount)})
-
-		if err = e.EncodeToken(childsStart); err != nil {
-			return err
-		}
-
-		for i := 0; i < childCount; i++ {
-			child := c.Child(i)
-			if err = e.EncodeNode(child, xml.StartElement{Name: XMLName(XMLStringChild)}); err != nil {
-				return errors.WithMessagef(err, "Marshal No.%d child", i)
-			}
-		}
-
-		if err = e.EncodeToken(childsStart.End()); err != nil {
-			return err
-		}
-
-		return nil
-	}
-
-	return nil
-}
-
-func (c *compositeNode) unmarshalXML(d *XMLDecoder, start xml.StartElement) error {
-	if err := d.DecodeAtUntil(XMLName(XMLStringChilds), start.End(), func(d *XMLDecoder, s xml.StartElement) error {
-		xmlCountName := XMLName("count")
-		var childCount int
-		var childs []Node
+	d.ids = nodeIDs{}
+	if err := d.DecodeAt(XMLName(XMLStringRoot), func(d *XMLDecoder, s xml.StartElement) error {
 		for _, attr := range s.Attr {
-			if attr.Name == xmlCountName {
-				var err error
-				if childCount, err = strconv.Atoi(attr.Value); err != nil {
-					return errors.WithMessage(err, "Unmarshal child count")
-				} else if childCount <= 0 {
-					return fmt.Errorf("invalid child count: %d", childCount)
-				} else {
-					childs = make([]Node, 0, childCount)
-					break
-				}
-			}
-		}
-
-		if childCount > 0 {
-			xmlChildName := XMLName(XMLStringChild)
-			if err := d.DecodeAtUntil(xmlChildName, s.End(), func(d *XMLDecoder, s xml.StartElement) error {
-				if len(childs) >= childCount {
-					return errors.New("too many children")
-				}
-
-				if node, err := d.DecodeNode(s); err != nil {
+			if attr.Name == XMLName(XMLStringID) {
+				id, err := unmarshalNodeIDAttr(attr)
+				if err != nil {
 					return err
-				} else {
-					childs = append(childs, node)
-					return nil
 				}
-
-			}); err != nil {
-				return errors.WithMessagef(err, "Unmarshal No.%d child", len(childs))
-			}
-
-			if len(childs) < childCount {
-				return errors.New("too few children")
+				d.ids.set(t._root, id)
 			}
 		}
 
-		c.children = childs
-
-		if err := d.Skip(); err != nil {
-			return err
-		}
-
-		return ErrXMLDecodeStop
-
-	}); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (s *SequenceNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SequenceNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return s.compositeNode.marshalXML(e)
+		return d.DecodeElement(t._root, s)
 	}); err != nil {
-		return errors.WithMessagef(err, "SequenceNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (s *SequenceNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SequenceNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := s.compositeNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "SequenceNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	for _, v := range s.children {
-		v.SetParent(s)
-	}
-
-	return d.Skip()
-}
-
-func (s *SelectorNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SelectorNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return s.compositeNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "SelectorNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (s *SelectorNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SelectorNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := s.compositeNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "SelectorNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	for _, v := range s.children {
-		v.SetParent(s)
-	}
-
-	return d.Skip()
-}
-
-func (r *RandSequenceNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RandSequenceNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return r.compositeNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "RandSequenceNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (r *RandSequenceNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RandSequenceNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := r.compositeNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "RandSequenceNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	for _, v := range r.children {
-		v.SetParent(r)
-	}
-
-	return d.Skip()
-}
-
-func (r *RandSelectorNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RandSelectorNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return r.compositeNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "RandSelectorNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (r *RandSelectorNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("RandSelectorNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := r.compositeNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "RandSelectorNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	for _, v := range r.children {
-		v.SetParent(r)
-	}
-
-	return d.Skip()
-}
-
-func (p *ParallelNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("ParallelNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(e *XMLEncoder) error {
-		return p.compositeNode.marshalXML(e)
-	}); err != nil {
-		return errors.WithMessagef(err, "ParallelNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (p *ParallelNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("ParallelNode.MarshalBTXML start:%v", start)
+		return errors.WithMessagef(err, "Tree %s Unmarshal root", XMLTokenToString(start))
 	}
 
-	if err := p.compositeNode.unmarshalXML(d, start); err != nil {
-		return errors.WithMessagef(err, "ParallelNode %s Unmarshal", XMLTokenToString(start))
+	// Generate IDs for the nodes without ID.
+	t.ids = d.ids
+	missing, err := t.assignMissingNodeIDs()
+	if err != nil {
+		return errors.WithMessagef(err, "Tree %s Unmarshal", XMLTokenToString(start))
 	}
 
-	for _, v := range p.children {
-		v.SetParent(p)
+	// Not to log for the tree failed to decode.
+	if missing > 0 && len(d.errs) == 0 {
+		d.log(LogInfo, "node IDs generated", LogField{Key: LogKeyTree, Value: t.name}, LogField{Key: "count", Value: missing})
 	}
 
 	return d.Skip()
 }
 
 func (b *BevNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("BevNode.MarshalBTXML start:%v", start)
-	}
+	e.logStart("BevNode", start)
 
 	var err error
 	var bevTypeAttr xml.Attr
 	if bevTypeAttr, err = e.marshalBevTypeAttr(b.bev.BevType(), XMLName(XMLStringBevType)); err == nil {
 		start.Attr = append(start.Attr, bevTypeAttr)
 
-		switch o := b.bev.(type) {
-		case XMLMarshaler:
-			err = o.MarshalBTXML(e, start)
-		default:
-			err = e.Encoder.EncodeElement(b.bev, start)
-		}
-
+		err = e.EncodeElement(b.bev, start)
 	}
 
 	if err != nil {
@@ -1163,9 +1263,7 @@ func (b *BevNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
 }
 
 func (b *BevNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("BevNode.UnmarshalBTXML start:%v", start)
-	}
+	d.logStart("BevNode", start)
 
 	var err error
 	var bev Bev
@@ -1192,160 +1290,3 @@ func (b *BevNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
 		return nil
 	}
 }
-
-func (s *SubtreeNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SubtreeNode.MarshalBTXML start:%v", start)
-	}
-
-	start.Attr = append(start.Attr, xml.Attr{Name: XMLName(XMLStringSubtree), Value: s.subtree.Name()})
-
-	if err := e.EncodeSE(start, nil); err != nil {
-		return errors.WithMessagef(err, "SubtreeNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (s *SubtreeNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("SubtreeNode.UnmarshalBTXML start:%v", start)
-	}
-
-	for _, attr := range start.Attr {
-		if attr.Name == XMLName(XMLStringSubtree) {
-			subtree, err := d.Framework().getOrLoadTree(attr.Value)
-			if err != nil {
-				return errors.WithMessagef(err, "SubtreeNode %s Unmarshal", XMLTokenToString(start))
-			} else if subtree == nil {
-				return errors.Errorf("SubtreeNode %s Unmarshal: subtree \"%s\" not exsit", XMLTokenToString(start), attr.Value)
-			} else {
-				s.subtree = subtree
-				break
-			}
-		}
-	}
-
-	if s.subtree == nil {
-		return errors.Errorf("SubtreeNode %s Unmarshal: attr subtree not exist", XMLTokenToString(start))
-	}
-
-	return d.Skip()
-}
-
-func (n *WeightSelectorNode) MarshalBTXML(e *XMLEncoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("WeightSelectorNode.MarshalBTXML start:%v", start)
-	}
-
-	if err := e.EncodeSE(start, func(x *XMLEncoder) error {
-		childCount := n.ChildCount()
-		if childCount == 0 {
-			return nil
-		}
-
-		childsStart := xml.StartElement{Name: XMLName(XMLStringChilds)}
-		childsStart.Attr = append(childsStart.Attr, xml.Attr{Name: XMLName("count"), Value: strconv.Itoa(childCount)})
-
-		if err := e.EncodeToken(childsStart); err != nil {
-			return err
-		}
-
-		for i := 0; i < childCount; i++ {
-			child, weight := n.Child(i)
-			childStart := xml.StartElement{Name: XMLName(XMLStringChild)}
-			childStart.Attr = append(childStart.Attr, xml.Attr{Name: XMLName("weight"), Value: strconv.FormatFloat(float64(weight), 'f', -1, 32)})
-			if err := e.EncodeNode(child, childStart); err != nil {
-				return errors.WithMessagef(err, "Marshal No.%d child", i)
-			}
-		}
-
-		if err := e.EncodeToken(childsStart.End()); err != nil {
-			return err
-		}
-
-		return nil
-
-	}); err != nil {
-		return errors.WithMessagef(err, "WeightSelectorNode %s Marshal", XMLTokenToString(start))
-	}
-
-	return nil
-}
-
-func (n *WeightSelectorNode) UnmarshalBTXML(d *XMLDecoder, start xml.StartElement) error {
-	if debug {
-		log.Printf("WeightSelectorNode.UnmarshalBTXML start:%v", start)
-	}
-
-	if err := d.DecodeAtUntil(XMLName(XMLStringChilds), start.End(), func(d *XMLDecoder, s xml.StartElement) error {
-		xmlCountName := XMLName("count")
-		var childCount int
-		var childs []*weightNode
-		for _, attr := range s.Attr {
-			if attr.Name == xmlCountName {
-				var err error
-				if childCount, err = strconv.Atoi(attr.Value); err != nil {
-					return errors.WithMessage(err, "Unmarshal child count")
-				} else if childCount <= 0 {
-					return fmt.Errorf("invalid child count: %d", childCount)
-				} else {
-					childs = make([]*weightNode, 0, childCount)
-					break
-				}
-			}
-		}
-
-		if childCount > 0 {
-			xmlChildName := XMLName(XMLStringChild)
-			if err := d.DecodeAtUntil(xmlChildName, s.End(), func(d *XMLDecoder, s xml.StartElement) error {
-				if len(childs) >= childCount {
-					return errors.New("too many children")
-				}
-
-				var weight = math.NaN()
-				xmlNameWeight := XMLName("weight")
-				for _, attr := range s.Attr {
-					if attr.Name == xmlNameWeight {
-						if w, err := strconv.ParseFloat(attr.Value, 32); err != nil {
-							return errors.WithMessagef(err, "Unmarshal attr weight")
-						} else {
-							weight = w
-						}
-					}
-				}
-
-				if math.IsNaN(weight) {
-					return errors.New("attr weight not found")
-				}
-
-				if node, err := d.DecodeNode(s); err != nil {
-					return err
-				} else {
-					childs = append(childs, &weightNode{node: node, weight: float32(weight)})
-					return nil
-				}
-
-			}); err != nil {
-				return errors.WithMessagef(err, "Unmarshal No.%d child", len(childs))
-			}
-
-			if len(childs) < childCount {
-				return errors.New("too few children")
-			}
-		}
-
-		n.children = childs
-
-		if err := d.Skip(); err != nil {
-			return err
-		}
-
-		return ErrXMLDecodeStop
-
-	}); err != nil {
-		return errors.WithMessagef(err, "WeightSelectorNode %s Unmarshal", XMLTokenToString(start))
-	}
-
-	return d.Skip()
-}
@@ -2,8 +2,12 @@ package bevtree
 
 import (
 	"math/rand"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 func TestTreeMarshalXML(t *testing.T) {
@@ -170,6 +174,17 @@ func TestTreeMarshalXML(t *testing.T) {
 	if err := framework.UnmarshalXMLTree(data, newTree); err != nil {
 		t.Fatal("unmarshal previos Tree:", err)
 	}
+	ids, _, _ := oldTree.completeNodeIDs()
+	walkNode(oldTree.Root(), func(n Node) bool {
+		if oldTree.NodeID(n).Valid() {
+			t.Fatalf("node %s assigned id on marshaling", NodePath(n))
+		}
+		if found := newTree.NodeByID(ids[n]); found == nil || NodePath(found) != NodePath(n) {
+			t.Fatalf("node %s id %s not kept after unmarshal", NodePath(n), ids[n])
+		}
+		return true
+	})
+
 	newTree.SetName("XML测试2")
 	framework.addTree(newTree)
 
@@ -187,3 +202,60 @@ func TestTreeMarshalXML(t *testing.T) {
 	}
 
 }
+
+func TestXMLDecodeErrors(t *testing.T) {
+	data := `<bevtree name="errors" comment="错误">
+    <root>
+        <child nodetype="parallel">
+            <childs count="4">
+                <child nodetype="unknown"></child>
+                <child nodetype="repeater"><limited>x</limited></child>
+                <child nodetype="sequence"></child>
+                <child nodetype="behavior" bevtype="blackboardIncr"><Key>k</Key><Limited>y</Limited></child>
+            </childs>
+        </child>
+    </root>
+</bevtree>`
+
+	syntaxData := `<bevtree name="syntax">
+    <root>
+        <child nodetype="inverter"><child nodetype="sequence"></child>
+    </root>
+</bevtree>`
+
+	fsys := fstest.MapFS{
+		"errors.xml": &fstest.MapFile{Data: []byte(data)},
+		"syntax.xml": &fstest.MapFile{Data: []byte(syntaxData)},
+	}
+
+	migrated := newTestFramework()
+	migrated.treeMigrations = []XMLMigration{func(*XMLElement) error { return nil }}
+
+	for name, framework := range map[string]*Framework{"latest": newTestFramework(), "migrated": migrated} {
+		err := framework.DecodeXMLTreeFS(fsys, "errors.xml", new(tree))
+
+		var errs DecodeErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: error %v is not DecodeErrors", name, err)
+		}
+
+		if len(errs) != 3 {
+			t.Fatalf("%s: %d errors, want 3:\n%v", name, len(errs), err)
+		}
+
+		for i, pos := range []string{"errors.xml:5:17: ", "errors.xml:6:17: ", "errors.xml:8:17: "} {
+			if !strings.HasPrefix(errs[i].Error(), pos) {
+				t.Fatalf("%s: error %d %q, want position %s", name, i, errs[i], pos)
+			}
+		}
+
+		if errs[0].Line != 5 || errs[0].Column != 17 || data[errs[0].Offset:][:6] != "<child" {
+			t.Fatalf("%s: error position %+v", name, errs[0])
+		}
+
+		err = framework.DecodeXMLTreeFS(fsys, "syntax.xml", new(tree))
+		if !errors.As(err, &errs) || len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "syntax.xml:4:") {
+			t.Fatalf("%s: syntax error %v", name, err)
+		}
+	}
+}